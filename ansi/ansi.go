@@ -0,0 +1,258 @@
+// ANSI terminal backend of compositor
+// Writes composed cells to terminal as escape sequences
+package ansi
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+var (
+	ErrNoInput = errors.New("ansi: terminal has no input")
+	ErrClosed  = errors.New("ansi: terminal closed")
+)
+
+// Cell set since previous flush
+type pending struct {
+	x, y int
+	cell fws.Cell
+}
+
+// ANSI terminal screen, compositor backend writing escape sequences.
+// Implements fws.Screen, compositor.Backend and compositor.Linker.
+// Size is not queried from terminal, it is set by Width and Height
+type Terminal struct {
+	OutputMode    fws.OutputMode // Terminal palette, OutputCurrent is treated as OutputRGB
+	Hyperlinks    bool           // Terminal supports OSC 8 hyperlinks, server can offer fws.CapHyperlinks
+	Width, Height int
+
+	w     io.Writer
+	cells []pending
+	links map[uint32]string // URIs of links set since previous flush
+
+	mu     sync.Mutex
+	in     io.Reader
+	input  *bufio.Reader
+	closed bool
+}
+
+// Terminal writing to w and decoding key and mouse input from in,
+// which may be nil
+func New(w io.Writer, in io.Reader) *Terminal {
+	t := &Terminal{w: w, in: in, links: make(map[uint32]string)}
+	if in != nil {
+		t.input = bufio.NewReader(in)
+	}
+	return t
+}
+
+func (t *Terminal) Size() (int, int) {
+	return t.Width, t.Height
+}
+
+func (t *Terminal) Mode() fws.OutputMode {
+	return t.OutputMode
+}
+
+// Waits for key or mouse input. Input errors are reported as EventError
+func (t *Terminal) PollEvent() fws.Event {
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	switch {
+	case closed:
+		return fws.Event{Type: fws.EventError, Err: ErrClosed}
+	case t.input == nil:
+		return fws.Event{Type: fws.EventError, Err: ErrNoInput}
+	}
+	ev, err := readEvent(t.input)
+	if err != nil {
+		return fws.Event{Type: fws.EventError, Err: err}
+	}
+	return ev
+}
+
+// Resets style and closes input if it is io.Closer,
+// which interrupts PollEvent
+func (t *Terminal) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+	_, err := io.WriteString(t.w, "\x1b[0m")
+	if c, ok := t.in.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (t *Terminal) SetCell(x, y int, cell fws.Cell) {
+	t.cells = append(t.cells, pending{x, y, cell})
+}
+
+// Sets URI of link for cells set until next flush
+func (t *Terminal) SetLink(link uint32, uri string) {
+	t.links[link] = uri
+}
+
+// Writes cells set since previous flush row by row.
+// Continuation cells are skipped, they are drawn by wide glyph
+func (t *Terminal) Flush() error {
+	sort.SliceStable(t.cells, func(i, j int) bool {
+		a, b := t.cells[i], t.cells[j]
+		return a.y < b.y || (a.y == b.y && a.x < b.x)
+	})
+	var buf []byte
+	x, y := -1, -1
+	style, styled := fws.Cell{}, false
+	var link uint32
+	for _, p := range t.cells {
+		cell := p.cell
+		if cell.Continuation {
+			continue
+		}
+		if p.x != x || p.y != y {
+			buf = append(buf, "\x1b["...)
+			buf = strconv.AppendInt(buf, int64(p.y+1), 10)
+			buf = append(buf, ';')
+			buf = strconv.AppendInt(buf, int64(p.x+1), 10)
+			buf = append(buf, 'H')
+		}
+		if key := styleOf(cell); !styled || key != style {
+			buf = t.sgr(buf, cell)
+			style, styled = key, true
+		}
+		if t.Hyperlinks && cell.Link != link {
+			uri := t.links[cell.Link]
+			if !fws.Printable(uri) {
+				// Control characters would end OSC 8 and run as commands
+				uri = ""
+			}
+			buf = osc8(buf, cell.Link, uri)
+			link = cell.Link
+		}
+		switch {
+		case cell.Grapheme != "" && fws.Printable(cell.Grapheme):
+			buf = append(buf, cell.Grapheme...)
+		case cell.Grapheme != "":
+			// Blank of glyph width keeps cursor position in sync
+			for i := 0; i < cell.Width(); i++ {
+				buf = append(buf, ' ')
+			}
+		case !fws.Printable(string(cell.Ch)):
+			// Empty cell, control characters would move cursor
+			buf = append(buf, ' ')
+		default:
+			buf = append(buf, string(cell.Ch)...)
+		}
+		x, y = p.x+cell.Width(), p.y
+	}
+	if link != 0 {
+		buf = osc8(buf, 0, "")
+	}
+	if styled {
+		buf = append(buf, "\x1b[0m"...)
+	}
+	t.cells = t.cells[:0]
+	t.links = make(map[uint32]string)
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := t.w.Write(buf)
+	return err
+}
+
+// Cell fields written with SGR
+func styleOf(cell fws.Cell) fws.Cell {
+	return fws.Cell{Fg: cell.Fg, Bg: cell.Bg, Attribute: cell.Attribute, UnderlineColor: cell.UnderlineColor}
+}
+
+// Opens link, closes previous one if URI is empty
+func osc8(buf []byte, link uint32, uri string) []byte {
+	buf = append(buf, "\x1b]8;"...)
+	if uri != "" {
+		buf = append(buf, "id="...)
+		buf = strconv.AppendUint(buf, uint64(link), 10)
+	}
+	buf = append(buf, ';')
+	buf = append(buf, uri...)
+	return append(buf, "\x1b\\"...)
+}
+
+// SGR codes of attributes, underline is replaced by underline styles
+var attrCodes = []struct {
+	attr fws.Attr
+	code string
+}{
+	{fws.Bold, "1"},
+	{fws.Dim, "2"},
+	{fws.Cursive, "3"},
+	{fws.Blink, "5"},
+	{fws.Reverse, "7"},
+	{fws.Hidden, "8"},
+	{fws.Strikethrough, "9"},
+}
+
+// Resets style and sets cell attributes and colors
+func (t *Terminal) sgr(buf []byte, cell fws.Cell) []byte {
+	buf = append(buf, "\x1b[0"...)
+	for _, a := range attrCodes {
+		if cell.Attribute&a.attr != 0 {
+			buf = append(buf, ';')
+			buf = append(buf, a.code...)
+		}
+	}
+	switch {
+	case cell.Attribute&fws.CurlyUnderline != 0:
+		buf = append(buf, ";4:3"...)
+	case cell.Attribute&fws.DoubleUnderline != 0:
+		buf = append(buf, ";4:2"...)
+	case cell.Attribute&fws.Underline != 0:
+		buf = append(buf, ";4"...)
+	}
+	buf = t.color(buf, cell.Fg, 30)
+	buf = t.color(buf, cell.Bg, 40)
+	if t.OutputMode != fws.OutputNormal {
+		buf = t.color(buf, cell.UnderlineColor, 50)
+	}
+	return append(buf, 'm')
+}
+
+// Appends SGR color parameter, base is 30 for foreground, 40 for background
+// and 50 for underline. Transparent colors are left default
+func (t *Terminal) color(buf []byte, c fws.Color, base int) []byte {
+	if c.A == 0 {
+		return buf
+	}
+	buf = append(buf, ';')
+	switch t.OutputMode {
+	case fws.OutputCurrent, fws.OutputRGB:
+		buf = strconv.AppendInt(buf, int64(base+8), 10)
+		for _, v := range []uint8{2, c.R, c.G, c.B} {
+			buf = append(buf, ';')
+			buf = strconv.AppendUint(buf, uint64(v), 10)
+		}
+		return buf
+	case fws.OutputNormal:
+		n := c.To16Mode()
+		if n >= 8 {
+			// Bright colors are 90-97 and 100-107
+			base, n = base+60, n-8
+		}
+		return strconv.AppendInt(buf, int64(base+n), 10)
+	}
+	buf = strconv.AppendInt(buf, int64(base+8), 10)
+	buf = append(buf, ";5;"...)
+	return strconv.AppendInt(buf, int64(c.Index(t.OutputMode)), 10)
+}
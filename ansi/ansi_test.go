@@ -0,0 +1,112 @@
+package ansi
+
+import (
+	"bytes"
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/Nekhaevalex/fwsprotocol/compositor"
+)
+
+var (
+	_ compositor.Backend = (*Terminal)(nil)
+	_ compositor.Linker  = (*Terminal)(nil)
+	_ fws.Screen         = (*Terminal)(nil)
+)
+
+var (
+	white = fws.Color{A: 255, R: 255, G: 255, B: 255}
+	red   = fws.Color{A: 255, R: 255}
+)
+
+func flush(t *testing.T, term *Terminal, buf *bytes.Buffer) string {
+	t.Helper()
+	buf.Reset()
+	if err := term.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestFlush(t *testing.T) {
+	var buf bytes.Buffer
+	term := New(&buf, nil)
+	term.SetCell(1, 0, fws.Cell{Ch: 'b', Fg: white, Bg: red})
+	term.SetCell(0, 0, fws.Cell{Ch: 'a', Fg: white, Bg: red})
+	term.SetCell(3, 0, fws.Cell{Ch: '世', Attribute: fws.Bold | fws.CurlyUnderline, UnderlineColor: red})
+	term.SetCell(4, 0, fws.Cell{Continuation: true})
+	term.SetCell(5, 0, fws.Cell{Ch: 'e', Grapheme: "e\u0301"})
+	term.SetCell(0, 2, fws.Cell{})
+	expected := "\x1b[1;1H\x1b[0;38;2;255;255;255;48;2;255;0;0mab" +
+		"\x1b[1;4H\x1b[0;1;4:3;58;2;255;0;0m世" +
+		"\x1b[0me\u0301" +
+		"\x1b[3;1H \x1b[0m"
+	if got := flush(t, term, &buf); got != expected {
+		t.Errorf("Flushed output:\nexpected %q\ngot      %q\n", expected, got)
+	}
+	if got := flush(t, term, &buf); got != "" {
+		t.Errorf("Empty flush: got %q\n", got)
+	}
+}
+
+func TestFlushModes(t *testing.T) {
+	cell := fws.Cell{Ch: 'x', Fg: red, Bg: fws.Color{A: 255, R: 128, G: 128, B: 128}, UnderlineColor: red}
+	tests := []struct {
+		mode     fws.OutputMode
+		expected string
+	}{
+		{fws.OutputNormal, "\x1b[0;91;100m"},
+		{fws.Output256, "\x1b[0;38;5;196;48;5;244;58;5;196m"},
+		{fws.Output216, "\x1b[0;38;5;196;48;5;102;58;5;196m"},
+		{fws.OutputGrayscale, "\x1b[0;38;5;239;48;5;244;58;5;239m"},
+		{fws.OutputRGB, "\x1b[0;38;2;255;0;0;48;2;128;128;128;58;2;255;0;0m"},
+	}
+	var buf bytes.Buffer
+	for _, test := range tests {
+		term := New(&buf, nil)
+		term.OutputMode = test.mode
+		term.SetCell(0, 0, cell)
+		expected := "\x1b[1;1H" + test.expected + "x\x1b[0m"
+		if got := flush(t, term, &buf); got != expected {
+			t.Errorf("Mode %d:\nexpected %q\ngot      %q\n", test.mode, expected, got)
+		}
+	}
+}
+
+func TestFlushHyperlinks(t *testing.T) {
+	var buf bytes.Buffer
+	term := New(&buf, nil)
+	for _, hyperlinks := range []bool{false, true} {
+		term.Hyperlinks = hyperlinks
+		term.SetLink(3, "https://example.com")
+		term.SetCell(0, 0, fws.Cell{Ch: 'a', Link: 3})
+		term.SetCell(1, 0, fws.Cell{Ch: 'b', Link: 3})
+		term.SetCell(2, 0, fws.Cell{Ch: 'c'})
+		term.SetCell(3, 0, fws.Cell{Ch: 'd', Link: 3})
+		expected := "\x1b[1;1H\x1b[0mabcd\x1b[0m"
+		if hyperlinks {
+			open := "\x1b]8;id=3;https://example.com\x1b\\"
+			expected = "\x1b[1;1H\x1b[0m" + open + "ab\x1b]8;;\x1b\\c" + open + "d\x1b]8;;\x1b\\\x1b[0m"
+		}
+		if got := flush(t, term, &buf); got != expected {
+			t.Errorf("Hyperlinks %v:\nexpected %q\ngot      %q\n", hyperlinks, expected, got)
+		}
+	}
+}
+
+func TestFlushControlCharacters(t *testing.T) {
+	var buf bytes.Buffer
+	term := New(&buf, nil)
+	term.Hyperlinks = true
+	term.SetLink(1, "x\x1b]0;pwned\a")
+	term.SetCell(0, 0, fws.Cell{Ch: 'a', Link: 1})
+	term.SetCell(1, 0, fws.Cell{Ch: 'x', Grapheme: "x\x1b]0;pwned\a"})
+	term.SetCell(2, 0, fws.Cell{Ch: 0x9b})
+	term.SetCell(3, 0, fws.Cell{Ch: '世', Grapheme: "世\u009b"})
+	term.SetCell(5, 0, fws.Cell{Ch: 'b'})
+	// Graphemes with control characters are blanked over their width
+	expected := "\x1b[1;1H\x1b[0m\x1b]8;;\x1b\\a\x1b]8;;\x1b\\  \x1b[1;3H   b\x1b[0m"
+	if got := flush(t, term, &buf); got != expected {
+		t.Errorf("Flushed output:\nexpected %q\ngot      %q\n", expected, got)
+	}
+}
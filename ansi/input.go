@@ -0,0 +1,174 @@
+package ansi
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+func key(k fws.Key) fws.Event {
+	return fws.Event{Type: fws.EventKey, Key: k}
+}
+
+// Keys of CSI sequences ending with letter
+var csiKeys = map[byte]fws.Key{
+	'A': fws.KeyArrowUp,
+	'B': fws.KeyArrowDown,
+	'C': fws.KeyArrowRight,
+	'D': fws.KeyArrowLeft,
+	'H': fws.KeyHome,
+	'F': fws.KeyEnd,
+	'P': fws.KeyF1,
+	'Q': fws.KeyF2,
+	'R': fws.KeyF3,
+	'S': fws.KeyF4,
+}
+
+// Keys of CSI sequences ending with tilde, by first parameter
+var tildeKeys = map[int]fws.Key{
+	1:  fws.KeyHome,
+	2:  fws.KeyInsert,
+	3:  fws.KeyDelete,
+	4:  fws.KeyEnd,
+	5:  fws.KeyPgup,
+	6:  fws.KeyPgdn,
+	7:  fws.KeyHome,
+	8:  fws.KeyEnd,
+	11: fws.KeyF1,
+	12: fws.KeyF2,
+	13: fws.KeyF3,
+	14: fws.KeyF4,
+	15: fws.KeyF5,
+	17: fws.KeyF6,
+	18: fws.KeyF7,
+	19: fws.KeyF8,
+	20: fws.KeyF9,
+	21: fws.KeyF10,
+	23: fws.KeyF11,
+	24: fws.KeyF12,
+}
+
+// Decodes next key or SGR mouse event, unknown sequences are skipped.
+// Escape followed by buffered input starts a sequence or means Alt
+func readEvent(r *bufio.Reader) (fws.Event, error) {
+	for {
+		ch, _, err := r.ReadRune()
+		if err != nil {
+			return fws.Event{}, err
+		}
+		if ch != 0x1b || r.Buffered() == 0 {
+			return runeEvent(ch), nil
+		}
+		next, _, err := r.ReadRune()
+		if err != nil {
+			return fws.Event{}, err
+		}
+		switch next {
+		case '[', 'O':
+			params, final, err := readCSI(r)
+			if err != nil {
+				return fws.Event{}, err
+			}
+			if ev, ok := csiEvent(params, final); ok {
+				return ev, nil
+			}
+		default:
+			ev := runeEvent(next)
+			ev.Mod |= fws.ModAlt
+			return ev, nil
+		}
+	}
+}
+
+func runeEvent(ch rune) fws.Event {
+	if ch < ' ' || ch == 0x7f {
+		return key(fws.Key(ch))
+	}
+	return fws.Event{Type: fws.EventKey, Ch: ch}
+}
+
+// Reads parameter bytes and final byte of control sequence
+func readCSI(r *bufio.Reader) (string, byte, error) {
+	var params []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", 0, err
+		}
+		if b >= 0x40 && b <= 0x7e {
+			return string(params), b, nil
+		}
+		params = append(params, b)
+	}
+}
+
+func csiEvent(params string, final byte) (fws.Event, bool) {
+	if strings.HasPrefix(params, "<") && (final == 'M' || final == 'm') {
+		return mouseEvent(params[1:], final == 'm')
+	}
+	fields := strings.Split(params, ";")
+	var ev fws.Event
+	switch final {
+	case '~':
+		n, _ := strconv.Atoi(fields[0])
+		k, ok := tildeKeys[n]
+		if !ok {
+			return ev, false
+		}
+		ev = key(k)
+	default:
+		k, ok := csiKeys[final]
+		if !ok {
+			return ev, false
+		}
+		ev = key(k)
+	}
+	// Modifier parameter is 1 + bit mask, Alt is 2
+	if len(fields) > 1 {
+		if m, err := strconv.Atoi(fields[1]); err == nil && (m-1)&2 != 0 {
+			ev.Mod |= fws.ModAlt
+		}
+	}
+	return ev, true
+}
+
+// SGR mouse report: button;x;y with 1-based coordinates
+func mouseEvent(params string, release bool) (fws.Event, bool) {
+	fields := strings.Split(params, ";")
+	if len(fields) != 3 {
+		return fws.Event{}, false
+	}
+	var v [3]int
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return fws.Event{}, false
+		}
+		v[i] = n
+	}
+	ev := fws.Event{Type: fws.EventMouse, MouseX: v[1] - 1, MouseY: v[2] - 1}
+	button := v[0]
+	if button&32 != 0 {
+		ev.Mod |= fws.ModMotion
+	}
+	switch {
+	case release:
+		ev.Key = fws.MouseRelease
+	case button&64 != 0 && button&1 == 0:
+		ev.Key = fws.MouseWheelUp
+	case button&64 != 0:
+		ev.Key = fws.MouseWheelDown
+	case button&3 == 0:
+		ev.Key = fws.MouseLeft
+	case button&3 == 1:
+		ev.Key = fws.MouseMiddle
+	case button&3 == 2:
+		ev.Key = fws.MouseRight
+	default:
+		// Motion without button
+		ev.Key = fws.MouseRelease
+	}
+	return ev, true
+}
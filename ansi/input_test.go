@@ -0,0 +1,60 @@
+package ansi
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+func TestPollEvent(t *testing.T) {
+	input := "a\r\x1b[A\x1b[3~\x1bOP\x1b[15;3~\x1bx\x1b[<0;5;7M\x1b[<0;5;7m\x1b[<65;1;1M\x1b[<32;2;3M\x1b[99Z世\x7f"
+	expected := []fws.Event{
+		{Type: fws.EventKey, Ch: 'a'},
+		{Type: fws.EventKey, Key: fws.KeyEnter},
+		{Type: fws.EventKey, Key: fws.KeyArrowUp},
+		{Type: fws.EventKey, Key: fws.KeyDelete},
+		{Type: fws.EventKey, Key: fws.KeyF1},
+		{Type: fws.EventKey, Key: fws.KeyF5, Mod: fws.ModAlt},
+		{Type: fws.EventKey, Ch: 'x', Mod: fws.ModAlt},
+		{Type: fws.EventMouse, Key: fws.MouseLeft, MouseX: 4, MouseY: 6},
+		{Type: fws.EventMouse, Key: fws.MouseRelease, MouseX: 4, MouseY: 6},
+		{Type: fws.EventMouse, Key: fws.MouseWheelDown},
+		{Type: fws.EventMouse, Key: fws.MouseLeft, Mod: fws.ModMotion, MouseX: 1, MouseY: 2},
+		{Type: fws.EventKey, Ch: '世'},
+		{Type: fws.EventKey, Key: fws.KeyBackspace2},
+	}
+	term := New(io.Discard, strings.NewReader(input))
+	for i, e := range expected {
+		if ev := term.PollEvent(); ev != e {
+			t.Errorf("Event %d: expected %+v, got %+v\n", i, e, ev)
+		}
+	}
+	if ev := term.PollEvent(); ev.Type != fws.EventError || !errors.Is(ev.Err, io.EOF) {
+		t.Errorf("End of input: expected EOF error, got %+v\n", ev)
+	}
+	// Lone escape key is not a sequence
+	if ev := New(io.Discard, strings.NewReader("\x1b")).PollEvent(); ev.Key != fws.KeyEsc {
+		t.Errorf("Escape: got %+v\n", ev)
+	}
+}
+
+func TestCloseInterruptsPollEvent(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	term := New(io.Discard, r)
+	done := make(chan fws.Event)
+	go func() { done <- term.PollEvent() }()
+	term.Close()
+	if ev := <-done; ev.Type != fws.EventError {
+		t.Errorf("Poll after close: expected error event, got %+v\n", ev)
+	}
+	if ev := term.PollEvent(); !errors.Is(ev.Err, ErrClosed) {
+		t.Errorf("Poll on closed terminal: expected ErrClosed, got %+v\n", ev)
+	}
+	if ev := New(io.Discard, nil).PollEvent(); !errors.Is(ev.Err, ErrNoInput) {
+		t.Errorf("Poll without input: expected ErrNoInput, got %+v\n", ev)
+	}
+}
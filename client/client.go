@@ -0,0 +1,517 @@
+// FWS application side library
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+// Capabilities requested by Dial
+const DefaultCapabilities = fws.CapTrueColor | fws.CapMouse | fws.CapCompression | fws.CapPalette | fws.CapDelta | fws.CapHyperlinks
+
+var (
+	ErrClosed   = errors.New("client: connection closed")
+	ErrBadReply = errors.New("client: reply does not match request")
+	// Message needs newer protocol revision than negotiated one
+	ErrUnsupported = errors.New("client: message not supported by server")
+)
+
+// Connection to FWS window server.
+// All methods are safe for concurrent use
+type Conn struct {
+	conn    net.Conn
+	enc     *fws.Encoder
+	dec     *fws.Decoder
+	session *fws.ReplyHelloRequest
+
+	mu      sync.Mutex
+	windows map[fws.ID]*Window
+
+	calls   sync.Mutex
+	pending map[uint32]chan fws.Request // Outstanding requests by sequence number
+
+	packs  sync.Mutex  // Keeps packed images sent in packing order
+	packer *fws.Packer // Delta references of DRAW_PACKED images
+
+	done chan struct{} // Closed when read loop stops
+	err  error         // Read loop termination reason
+}
+
+// Connects to window server listening on path (FWS_SOCKET if empty)
+// and performs protocol handshake
+func Dial(ctx context.Context, path string) (*Conn, error) {
+	return DialCapabilities(ctx, path, DefaultCapabilities)
+}
+
+// Dial with explicitly requested capabilities
+func DialCapabilities(ctx context.Context, path string, caps fws.Capability) (*Conn, error) {
+	if path == "" {
+		path = fws.FWS_SOCKET
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		conn:    conn,
+		enc:     fws.NewEncoder(conn),
+		dec:     fws.NewDecoder(conn),
+		windows: make(map[fws.ID]*Window),
+		pending: make(map[uint32]chan fws.Request),
+		packer:  fws.NewPacker(),
+		done:    make(chan struct{}),
+	}
+	if err := c.handshake(ctx, caps); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *Conn) handshake(ctx context.Context, caps fws.Capability) error {
+	// Cancellation interrupts blocked handshake reads and writes
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	session, err := fws.ClientHandshake(c.enc, c.dec, caps)
+	close(stop)
+	<-stopped
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	c.session = session
+	return nil
+}
+
+// Negotiated protocol revision and capabilities
+func (c *Conn) Session() fws.ReplyHelloRequest {
+	return *c.session
+}
+
+// Closes connection and all its windows
+func (c *Conn) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// Error that stopped the connection, nil while it is alive
+func (c *Conn) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+func (c *Conn) send(req fws.Request) error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+	return c.enc.Encode(req)
+}
+
+// Sends request and waits for reply referring to it
+func (c *Conn) call(ctx context.Context, req fws.Request) (fws.Request, error) {
+	reply := make(chan fws.Request, 1)
+	select {
+	case <-c.done:
+		return nil, ErrClosed
+	default:
+	}
+	// Request is registered before read loop can see its reply, calls
+	// are not locked during write, so replies are read while it blocks
+	seq, err := c.enc.SendReserved(req, 0, func(seq uint32) {
+		c.calls.Lock()
+		c.pending[seq] = reply
+		c.calls.Unlock()
+	})
+	if err != nil {
+		c.calls.Lock()
+		delete(c.pending, seq)
+		c.calls.Unlock()
+		return nil, err
+	}
+
+	select {
+	case r := <-reply:
+		return r, nil
+	case <-c.done:
+		return nil, c.err
+	case <-ctx.Done():
+		c.calls.Lock()
+		delete(c.pending, seq)
+		c.calls.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Conn) readLoop() {
+	var err error
+	defer func() {
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			err = ErrClosed
+		}
+		c.err = err
+		close(c.done)
+		c.mu.Lock()
+		for id, w := range c.windows {
+			w.stop()
+			delete(c.windows, id)
+		}
+		c.mu.Unlock()
+	}()
+	for {
+		var f fws.Frame
+		f, err = c.dec.ReadFrame()
+		if err != nil {
+			return
+		}
+		req, decodeErr := fws.DecodeMsg(f.Msg)
+		if decodeErr != nil {
+			// Message is framed, so the stream is still in sync
+			continue
+		}
+		if f.Ref != 0 {
+			c.reply(f.Ref, req)
+		} else {
+			c.dispatch(req)
+		}
+	}
+}
+
+// Delivers reply to its caller, replies to timed out calls are dropped
+func (c *Conn) reply(ref uint32, req fws.Request) {
+	if created, ok := req.(*fws.ReplyCreationRequest); ok {
+		// Window is registered before the next message is read,
+		// so its first events are not lost
+		c.mu.Lock()
+		c.windows[created.Id] = newWindow(c, created.Id)
+		c.mu.Unlock()
+	}
+	c.calls.Lock()
+	reply, ok := c.pending[ref]
+	delete(c.pending, ref)
+	c.calls.Unlock()
+	if ok {
+		reply <- req
+	} else if created, ok := req.(*fws.ReplyCreationRequest); ok {
+		c.mu.Lock()
+		w := c.windows[created.Id]
+		delete(c.windows, created.Id)
+		c.mu.Unlock()
+		w.stop()
+	}
+}
+
+// Handles asynchronous messages
+func (c *Conn) dispatch(req fws.Request) {
+	switch req := req.(type) {
+	case *fws.EventRequest:
+		c.mu.Lock()
+		w := c.windows[req.Id]
+		c.mu.Unlock()
+		if w != nil {
+			w.push(req.Event)
+		}
+	}
+}
+
+// New window parameters
+type WindowOptions struct {
+	X, Y          int                // Global position
+	Width, Height int                // Window size
+	Layer         fws.LayerAttribute // Stacking order
+}
+
+// Creates new window and waits for server to assign its ID
+func (c *Conn) NewWindow(opts WindowOptions) (*Window, error) {
+	reply, err := c.call(context.Background(), &fws.NewWindowRequest{
+		Pid:       os.Getpid(),
+		X:         opts.X,
+		Y:         opts.Y,
+		Width:     opts.Width,
+		Height:    opts.Height,
+		LayerAttr: opts.Layer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	created, ok := reply.(*fws.ReplyCreationRequest)
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected reply to NEW", ErrBadReply)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := c.windows[created.Id]
+	if w == nil {
+		return nil, ErrClosed
+	}
+	return w, nil
+}
+
+// Reads cell of window at local coordinates
+func (c *Conn) GetCell(ctx context.Context, id fws.ID, x, y int) (fws.Cell, error) {
+	reply, err := c.call(ctx, &fws.GetRequest{Id: id, X: x, Y: y})
+	if err != nil {
+		return fws.Cell{}, err
+	}
+	get, ok := reply.(*fws.ReplyGetRequest)
+	if !ok || get.Id != id || get.X != x || get.Y != y {
+		return fws.Cell{}, fmt.Errorf("%w: unexpected reply to GET", ErrBadReply)
+	}
+	return get.C, nil
+}
+
+// Requests screen size and color mode
+func (c *Conn) Screen(ctx context.Context) (fws.ReplyScreenRequest, error) {
+	reply, err := c.call(ctx, &fws.ScreenRequest{})
+	if err != nil {
+		return fws.ReplyScreenRequest{}, err
+	}
+	screen, ok := reply.(*fws.ReplyScreenRequest)
+	if !ok {
+		return fws.ReplyScreenRequest{}, fmt.Errorf("%w: unexpected reply to SCREEN", ErrBadReply)
+	}
+	return *screen, nil
+}
+
+// Handle of a window owned by connection
+type Window struct {
+	conn   *Conn
+	id     fws.ID
+	events chan fws.Event
+
+	mu     sync.Mutex
+	queue  []fws.Event
+	notify chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newWindow(c *Conn, id fws.ID) *Window {
+	w := &Window{
+		conn:   c,
+		id:     id,
+		events: make(chan fws.Event),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go w.pump()
+	return w
+}
+
+// Server assigned window ID
+func (w *Window) ID() fws.ID {
+	return w.id
+}
+
+// Events dispatched to window, mouse coordinates are window local.
+// Channel is closed when window or connection is closed
+func (w *Window) Events() <-chan fws.Event {
+	return w.events
+}
+
+// Queues event without blocking connection read loop
+func (w *Window) push(ev fws.Event) {
+	w.mu.Lock()
+	w.queue = append(w.queue, ev)
+	w.mu.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Feeds queued events into events channel
+func (w *Window) pump() {
+	defer close(w.events)
+	for {
+		select {
+		case <-w.notify:
+		case <-w.done:
+			return
+		}
+		for {
+			w.mu.Lock()
+			if len(w.queue) == 0 {
+				w.mu.Unlock()
+				break
+			}
+			ev := w.queue[0]
+			w.queue = w.queue[1:]
+			w.mu.Unlock()
+			select {
+			case w.events <- ev:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+func (w *Window) stop() {
+	w.once.Do(func() { close(w.done) })
+}
+
+// Draws single cell at local coordinates
+func (w *Window) Draw(x, y int, cell fws.Cell) error {
+	cell = cell.Downgrade(w.conn.session.Minor)
+	return w.conn.send(&fws.DrawRequest{Id: w.id, X: x, Y: y, Cell: cell})
+}
+
+// Image without cell fields server revision can't decode,
+// copied only if anything is dropped
+func (c *Conn) downgrade(img [][]fws.Cell) [][]fws.Cell {
+	minor := c.session.Minor
+	if minor >= fws.ProtocolMinor {
+		return img
+	}
+	var copied [][]fws.Cell
+	for x := range img {
+		for y, cell := range img[x] {
+			down := cell.Downgrade(minor)
+			if down == cell {
+				continue
+			}
+			if copied == nil {
+				copied = make([][]fws.Cell, len(img))
+				for i := range img {
+					copied[i] = append([]fws.Cell(nil), img[i]...)
+				}
+			}
+			copied[x][y] = down
+		}
+	}
+	if copied == nil {
+		return img
+	}
+	return copied
+}
+
+// Size of image indexed as img[x][y], every column must have the same height
+func imgSize(img [][]fws.Cell) (int, int, error) {
+	width := len(img)
+	height := 0
+	if width > 0 {
+		height = len(img[0])
+	}
+	for x := range img {
+		if len(img[x]) != height {
+			return 0, 0, fmt.Errorf("%w: column %d has %d cells, expected %d", fws.ErrBadDimensions, x, len(img[x]), height)
+		}
+	}
+	return width, height, nil
+}
+
+// Draws whole window image, indexed as img[x][y].
+// Image is compressed if server accepts any DRAW_PACKED encoding
+func (w *Window) DrawFill(img [][]fws.Cell) error {
+	width, height, err := imgSize(img)
+	if err != nil {
+		return err
+	}
+	c := w.conn
+	fill := &fws.DrawFillRequest{Id: w.id, Width: width, Height: height, Img: c.downgrade(img)}
+	allowed := fws.Encodings(c.session.Caps)
+	if allowed == 0 || !c.session.Supports(fws.DRAW_PACKED) || width*height > fws.MaxPackedCells {
+		return c.send(fill)
+	}
+	c.packs.Lock()
+	defer c.packs.Unlock()
+	return c.send(c.packer.Pack(fill, allowed))
+}
+
+// Draws image, indexed as img[x][y], with top left corner at local coordinates.
+// Server clips parts outside window
+func (w *Window) DrawRect(x, y int, img [][]fws.Cell) error {
+	if !w.conn.session.Supports(fws.DRAW_RECT) {
+		return fmt.Errorf("%w: DRAW_RECT", ErrUnsupported)
+	}
+	width, height, err := imgSize(img)
+	if err != nil {
+		return err
+	}
+	return w.conn.send(&fws.DrawRectRequest{Id: w.id, X: x, Y: y, Width: width, Height: height, Img: w.conn.downgrade(img)})
+}
+
+// Draws single line of text starting at local coordinates.
+// Falls back to DRAW_RECT on servers without DRAW_TEXT
+func (w *Window) DrawText(x, y int, text string, fg, bg fws.Color, attr fws.Attr) error {
+	attr = fws.Cell{Attribute: attr}.Downgrade(w.conn.session.Minor).Attribute
+	req := &fws.DrawTextRequest{Id: w.id, X: x, Y: y, Text: text, Fg: fg, Bg: bg, Attribute: attr}
+	if w.conn.session.Supports(fws.DRAW_TEXT) {
+		return w.conn.send(req)
+	}
+	if w.conn.session.Supports(fws.DRAW_RECT) {
+		// Expanded text may have continuation and grapheme cells
+		rect := req.Rect()
+		rect.Img = w.conn.downgrade(rect.Img)
+		return w.conn.send(rect)
+	}
+	return fmt.Errorf("%w: DRAW_TEXT", ErrUnsupported)
+}
+
+// Defines hyperlink of window link table, cells with Link set to
+// link index are shown as hyperlinks. Empty URI removes link
+func (w *Window) Link(link uint32, uri string) error {
+	if !w.conn.session.Supports(fws.LINK) {
+		return fmt.Errorf("%w: LINK", ErrUnsupported)
+	}
+	return w.conn.send(&fws.LinkRequest{Id: w.id, Link: link, URI: uri})
+}
+
+// Shows everything drawn since previous render
+func (w *Window) Render() error {
+	return w.conn.send(&fws.RenderRequest{Id: w.id})
+}
+
+// Moves window to global position
+func (w *Window) Move(x, y int) error {
+	return w.conn.send(&fws.MoveRequest{Id: w.id, X: x, Y: y})
+}
+
+func (w *Window) Resize(width, height int) error {
+	return w.conn.send(&fws.ResizeRequest{Id: w.id, Width: width, Height: height})
+}
+
+// Puts window on top and makes it receive key events
+func (w *Window) Focus() error {
+	return w.conn.send(&fws.FocusRequest{Id: w.id})
+}
+
+// Deletes window and closes its events channel
+func (w *Window) Close() error {
+	w.conn.mu.Lock()
+	delete(w.conn.windows, w.id)
+	w.conn.mu.Unlock()
+	w.stop()
+	// Packed image sent concurrently must not leave reference after Forget
+	w.conn.packs.Lock()
+	defer w.conn.packs.Unlock()
+	w.conn.packer.Forget(w.id)
+	return w.conn.send(&fws.DeleteRequest{Id: w.id})
+}
@@ -0,0 +1,543 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+// Minimal window server answering window creation and recording requests
+type fakeServer struct {
+	listener net.Listener
+	path     string
+
+	mu       sync.Mutex
+	requests []fws.Request
+	enc      *fws.Encoder
+	nextID   fws.ID
+	ready    chan struct{}
+
+	held   []fws.Frame // Queries held back until release
+	holdN  int         // Number of queries to hold and answer in reverse order
+	silent bool        // Queries are never answered
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	path := filepath.Join(t.TempDir(), "fws.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{listener: listener, path: path, nextID: 100, ready: make(chan struct{})}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	enc, dec := fws.NewEncoder(conn), fws.NewDecoder(conn)
+	if _, err := fws.ServerHandshake(enc, dec, fws.CapMouse); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.enc = enc
+	s.mu.Unlock()
+	close(s.ready)
+	for {
+		f, err := dec.ReadFrame()
+		if err != nil {
+			return
+		}
+		req, err := fws.DecodeMsg(f.Msg)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		switch req.(type) {
+		case *fws.NewWindowRequest:
+			s.nextID++
+			enc.Send(&fws.ReplyCreationRequest{Id: s.nextID}, f.Seq)
+		case *fws.GetRequest, *fws.ScreenRequest:
+			if s.silent {
+				break
+			}
+			s.held = append(s.held, f)
+			if len(s.held) >= s.holdN {
+				// Answer queries in reverse order with an event in between
+				for i := len(s.held) - 1; i >= 0; i-- {
+					s.answer(s.held[i])
+					enc.Encode(&fws.EventRequest{Id: s.nextID, Event: fws.Event{Type: fws.EventKey, Ch: 'e'}})
+				}
+				s.held = nil
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *fakeServer) answer(f fws.Frame) {
+	req, _ := fws.DecodeMsg(f.Msg)
+	switch req := req.(type) {
+	case *fws.GetRequest:
+		cell := fws.Cell{Ch: rune('0' + req.X)}
+		s.enc.Send(&fws.ReplyGetRequest{Id: req.Id, X: req.X, Y: req.Y, C: cell}, f.Seq)
+	case *fws.ScreenRequest:
+		s.enc.Send(&fws.ReplyScreenRequest{Width: 80, Height: 25, Mode: fws.OutputRGB}, f.Seq)
+	}
+}
+
+func (s *fakeServer) received() []fws.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fws.Request(nil), s.requests...)
+}
+
+func (s *fakeServer) waitFor(t *testing.T, count int) []fws.Request {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if requests := s.received(); len(requests) >= count {
+			return requests
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Server received %d requests, expected %d\n", len(s.received()), count)
+	return nil
+}
+
+func dial(t *testing.T, s *fakeServer) *Conn {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestDialHandshake(t *testing.T) {
+	c := dial(t, newFakeServer(t))
+	if session := c.Session(); !session.Accepted || session.Caps != fws.CapMouse {
+		t.Errorf("Unexpected session: %v\n", session)
+	}
+}
+
+func TestDialCancelled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fws.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// Server accepts connection but never answers HELLO
+	go listener.Accept()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Dial(ctx, path); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v\n", err)
+	}
+}
+
+func TestWindowRequests(t *testing.T) {
+	s := newFakeServer(t)
+	c := dial(t, s)
+	w, err := c.NewWindow(WindowOptions{X: 1, Y: 2, Width: 3, Height: 4, Layer: fws.TOP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.ID() != 101 {
+		t.Errorf("ID field failed: expected %d, got %d\n", 101, w.ID())
+	}
+	cell := fws.Cell{Ch: 'a', Fg: fws.Color{A: 255, R: 1, G: 2, B: 3}}
+	w.Draw(1, 1, cell)
+	w.DrawFill([][]fws.Cell{{cell, cell}, {cell, cell}})
+	w.DrawRect(-1, 2, [][]fws.Cell{{cell}, {cell}, {cell}})
+	w.Link(1, "https://example.com")
+	w.Render()
+	w.Move(5, 6)
+	w.Resize(7, 8)
+	w.Focus()
+	w.Close()
+
+	requests := s.waitFor(t, 10)
+	if req, ok := requests[0].(*fws.NewWindowRequest); !ok || req.Width != 3 || req.LayerAttr != fws.TOP {
+		t.Errorf("Expected new window request, got %v\n", requests[0])
+	}
+	if req, ok := requests[1].(*fws.DrawRequest); !ok || req.Id != w.ID() || req.Cell != cell {
+		t.Errorf("Expected draw request, got %v\n", requests[1])
+	}
+	if req, ok := requests[2].(*fws.DrawFillRequest); !ok || req.Width != 2 || req.Height != 2 {
+		t.Errorf("Expected draw fill request, got %v\n", requests[2])
+	}
+	if req, ok := requests[3].(*fws.DrawRectRequest); !ok || req.X != -1 || req.Y != 2 || req.Width != 3 || req.Height != 1 {
+		t.Errorf("Expected draw rect request, got %v\n", requests[3])
+	}
+	if req, ok := requests[4].(*fws.LinkRequest); !ok || req.Link != 1 || req.URI != "https://example.com" {
+		t.Errorf("Expected link request, got %v\n", requests[4])
+	}
+	if _, ok := requests[5].(*fws.RenderRequest); !ok {
+		t.Errorf("Expected render request, got %v\n", requests[5])
+	}
+	if req, ok := requests[6].(*fws.MoveRequest); !ok || req.X != 5 || req.Y != 6 {
+		t.Errorf("Expected move request, got %v\n", requests[6])
+	}
+	if req, ok := requests[7].(*fws.ResizeRequest); !ok || req.Width != 7 || req.Height != 8 {
+		t.Errorf("Expected resize request, got %v\n", requests[7])
+	}
+	if _, ok := requests[8].(*fws.FocusRequest); !ok {
+		t.Errorf("Expected focus request, got %v\n", requests[8])
+	}
+	if req, ok := requests[9].(*fws.DeleteRequest); !ok || req.Id != w.ID() {
+		t.Errorf("Expected delete request, got %v\n", requests[9])
+	}
+	if _, open := <-w.Events(); open {
+		t.Errorf("Events channel is open after window close\n")
+	}
+}
+
+func TestDrawFillRagged(t *testing.T) {
+	c := dial(t, newFakeServer(t))
+	w, err := c.NewWindow(WindowOptions{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.DrawFill([][]fws.Cell{{{}, {}}, {{}}}); !errors.Is(err, fws.ErrBadDimensions) {
+		t.Errorf("Expected ErrBadDimensions, got %v\n", err)
+	}
+	if err := w.DrawRect(1, 1, [][]fws.Cell{{{}}, {}}); !errors.Is(err, fws.ErrBadDimensions) {
+		t.Errorf("Expected ErrBadDimensions from DrawRect, got %v\n", err)
+	}
+}
+
+func TestDrawFillPacked(t *testing.T) {
+	s := newFakeServer(t)
+	c := dial(t, s)
+	w, err := c.NewWindow(WindowOptions{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Session negotiated with server accepting compressed images
+	c.session.Caps |= fws.CapCompression | fws.CapDelta
+	img := [][]fws.Cell{{{Ch: 'a'}, {Ch: 'a'}}, {{Ch: 'a'}, {Ch: 'b'}}}
+	w.DrawFill(img)
+	img[0][0].Ch = 'c'
+	w.DrawFill(img)
+
+	requests := s.waitFor(t, 3)
+	u := fws.NewUnpacker()
+	expected := []rune{'a', 'c'}
+	for i, req := range requests[1:] {
+		packed, ok := req.(*fws.PackedFillRequest)
+		if !ok {
+			t.Fatalf("Expected packed fill request, got %v\n", req)
+		}
+		fill, err := u.Unpack(packed)
+		if err != nil {
+			t.Fatalf("Unexpected unpacking error: %v\n", err)
+		}
+		if fill.Img[0][0].Ch != expected[i] || fill.Img[1][1].Ch != 'b' {
+			t.Errorf("Image %d: unexpected cells %v\n", i, fill.Img)
+		}
+	}
+	if enc := requests[2].(*fws.PackedFillRequest).Encoding; enc != fws.EncodingRLE|fws.EncodingDelta {
+		t.Errorf("Second image encoding: expected %b, got %b\n", fws.EncodingRLE|fws.EncodingDelta, enc)
+	}
+}
+
+func TestDrawRectUnsupported(t *testing.T) {
+	c := dial(t, newFakeServer(t))
+	w, err := c.NewWindow(WindowOptions{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Session negotiated with server implementing revision 0
+	c.session.Minor = 0
+	if err := w.DrawRect(0, 0, [][]fws.Cell{{{}}}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got %v\n", err)
+	}
+	if err := w.DrawText(0, 0, "text", fws.Color{}, fws.Color{}, 0); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported from DrawText, got %v\n", err)
+	}
+	if err := w.Link(1, "file:///"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported from Link, got %v\n", err)
+	}
+}
+
+func TestDrawText(t *testing.T) {
+	s := newFakeServer(t)
+	c := dial(t, s)
+	w, err := c.NewWindow(WindowOptions{Width: 10, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fg := fws.Color{A: 255, R: 9}
+	w.DrawText(1, 1, "hi", fg, fws.Color{}, fws.Bold)
+	// Session negotiated with server implementing revision 1
+	c.session.Minor = 1
+	w.DrawText(1, 0, "hi", fg, fws.Color{}, fws.Bold)
+
+	requests := s.waitFor(t, 3)
+	if req, ok := requests[1].(*fws.DrawTextRequest); !ok || req.Text != "hi" || req.Fg != fg || req.Attribute != fws.Bold {
+		t.Errorf("Expected draw text request, got %v\n", requests[1])
+	}
+	if req, ok := requests[2].(*fws.DrawRectRequest); !ok || req.Width != 2 || req.Img[1][0].Ch != 'i' {
+		t.Errorf("Expected draw rect request, got %v\n", requests[2])
+	}
+}
+
+func TestDowngradedCells(t *testing.T) {
+	s := newFakeServer(t)
+	c := dial(t, s)
+	w, err := c.NewWindow(WindowOptions{Width: 10, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Session negotiated with server implementing revision 2, which can't
+	// decode any cell extension
+	c.session.Minor = 2
+	red := fws.Color{A: 255, R: 255}
+	w.DrawText(0, 0, "世e\u0301", red, fws.Color{}, fws.Strikethrough)
+	w.Draw(0, 1, fws.Cell{Ch: 'a', Attribute: fws.CurlyUnderline, UnderlineColor: red, Link: 1})
+	img := [][]fws.Cell{{{Ch: 'e', Grapheme: "e\u0301"}}}
+	w.DrawFill(img)
+
+	requests := s.waitFor(t, 4)
+	rect, ok := requests[1].(*fws.DrawRectRequest)
+	if !ok || rect.Width != 3 {
+		t.Fatalf("Expected draw rect request, got %v\n", requests[1])
+	}
+	expected := []fws.Cell{{Ch: '世', Fg: red}, {Fg: red}, {Ch: 'e', Fg: red}}
+	for x, cell := range expected {
+		if rect.Img[x][0] != cell {
+			t.Errorf("Text cell %d: expected %v, got %v\n", x, cell, rect.Img[x][0])
+		}
+	}
+	if draw, ok := requests[2].(*fws.DrawRequest); !ok || draw.Cell != (fws.Cell{Ch: 'a', Attribute: fws.Underline}) {
+		t.Errorf("Expected plain underlined cell, got %v\n", requests[2])
+	}
+	if fill, ok := requests[3].(*fws.DrawFillRequest); !ok || fill.Img[0][0] != (fws.Cell{Ch: 'e'}) {
+		t.Errorf("Expected fill without grapheme, got %v\n", requests[3])
+	}
+	if img[0][0].Grapheme == "" {
+		t.Error("Caller image was modified")
+	}
+}
+
+func TestConcurrentWindows(t *testing.T) {
+	s := newFakeServer(t)
+	c := dial(t, s)
+	const count = 20
+	windows := make([]*Window, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w, err := c.NewWindow(WindowOptions{Width: 1, Height: 1})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			windows[i] = w
+			w.Draw(0, 0, fws.Cell{Ch: 'x'})
+		}(i)
+	}
+	wg.Wait()
+	seen := map[fws.ID]bool{}
+	for _, w := range windows {
+		if w == nil {
+			continue
+		}
+		if seen[w.ID()] {
+			t.Errorf("Window ID %d assigned twice\n", w.ID())
+		}
+		seen[w.ID()] = true
+	}
+	s.waitFor(t, 2*count)
+}
+
+func TestWindowEvents(t *testing.T) {
+	s := newFakeServer(t)
+	c := dial(t, s)
+	first, err := c.NewWindow(WindowOptions{Width: 1, Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.NewWindow(WindowOptions{Width: 1, Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.ready
+	// Events are queued even if nobody reads them yet
+	for i := 0; i < 100; i++ {
+		s.enc.Encode(&fws.EventRequest{Id: second.ID(), Event: fws.Event{Type: fws.EventKey, Ch: rune('a' + i%26)}})
+	}
+	s.enc.Encode(&fws.EventRequest{Id: first.ID(), Event: fws.Event{Type: fws.EventMouse, MouseX: 3}})
+
+	select {
+	case ev := <-first.Events():
+		if ev.Type != fws.EventMouse || ev.MouseX != 3 {
+			t.Errorf("Unexpected event: %v\n", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("First window event was not delivered")
+	}
+	for i := 0; i < 100; i++ {
+		ev := <-second.Events()
+		if ev.Ch != rune('a'+i%26) {
+			t.Fatalf("Event %d: expected %c, got %c\n", i, rune('a'+i%26), ev.Ch)
+		}
+	}
+
+	c.Close()
+	if _, open := <-first.Events(); open {
+		t.Errorf("Events channel is open after connection close\n")
+	}
+	if err := first.Render(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v\n", err)
+	}
+}
+
+func TestOutOfOrderReplies(t *testing.T) {
+	s := newFakeServer(t)
+	s.holdN = 6
+	c := dial(t, s)
+	w, err := c.NewWindow(WindowOptions{Width: 10, Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for x := 0; x < 5; x++ {
+		wg.Add(1)
+		go func(x int) {
+			defer wg.Done()
+			cell, err := c.GetCell(ctx, w.ID(), x, 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if cell.Ch != rune('0'+x) {
+				t.Errorf("GetCell(%d): expected %c, got %c\n", x, rune('0'+x), cell.Ch)
+			}
+		}(x)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		screen, err := c.Screen(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if screen.Width != 80 || screen.Height != 25 || screen.Mode != fws.OutputRGB {
+			t.Errorf("Unexpected screen reply: %v\n", screen)
+		}
+	}()
+	wg.Wait()
+
+	for i := 0; i < 6; i++ {
+		if ev := <-w.Events(); ev.Ch != 'e' {
+			t.Errorf("Interleaved event %d: expected %c, got %c\n", i, 'e', ev.Ch)
+		}
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	s := newFakeServer(t)
+	s.silent = true
+	c := dial(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetCell(ctx, 1, 0, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v\n", err)
+	}
+	if _, err := c.Screen(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v\n", err)
+	}
+	c.calls.Lock()
+	defer c.calls.Unlock()
+	if len(c.pending) != 0 {
+		t.Errorf("Timed out calls left in pending table: %d\n", len(c.pending))
+	}
+}
+
+func TestReplyDuringBlockedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fws.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// Server reads the first query, then answers it without reading
+	// anything else, so socket buffers of app fill up
+	queried := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		enc, dec := fws.NewEncoder(conn), fws.NewDecoder(conn)
+		if _, err := fws.ServerHandshake(enc, dec, 0); err != nil {
+			return
+		}
+		f, err := dec.ReadFrame()
+		if err != nil {
+			return
+		}
+		close(queried)
+		time.Sleep(100 * time.Millisecond)
+		enc.Send(&fws.ReplyGetRequest{Id: 1, C: fws.Cell{Ch: 'a'}}, f.Seq)
+		time.Sleep(5 * time.Second)
+	}()
+	c, err := Dial(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got := make(chan fws.Cell, 1)
+	go func() {
+		cell, _ := c.GetCell(ctx, 1, 0, 0)
+		got <- cell
+	}()
+	<-queried
+	img := make([][]fws.Cell, 100)
+	for i := range img {
+		img[i] = make([]fws.Cell, 100)
+	}
+	go func() {
+		for c.send(&fws.DrawFillRequest{Id: 1, Width: 100, Height: 100, Img: img}) == nil {
+		}
+	}()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.Screen(ctx)
+	}()
+	select {
+	case cell := <-got:
+		if cell.Ch != 'a' {
+			t.Errorf("Reply cell: got %v\n", cell)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Reply is not delivered while another call blocks in write")
+	}
+}
@@ -0,0 +1,75 @@
+// Command fwsd is reference FWS window server.
+//
+// It listens for apps on FWS socket and composes their windows on the
+// terminal it is started in:
+//
+//	fwsd [-socket /tmp/fws_server.sock] [-mode 256] [-maxcells 299593]
+//
+// Keyboard input goes to focused window, which is the last created,
+// focused or clicked one. Mouse input goes to the topmost window under
+// pointer in its local coordinates, transparent cells let it through.
+// Windows of disconnected apps are removed. Ctrl+\ or SIGTERM stops
+// the server
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/Nekhaevalex/fwsprotocol/compositor"
+	"github.com/Nekhaevalex/fwsprotocol/server"
+	"github.com/Nekhaevalex/fwsprotocol/termboxadapter"
+)
+
+// Serves apps on path and composes their windows on screen until
+// quit key is pressed or screen is closed
+func run(path string, screen fws.Screen, quit fws.Key, maxCells int) error {
+	l, err := server.Listen(path)
+	if err != nil {
+		return err
+	}
+	m := newWM(screen, maxCells)
+	l.Caps = m.caps()
+	m.render()
+	served := make(chan error, 1)
+	go func() {
+		served <- l.Serve(m)
+	}()
+	m.pollEvents(quit)
+	l.Close()
+	if err := <-served; !errors.Is(err, server.ErrListenerClosed) {
+		return err
+	}
+	return nil
+}
+
+func main() {
+	path := flag.String("socket", fws.FWS_SOCKET, "socket to accept apps on")
+	mode := fws.OutputRGB
+	flag.TextVar(&mode, "mode", fws.OutputRGB, "terminal colors: normal, 256, 216, grayscale or rgb")
+	maxCells := flag.Int("maxcells", compositor.DefaultMaxWindowCells, "largest window area, apps asking for more are disconnected")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("fwsd: ")
+
+	screen, err := termboxadapter.Open(mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-stop
+		screen.Close()
+	}()
+	err = run(*path, screen, fws.KeyCtrlBackslash, *maxCells)
+	screen.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/Nekhaevalex/fwsprotocol/client"
+	"github.com/Nekhaevalex/fwsprotocol/headless"
+)
+
+// Starts server on headless screen, it is stopped by quit key at cleanup
+func start(t *testing.T, screen *headless.Screen) string {
+	path := filepath.Join(t.TempDir(), "fws.sock")
+	done := make(chan error, 1)
+	go func() {
+		done <- run(path, screen, fws.KeyCtrlBackslash, 100)
+	}()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Server socket was not created")
+		}
+	}
+	t.Cleanup(func() {
+		screen.InjectKey(fws.KeyCtrlBackslash, 0)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Server: %v\n", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("Server was not stopped by quit key")
+		}
+		screen.Close()
+	})
+	return path
+}
+
+func TestRun(t *testing.T) {
+	screen := headless.New(4, 2, fws.OutputRGB)
+	path := start(t, screen)
+	// Background is shown before any app connects
+	if err := screen.WaitText(time.Second, 1, "    "); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+	conn, err := client.Dial(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	caps := conn.Session().Caps
+	if caps&fws.CapMouse == 0 || caps&fws.CapTrueColor == 0 || caps&fws.CapHyperlinks == 0 {
+		t.Errorf("Offered capabilities: got %v\n", caps)
+	}
+}
+
+func TestRunQuit(t *testing.T) {
+	screen := headless.New(4, 2, fws.Output256)
+	path := filepath.Join(t.TempDir(), "fws.sock")
+	done := make(chan error, 1)
+	go func() {
+		done <- run(path, screen, fws.KeyCtrlBackslash, 100)
+	}()
+	screen.InjectRune('q')
+	screen.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Server: %v\n", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Server was not stopped by closed screen")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Socket file: expected removal, got %v\n", err)
+	}
+}
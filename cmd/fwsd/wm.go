@@ -0,0 +1,251 @@
+package main
+
+import (
+	"sync"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/Nekhaevalex/fwsprotocol/compositor"
+	"github.com/Nekhaevalex/fwsprotocol/server"
+)
+
+// Window manager composing app windows onto terminal screen.
+// Keyboard input goes to focused window, which is the last created,
+// focused or clicked one; when it is deleted focus moves to the topmost
+// window left. Mouse input goes to the window under pointer
+type wm struct {
+	server.BaseHandler
+	comp   *compositor.Compositor
+	mouse  *compositor.Router
+	screen fws.Screen
+
+	mu      sync.Mutex
+	nextID  fws.ID
+	conns   map[fws.ID]*server.Conn
+	outbox  map[*server.Conn]chan windowEvent // Events waiting for apps having windows
+	focus   fws.ID                            // 0 if there are no windows
+	stopped bool                              // Screen is not used after input loop ends
+}
+
+// Events queued for one app, app not reading them is disconnected
+// once queue is full, so it can't stall input of others
+const outboxSize = 256
+
+type windowEvent struct {
+	id fws.ID
+	ev fws.Event
+}
+
+// Window manager with windows of at most maxCells cells
+func newWM(screen fws.Screen, maxCells int) *wm {
+	w, h := screen.Size()
+	comp := compositor.New(w, h)
+	comp.SetMaxWindowCells(maxCells)
+	mouse := compositor.NewRouter(comp)
+	mouse.FocusOnClick = true
+	return &wm{
+		comp:   comp,
+		mouse:  mouse,
+		screen: screen,
+		nextID: 1,
+		conns:  make(map[fws.ID]*server.Conn),
+		outbox: make(map[*server.Conn]chan windowEvent),
+	}
+}
+
+// Capabilities offered to apps
+func (m *wm) caps() fws.Capability {
+	caps := fws.CapMouse | fws.CapCompression | fws.CapPalette | fws.CapDelta
+	if m.screen.Mode() == fws.OutputRGB {
+		caps |= fws.CapTrueColor
+	}
+	if _, ok := m.screen.(compositor.Linker); ok {
+		caps |= fws.CapHyperlinks
+	}
+	return caps
+}
+
+// Sends changed cells to screen
+func (m *wm) render() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.stopped {
+		m.comp.Render(m.screen)
+	}
+}
+
+func (m *wm) OnNewWindow(c *server.Conn, req *fws.NewWindowRequest) (fws.ID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.nextID
+	if err := m.comp.Add(id, req); err != nil {
+		return 0, err
+	}
+	m.nextID++
+	m.conns[id] = c
+	m.focus = id
+	if m.outbox[c] == nil {
+		events := make(chan windowEvent, outboxSize)
+		m.outbox[c] = events
+		go deliver(c, events)
+	}
+	return id, nil
+}
+
+// Sends queued events to app until queue is closed
+func deliver(c *server.Conn, events <-chan windowEvent) {
+	for e := range events {
+		if err := c.SendEvent(e.id, e.ev); err != nil {
+			c.Close()
+		}
+	}
+}
+
+func (m *wm) OnGet(c *server.Conn, req *fws.GetRequest) fws.Cell {
+	cell, _ := m.comp.Cell(req.Id, req.X, req.Y)
+	return cell
+}
+
+func (m *wm) OnDraw(c *server.Conn, req *fws.DrawRequest)         { m.comp.Apply(req) }
+func (m *wm) OnDrawFill(c *server.Conn, req *fws.DrawFillRequest) { m.comp.Apply(req) }
+func (m *wm) OnDrawRect(c *server.Conn, req *fws.DrawRectRequest) { m.comp.Apply(req) }
+func (m *wm) OnLink(c *server.Conn, req *fws.LinkRequest)         { m.comp.Apply(req) }
+func (m *wm) OnMove(c *server.Conn, req *fws.MoveRequest)         { m.comp.Apply(req) }
+func (m *wm) OnRender(c *server.Conn, req *fws.RenderRequest)     { m.render() }
+
+// Window can't grow over area limit, app asking for it is disconnected
+func (m *wm) OnResize(c *server.Conn, req *fws.ResizeRequest) {
+	if err := m.comp.Apply(req); err != nil {
+		c.Close()
+	}
+}
+
+// Focused window is raised and shown at once
+func (m *wm) OnFocus(c *server.Conn, req *fws.FocusRequest) {
+	m.mu.Lock()
+	m.focus = req.Id
+	m.mu.Unlock()
+	m.comp.Apply(req)
+	m.render()
+}
+
+func (m *wm) OnUnfocus(c *server.Conn, req *fws.UnfocusRequest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.focus == req.Id {
+		m.refocus(req.Id)
+	}
+}
+
+// Moves focus to topmost window other than id
+func (m *wm) refocus(id fws.ID) {
+	m.focus = 0
+	stack := m.comp.Stack()
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] != id {
+			m.focus = stack[i]
+			return
+		}
+	}
+}
+
+// Called for windows left by disconnected apps as well,
+// deleted window disappears at once
+func (m *wm) OnDelete(c *server.Conn, req *fws.DeleteRequest) {
+	m.mu.Lock()
+	delete(m.conns, req.Id)
+	m.comp.Apply(req)
+	if m.focus == req.Id {
+		m.refocus(req.Id)
+	}
+	if !m.hasWindows(c) {
+		if events := m.outbox[c]; events != nil {
+			close(events)
+			delete(m.outbox, c)
+		}
+	}
+	m.mu.Unlock()
+	m.render()
+}
+
+func (m *wm) hasWindows(c *server.Conn) bool {
+	for _, owner := range m.conns {
+		if owner == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *wm) OnScreen(c *server.Conn, req *fws.ScreenRequest) fws.ReplyScreenRequest {
+	w, h := m.comp.Size()
+	return fws.ReplyScreenRequest{Width: int32(w), Height: int32(h), Mode: m.screen.Mode()}
+}
+
+// Queues event for window if it is still there, never blocks
+func (m *wm) send(id fws.ID, ev fws.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue(id, ev)
+}
+
+func (m *wm) queue(id fws.ID, ev fws.Event) {
+	c := m.conns[id]
+	if c == nil {
+		return
+	}
+	select {
+	case m.outbox[c] <- windowEvent{id, ev}:
+	default:
+		// Connection goroutine deletes windows of app after close
+		c.Close()
+	}
+}
+
+// Handles screen input until screen is closed or quit key is pressed.
+// Resize repaints screen and is sent to every window, mouse events are
+// routed to window under pointer in its local coordinates, click focuses
+// it. Other events go to focused window
+func (m *wm) pollEvents(quit fws.Key) {
+	defer func() {
+		m.mu.Lock()
+		m.stopped = true
+		m.mu.Unlock()
+	}()
+	for {
+		ev := m.screen.PollEvent()
+		switch ev.Type {
+		case fws.EventError:
+			return
+		case fws.EventKey:
+			if ev.Ch == 0 && ev.Key == quit {
+				return
+			}
+		case fws.EventResize:
+			m.comp.SetSize(ev.Width, ev.Height)
+			m.render()
+			m.mu.Lock()
+			for id := range m.conns {
+				m.queue(id, ev)
+			}
+			m.mu.Unlock()
+			continue
+		case fws.EventMouse:
+			route, ok := m.mouse.Route(ev)
+			if !ok {
+				continue
+			}
+			if route.Focus {
+				m.mu.Lock()
+				m.focus = route.Id
+				m.mu.Unlock()
+				m.render()
+			}
+			m.send(route.Id, route.Event)
+			continue
+		}
+		m.mu.Lock()
+		id := m.focus
+		m.mu.Unlock()
+		m.send(id, ev)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/Nekhaevalex/fwsprotocol/client"
+	"github.com/Nekhaevalex/fwsprotocol/headless"
+)
+
+var (
+	white = fws.Color{A: 255, R: 255, G: 255, B: 255}
+	blue  = fws.Color{A: 255, B: 255}
+)
+
+func dial(t *testing.T, path string) *client.Conn {
+	conn, err := client.Dial(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func window(t *testing.T, conn *client.Conn, opts client.WindowOptions, text string) *client.Window {
+	w, err := conn.NewWindow(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.DrawText(0, 0, text, white, blue, 0)
+	w.Render()
+	return w
+}
+
+func nextEvent(t *testing.T, w *client.Window) fws.Event {
+	t.Helper()
+	select {
+	case ev := <-w.Events():
+		return ev
+	case <-time.After(time.Second):
+		t.Fatalf("Window %d: no event received", w.ID())
+	}
+	return fws.Event{}
+}
+
+func TestCompose(t *testing.T) {
+	screen := headless.New(10, 3, fws.Output256)
+	path := start(t, screen)
+	back := window(t, dial(t, path), client.WindowOptions{X: 1, Y: 1, Width: 6, Height: 1}, "back..")
+	if err := screen.WaitText(time.Second, 1, " back.."); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+	// Transparent cells of upper window show the one below
+	front, err := dial(t, path).NewWindow(client.WindowOptions{X: 4, Y: 1, Width: 4, Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	front.Draw(1, 0, fws.Cell{Ch: '#', Fg: white, Bg: blue})
+	front.Render()
+	if err := screen.WaitText(time.Second, 1, " back#."); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+	back.Focus()
+	if err := screen.WaitText(time.Second, 1, " back.. "); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+	if cell, _ := dial(t, path).GetCell(context.Background(), back.ID(), 0, 0); cell.Ch != 0 {
+		t.Errorf("Foreign window cell: expected empty, got %v\n", cell)
+	}
+}
+
+func TestInput(t *testing.T) {
+	screen := headless.New(10, 4, fws.Output256)
+	path := start(t, screen)
+	conn := dial(t, path)
+	first := window(t, conn, client.WindowOptions{Width: 3, Height: 1}, "one")
+	other := dial(t, path)
+	second := window(t, other, client.WindowOptions{X: 2, Y: 2, Width: 3, Height: 1}, "two")
+	if err := screen.WaitText(time.Second, 2, "  two"); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+
+	// Last created window is focused
+	screen.InjectRune('a')
+	if ev := nextEvent(t, second); ev.Type != fws.EventKey || ev.Ch != 'a' {
+		t.Errorf("Key event: got %v\n", ev)
+	}
+	screen.InjectMouse(3, 2, fws.MouseLeft)
+	if ev := nextEvent(t, second); ev.Type != fws.EventMouse || ev.MouseX != 1 || ev.MouseY != 0 {
+		t.Errorf("Mouse event: expected local 1,0, got %v\n", ev)
+	}
+
+	// Click focuses window under pointer, transparent cells let it through
+	screen.InjectMouse(1, 0, fws.MouseLeft)
+	if ev := nextEvent(t, first); ev.Type != fws.EventMouse || ev.MouseX != 1 || ev.MouseY != 0 {
+		t.Errorf("Click event: expected local 1,0, got %v\n", ev)
+	}
+	screen.InjectRune('c')
+	if ev := nextEvent(t, first); ev.Ch != 'c' {
+		t.Errorf("Key event after click: got %v\n", ev)
+	}
+	second.Move(1, 0)
+	second.Draw(1, 0, fws.Cell{})
+	second.Focus()
+	// Reply comes after requests are handled
+	if _, err := other.Screen(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	screen.InjectRune('b')
+	if ev := nextEvent(t, second); ev.Ch != 'b' {
+		t.Errorf("Key event after focus: got %v\n", ev)
+	}
+	screen.InjectMouse(2, 0, fws.MouseLeft)
+	if ev := nextEvent(t, first); ev.MouseX != 2 || ev.MouseY != 0 {
+		t.Errorf("Click through transparent cell: expected local 2,0, got %v\n", ev)
+	}
+	// Release goes to pressed window
+	screen.InjectMouse(3, 0, fws.MouseRelease)
+	if ev := nextEvent(t, first); ev.Key != fws.MouseRelease || ev.MouseX != 3 {
+		t.Errorf("Release event: expected local 3,0, got %v\n", ev)
+	}
+	screen.InjectMouse(3, 0, fws.MouseWheelUp)
+	if ev := nextEvent(t, second); ev.Key != fws.MouseWheelUp || ev.MouseX != 2 || ev.MouseY != 0 {
+		t.Errorf("Wheel event: expected local 2,0, got %v\n", ev)
+	}
+	if err := screen.WaitText(time.Second, 0, "oneo"); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+
+	// Every window learns about resize, screen reply follows it
+	screen.Resize(12, 5)
+	for _, w := range []*client.Window{first, second} {
+		if ev := nextEvent(t, w); ev.Type != fws.EventResize || ev.Width != 12 || ev.Height != 5 {
+			t.Errorf("Window %d resize event: got %v\n", w.ID(), ev)
+		}
+	}
+	reply, err := conn.Screen(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Width != 12 || reply.Height != 5 || reply.Mode != fws.Output256 {
+		t.Errorf("Screen reply: got %v\n", &reply)
+	}
+	if err := screen.WaitText(time.Second, 0, "oneo        "); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	screen := headless.New(8, 2, fws.Output256)
+	path := start(t, screen)
+	first := window(t, dial(t, path), client.WindowOptions{Width: 3, Height: 1}, "one")
+	other := dial(t, path)
+	window(t, other, client.WindowOptions{Y: 1, Width: 3, Height: 1}, "two")
+	if err := screen.WaitText(time.Second, 1, "two"); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+
+	// Windows of disconnected app disappear and focus goes to the
+	// topmost window left
+	other.Close()
+	if err := screen.WaitText(time.Second, 1, "   "); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+	screen.InjectRune('x')
+	if ev := nextEvent(t, first); ev.Ch != 'x' {
+		t.Errorf("Key event after disconnect: got %v\n", ev)
+	}
+
+	first.Close()
+	if err := screen.WaitText(time.Second, 0, "   "); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+}
+
+func TestWindowSizeLimit(t *testing.T) {
+	screen := headless.New(8, 2, fws.Output256)
+	path := start(t, screen)
+	if _, err := dial(t, path).NewWindow(client.WindowOptions{Width: 101, Height: 1}); err == nil {
+		t.Error("Window over area limit was created")
+	}
+	conn := dial(t, path)
+	w := window(t, conn, client.WindowOptions{Width: 3, Height: 1}, "big")
+	if err := screen.WaitText(time.Second, 0, "big"); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+	// App asking for more is disconnected and its window removed
+	w.Resize(1<<40, 1)
+	if err := screen.WaitText(time.Second, 0, "   "); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+}
+
+func TestStalledApp(t *testing.T) {
+	screen := headless.New(8, 2, fws.Output256)
+	path := start(t, screen)
+	reading := window(t, dial(t, path), client.WindowOptions{Width: 3, Height: 1}, "one")
+
+	// App stops reading its socket after creating focused window
+	raw, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	enc, dec := fws.NewEncoder(raw), fws.NewDecoder(raw)
+	if _, err := fws.ClientHandshake(enc, dec, 0); err != nil {
+		t.Fatal(err)
+	}
+	enc.Send(&fws.NewWindowRequest{Y: 1, Width: 1, Height: 1}, 0)
+	f, err := dec.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := fws.DecodeMsg(f.Msg)
+	id := req.(*fws.ReplyCreationRequest).Id
+	enc.Send(&fws.DrawRequest{Id: id, Cell: fws.Cell{Ch: '#', Fg: white, Bg: blue}}, 0)
+	enc.Send(&fws.RenderRequest{Id: id}, 0)
+	if err := screen.WaitText(time.Second, 1, "#"); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+
+	// Input keeps flowing, stalled app is dropped once its queue is full
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100000 && screen.Cell(0, 1).Ch == '#'; i++ {
+			screen.InjectRune('x')
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Input loop is stalled by app not reading events")
+	}
+	if err := screen.WaitText(time.Second, 1, " "); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+	screen.InjectRune('z')
+	for {
+		ev := nextEvent(t, reading)
+		if ev.Ch == 'z' {
+			break
+		}
+		if ev.Ch != 'x' {
+			t.Fatalf("Key event after stalled app removal: got %v\n", ev)
+		}
+	}
+}
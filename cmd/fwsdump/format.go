@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+// Parses comma separated header names
+func parseHeaders(list string) (map[fws.Header]bool, error) {
+	headers := make(map[fws.Header]bool)
+	for _, name := range strings.Split(list, ",") {
+		h, err := fws.ParseHeader(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		headers[h] = true
+	}
+	return headers, nil
+}
+
+// Image img[x][y] as text rows
+func rows(img [][]fws.Cell) []string {
+	if len(img) == 0 {
+		return nil
+	}
+	lines := make([]string, len(img[0]))
+	for y := range lines {
+		var b strings.Builder
+		for x := range img {
+			c := img[x][y]
+			switch {
+			case c.Continuation:
+			case c.Grapheme != "":
+				b.WriteString(c.Grapheme)
+			case c.Ch < ' ' || c.Ch == 0x7F:
+				b.WriteByte(' ')
+			default:
+				b.WriteRune(c.Ch)
+			}
+		}
+		lines[y] = b.String()
+	}
+	return lines
+}
+
+// Image lines printed under request: text rows, and every cell if cells
+func image(img [][]fws.Cell, cells bool) []string {
+	var lines []string
+	for y, row := range rows(img) {
+		lines = append(lines, fmt.Sprintf("%3d |%s|", y, row))
+	}
+	if cells {
+		for y := 0; len(img) > 0 && y < len(img[0]); y++ {
+			for x := range img {
+				lines = append(lines, fmt.Sprintf("%3d,%-3d %v", x, y, img[x][y]))
+			}
+		}
+	}
+	return lines
+}
+
+// Window addressed by request
+func windowID(req fws.Request) (fws.ID, bool) {
+	switch req := req.(type) {
+	case *fws.GetRequest:
+		return req.Id, true
+	case *fws.ReplyCreationRequest:
+		return req.Id, true
+	case *fws.ReplyGetRequest:
+		return req.Id, true
+	case *fws.EventRequest:
+		return req.Id, true
+	case *fws.DrawRequest:
+		return req.Id, true
+	case *fws.DrawFillRequest:
+		return req.Id, true
+	case *fws.DrawRectRequest:
+		return req.Id, true
+	case *fws.PackedFillRequest:
+		return req.Id, true
+	case *fws.DrawTextRequest:
+		return req.Id, true
+	case *fws.LinkRequest:
+		return req.Id, true
+	case *fws.RenderRequest:
+		return req.Id, true
+	case *fws.ResizeRequest:
+		return req.Id, true
+	case *fws.DeleteRequest:
+		return req.Id, true
+	case *fws.MoveRequest:
+		return req.Id, true
+	case *fws.FocusRequest:
+		return req.Id, true
+	case *fws.UnfocusRequest:
+		return req.Id, true
+	}
+	return 0, false
+}
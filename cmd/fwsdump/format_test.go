@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := parseHeaders("draw, render,DRAW_TEXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 3 || !headers[fws.DRAW] || !headers[fws.RENDER] || !headers[fws.DRAW_TEXT] {
+		t.Errorf("Headers: got %v\n", headers)
+	}
+	if _, err := parseHeaders("DRAW,PAINT"); err == nil || !strings.Contains(err.Error(), "PAINT") {
+		t.Errorf("Unknown header: got %v\n", err)
+	}
+}
+
+func TestImage(t *testing.T) {
+	img := [][]fws.Cell{
+		{{Ch: '世'}, {Ch: 'a'}},
+		{{Continuation: true}, {Ch: '\n'}},
+	}
+	expected := []string{
+		"  0 |世|",
+		"  1 |a |",
+		"  0,0   '世' fg=none bg=none",
+		"  1,0   cont fg=none bg=none",
+		"  0,1   'a' fg=none bg=none",
+		"  1,1   '\\n' fg=none bg=none",
+	}
+	got := image(img, true)
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Image: expected\n%s\ngot\n%s\n", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+	if got := image(img, false); len(got) != 2 {
+		t.Errorf("Image rows: got %q\n", got)
+	}
+}
@@ -0,0 +1,301 @@
+// Command fwsdump prints FWS protocol traffic in readable form.
+//
+// As proxy it listens for apps and forwards their connections to window
+// server, printing every message passing in both directions:
+//
+//	fwsdump -listen /tmp/fwsdump.sock [-server /tmp/fws_server.sock] [-w session.fwsrec]
+//
+// It also reads recordings made by -w flag or record package:
+//
+//	fwsdump -r session.fwsrec -id 3,4 -type DRAW,RENDER -cells
+//
+// Every line shows time since recording start, connection number,
+// direction, sequence number, reply reference and decoded message.
+// Images are followed by their text rows, and with -cells by every cell
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/Nekhaevalex/fwsprotocol/record"
+)
+
+// Shown messages
+type filter struct {
+	ids     map[fws.ID]bool     // Addressed windows, messages without window are hidden
+	headers map[fws.Header]bool // Message types
+	pids    map[int]bool        // Pids declared by app windows, connections before NEW are hidden
+}
+
+// Parses comma separated numbers
+func parseNumbers(list string) ([]int, error) {
+	var numbers []int
+	for _, s := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, nil
+}
+
+// Writes readable form of recording entries
+type dumper struct {
+	out    io.Writer
+	filter filter
+	cells  bool                     // Every cell of images is printed
+	pids   map[uint32]int           // Pids declared by connections
+	unpack map[uint32]*fws.Unpacker // Delta references of connections
+}
+
+func newDumper(out io.Writer, f filter, cells bool) *dumper {
+	return &dumper{
+		out:    out,
+		filter: f,
+		cells:  cells,
+		pids:   make(map[uint32]int),
+		unpack: make(map[uint32]*fws.Unpacker),
+	}
+}
+
+func (d *dumper) shown(e record.Entry, header fws.Header, req fws.Request) bool {
+	if d.filter.headers != nil && !d.filter.headers[header] {
+		return false
+	}
+	if d.filter.pids != nil {
+		pid, ok := d.pids[e.Conn]
+		if !ok || !d.filter.pids[pid] {
+			return false
+		}
+	}
+	if d.filter.ids != nil {
+		id, ok := windowID(req)
+		if !ok || !d.filter.ids[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *dumper) dump(e record.Entry) error {
+	msg := e.Frame.Msg
+	if len(msg) == 0 {
+		return nil
+	}
+	header := fws.Header(msg[0])
+	req, err := fws.DecodeMsg(msg)
+	var img [][]fws.Cell
+	// Connection state is followed for every message, shown or not
+	switch r := req.(type) {
+	case *fws.NewWindowRequest:
+		d.pids[e.Conn] = r.Pid
+	case *fws.DrawFillRequest:
+		img = r.Img
+	case *fws.DrawRectRequest:
+		img = r.Img
+	case *fws.DrawTextRequest:
+		img = r.Rect().Img
+	case *fws.PackedFillRequest:
+		if d.unpack[e.Conn] == nil {
+			d.unpack[e.Conn] = fws.NewUnpacker()
+		}
+		fill, uerr := d.unpack[e.Conn].Unpack(r)
+		if uerr != nil {
+			err = uerr
+			break
+		}
+		img = fill.Img
+	}
+	if !d.shown(e, header, req) {
+		return nil
+	}
+	var desc interface{} = header
+	if req != nil {
+		desc = req
+	}
+	line := fmt.Sprintf("%10.6f %3d %s %5d %5d %v", e.Time.Seconds(), e.Conn, e.Dir, e.Frame.Seq, e.Frame.Ref, desc)
+	if err != nil {
+		line += fmt.Sprintf(" error=%q", err.Error())
+	}
+	if _, err := fmt.Fprintln(d.out, strings.TrimRight(line, " ")); err != nil {
+		return err
+	}
+	if _, ok := req.(*fws.DrawTextRequest); ok {
+		// Text is shown in request line already
+		return nil
+	}
+	for _, l := range image(img, d.cells) {
+		if _, err := fmt.Fprintln(d.out, "           "+l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dumps recording until its end
+func (d *dumper) dumpAll(r *record.Reader) error {
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := d.dump(e); err != nil {
+			return err
+		}
+	}
+}
+
+// Forwards app connection to server, recording its traffic
+func forward(app net.Conn, server string, rec *record.Recorder) {
+	srv, err := net.Dial("unix", server)
+	if err != nil {
+		log.Print(err)
+		app.Close()
+		return
+	}
+	conn := rec.Conn(app, record.ToServer)
+	done := make(chan struct{})
+	go func() {
+		io.Copy(srv, conn)
+		srv.Close()
+		close(done)
+	}()
+	io.Copy(conn, srv)
+	conn.Close()
+	<-done
+}
+
+// Accepts apps until listener is closed
+func proxy(ln net.Listener, server string, rec *record.Recorder) error {
+	for {
+		app, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go forward(app, server, rec)
+	}
+}
+
+// Proxies apps connecting to listen until interrupted.
+// Traffic is decoded by dumper through pipe and written to recording
+// file if set
+func runProxy(listen, server, file string, d *dumper) error {
+	pr, pw := io.Pipe()
+	go func() {
+		r, err := record.NewReader(pr)
+		if err == nil {
+			err = d.dumpAll(r)
+		}
+		pr.CloseWithError(err)
+	}()
+	var w io.Writer = pw
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = io.MultiWriter(pw, f)
+	}
+	rec, err := record.NewRecorder(w)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("unix", listen)
+	if err != nil {
+		return err
+	}
+	// Socket file is removed when listener is closed
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		ln.Close()
+	}()
+	if err := proxy(ln, server, rec); !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return rec.Err()
+}
+
+func main() {
+	listen := flag.String("listen", "", "socket to accept apps on")
+	server := flag.String("server", fws.FWS_SOCKET, "window server socket")
+	read := flag.String("r", "", "read recording instead of proxying")
+	write := flag.String("w", "", "also write proxied traffic to recording")
+	ids := flag.String("id", "", "show only messages addressing windows, comma separated")
+	types := flag.String("type", "", "show only message types, comma separated names")
+	pids := flag.String("pid", "", "show only connections of apps with pids, comma separated")
+	cells := flag.Bool("cells", false, "print every cell of images")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("fwsdump: ")
+
+	var f filter
+	if *ids != "" {
+		numbers, err := parseNumbers(*ids)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f.ids = make(map[fws.ID]bool)
+		for _, n := range numbers {
+			f.ids[fws.ID(n)] = true
+		}
+	}
+	if *types != "" {
+		headers, err := parseHeaders(*types)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f.headers = headers
+	}
+	if *pids != "" {
+		numbers, err := parseNumbers(*pids)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f.pids = make(map[int]bool)
+		for _, n := range numbers {
+			f.pids[n] = true
+		}
+	}
+	d := newDumper(os.Stdout, f, *cells)
+
+	switch {
+	case *read != "":
+		file, err := os.Open(*read)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		r, err := record.NewReader(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := d.dumpAll(r); err != nil {
+			log.Fatal(err)
+		}
+	case *listen != "":
+		if err := runProxy(*listen, *server, *write, d); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "fwsdump: either -listen or -r is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+}
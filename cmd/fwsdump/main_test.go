@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/Nekhaevalex/fwsprotocol/client"
+	"github.com/Nekhaevalex/fwsprotocol/record"
+	"github.com/Nekhaevalex/fwsprotocol/server"
+)
+
+// Recording of two apps, pids 10 and 20, windows 1 and 2
+func recording() []record.Entry {
+	entries := []struct {
+		conn uint32
+		dir  record.Direction
+		seq  uint32
+		ref  uint32
+		req  fws.Request
+	}{
+		{1, record.ToServer, 1, 0, &fws.NewWindowRequest{Pid: 10, Width: 2, Height: 1}},
+		{1, record.ToApp, 1, 1, &fws.ReplyCreationRequest{Id: 1}},
+		{2, record.ToServer, 1, 0, &fws.NewWindowRequest{Pid: 20, Width: 2, Height: 1}},
+		{2, record.ToApp, 1, 1, &fws.ReplyCreationRequest{Id: 2}},
+		{1, record.ToServer, 2, 0, &fws.DrawFillRequest{Id: 1, Width: 2, Height: 1, Img: [][]fws.Cell{{{Ch: 'a'}}, {{Ch: 'b'}}}}},
+		{2, record.ToServer, 2, 0, &fws.DrawRequest{Id: 2, X: 1, Cell: fws.Cell{Ch: 'c'}}},
+		{2, record.ToServer, 3, 0, &fws.RenderRequest{Id: 2}},
+		{1, record.ToServer, 3, 0, &fws.RenderRequest{Id: 1}},
+	}
+	var recorded []record.Entry
+	for i, e := range entries {
+		recorded = append(recorded, record.Entry{
+			Time:  time.Duration(i) * time.Millisecond,
+			Conn:  e.conn,
+			Dir:   e.dir,
+			Frame: fws.Frame{Seq: e.seq, Ref: e.ref, Msg: e.req.Encode()},
+		})
+	}
+	return recorded
+}
+
+func dumpLines(t *testing.T, f filter, cells bool) []string {
+	var out bytes.Buffer
+	d := newDumper(&out, f, cells)
+	for _, e := range recording() {
+		if err := d.dump(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+func TestDump(t *testing.T) {
+	lines := dumpLines(t, filter{}, false)
+	if len(lines) != 9 {
+		t.Fatalf("Dump: expected 9 lines, got\n%s\n", strings.Join(lines, "\n"))
+	}
+	expected := "  0.004000   1 app->server     2     0 DRAW_FILL id=1 width=2 height=1"
+	if lines[4] != expected {
+		t.Errorf("Dump line: expected\n%q\ngot\n%q\n", expected, lines[4])
+	}
+	if strings.TrimSpace(lines[5]) != "0 |ab|" {
+		t.Errorf("Image row: got %q\n", lines[5])
+	}
+	if !strings.Contains(lines[1], "server->app") || !strings.HasSuffix(lines[1], "REPLY_CREATION id=1") {
+		t.Errorf("Reply line: got %q\n", lines[1])
+	}
+}
+
+func TestDumpFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   filter
+		expected []string // Last words of lines
+	}{
+		{"window", filter{ids: map[fws.ID]bool{2: true}}, []string{"id=2", "bg=none", "id=2"}},
+		{"type", filter{headers: map[fws.Header]bool{fws.RENDER: true}}, []string{"id=2", "id=1"}},
+		{"pid", filter{pids: map[int]bool{10: true}}, []string{"layer=ANY", "id=1", "height=1", "|ab|", "id=1"}},
+		{"all", filter{ids: map[fws.ID]bool{1: true}, headers: map[fws.Header]bool{fws.RENDER: true}, pids: map[int]bool{20: true}}, nil},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		d := newDumper(&out, test.filter, false)
+		for _, e := range recording() {
+			d.dump(e)
+		}
+		var got []string
+		for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if fields := strings.Fields(l); len(fields) > 0 {
+				got = append(got, fields[len(fields)-1])
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("Filter %s: expected %v, got\n%s\n", test.name, test.expected, out.String())
+		}
+	}
+}
+
+// Window server counting rendered windows
+type renderCounter struct {
+	server.BaseHandler
+	mu      sync.Mutex
+	renders int
+}
+
+func (h *renderCounter) OnNewWindow(c *server.Conn, req *fws.NewWindowRequest) (fws.ID, error) {
+	return 7, nil
+}
+
+func (h *renderCounter) OnRender(c *server.Conn, req *fws.RenderRequest) {
+	h.mu.Lock()
+	h.renders++
+	h.mu.Unlock()
+}
+
+func TestProxy(t *testing.T) {
+	dir := t.TempDir()
+	l, err := server.Listen(filepath.Join(dir, "server.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	h := &renderCounter{}
+	go l.Serve(h)
+
+	var buf bytes.Buffer
+	rec, err := record.NewRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("unix", filepath.Join(dir, "dump.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go proxy(ln, filepath.Join(dir, "server.sock"), rec)
+
+	conn, err := client.Dial(context.Background(), filepath.Join(dir, "dump.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := conn.NewWindow(client.WindowOptions{Width: 3, Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.ID() != 7 {
+		t.Errorf("Proxied window ID: expected 7, got %d\n", w.ID())
+	}
+	w.Render()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		h.mu.Lock()
+		renders := h.renders
+		h.mu.Unlock()
+		if renders == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Render was not proxied")
+		}
+	}
+	conn.Close()
+	ln.Close()
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := record.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := newDumper(&out, filter{}, false).dumpAll(r); err != nil {
+		t.Fatal(err)
+	}
+	var headers []string
+	for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		headers = append(headers, strings.Fields(l)[5])
+	}
+	expected := []string{"HELLO", "REPLY_HELLO", "NEW", "REPLY_CREATION", "RENDER"}
+	if strings.Join(headers, " ") != strings.Join(expected, " ") {
+		t.Errorf("Proxied messages: expected %v, got\n%s\n", expected, out.String())
+	}
+}
@@ -0,0 +1,154 @@
+package fwsprotocol
+
+// Colors of xterm default 16 color palette
+var systemColors = [16]Color{
+	{255, 0, 0, 0},
+	{255, 205, 0, 0},
+	{255, 0, 205, 0},
+	{255, 205, 205, 0},
+	{255, 0, 0, 238},
+	{255, 205, 0, 205},
+	{255, 0, 205, 205},
+	{255, 229, 229, 229},
+	{255, 127, 127, 127},
+	{255, 255, 0, 0},
+	{255, 0, 255, 0},
+	{255, 255, 255, 0},
+	{255, 92, 92, 255},
+	{255, 255, 0, 255},
+	{255, 0, 255, 255},
+	{255, 255, 255, 255},
+}
+
+// Channel levels of 6x6x6 color cube of 256 color palette
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// Gray ramp of 256 color palette: 24 levels from 8 to 238
+const (
+	grayFirst  = 232
+	grayLevels = 24
+)
+
+func grayLevel(i int) int {
+	return 8 + 10*i
+}
+
+// Squared color distance weighted by mean red level ("redmean"),
+// closer to perceived difference than plain RGB distance
+func distance(a, b Color) int {
+	rmean := (int(a.R) + int(b.R)) / 2
+	dr := int(a.R) - int(b.R)
+	dg := int(a.G) - int(b.G)
+	db := int(a.B) - int(b.B)
+	return ((512+rmean)*dr*dr)>>8 + 4*dg*dg + ((767-rmean)*db*db)>>8
+}
+
+// Index of nearest color in palette
+func nearest(c Color, palette []Color) int {
+	best, bestDist := 0, -1
+	for i, p := range palette {
+		if d := distance(c, p); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Index of nearest cube level
+func cubeIndex(v uint8) int {
+	best := 0
+	for i, level := range cubeLevels {
+		if abs(level-int(v)) < abs(cubeLevels[best]-int(v)) {
+			best = i
+		}
+	}
+	return best
+}
+
+// Rec. 601 luma
+func (a Color) luma() int {
+	return (299*int(a.R) + 587*int(a.G) + 114*int(a.B) + 500) / 1000
+}
+
+func gray(v int) Color {
+	return Color{255, uint8(v), uint8(v), uint8(v)}
+}
+
+// Nearest gray ramp level index
+func grayIndex(v int) int {
+	i := (v - 3) / 10
+	if i < 0 {
+		i = 0
+	}
+	if i >= grayLevels {
+		i = grayLevels - 1
+	}
+	return i
+}
+
+// Index of nearest 256 color palette color available in output mode,
+// -1 for fully transparent color, which leaves terminal default color.
+// OutputRGB and OutputCurrent use color cube and gray ramp
+func (a Color) Index(mode OutputMode) int {
+	if a.A == 0 {
+		return -1
+	}
+	switch mode {
+	case OutputNormal:
+		return a.To16Mode()
+	case Output216:
+		return 16 + a.To216Mode() - 1
+	case OutputGrayscale:
+		return a.ToGrayscaleMode()
+	default:
+		return a.To256Mode()
+	}
+}
+
+// Nearest of 8 basic colors, palette index 0 to 7
+func (a Color) To8Mode() int {
+	return nearest(a, systemColors[:8])
+}
+
+// Nearest of 16 colors of OutputNormal, palette index 0 to 15
+func (a Color) To16Mode() int {
+	return nearest(a, systemColors[:])
+}
+
+// Output216 color index, 1 to 216
+func (a Color) To216Mode() int {
+	return cubeIndex(a.R)*36 + cubeIndex(a.G)*6 + cubeIndex(a.B) + 1
+}
+
+// Nearest color of color cube or gray ramp, palette index 16 to 255.
+// System colors are skipped as terminals are free to redefine them
+func (a Color) To256Mode() int {
+	r, g, b := cubeIndex(a.R), cubeIndex(a.G), cubeIndex(a.B)
+	cube := Color{255, uint8(cubeLevels[r]), uint8(cubeLevels[g]), uint8(cubeLevels[b])}
+	i := grayIndex(a.luma())
+	if distance(a, gray(grayLevel(i))) < distance(a, cube) {
+		return grayFirst + i
+	}
+	return 16 + r*36 + g*6 + b
+}
+
+// Nearest color of OutputGrayscale: palette index 16 for black,
+// 231 for white or gray ramp index 232 to 255
+func (a Color) ToGrayscaleMode() int {
+	v := a.luma()
+	i := grayIndex(v)
+	switch {
+	case v < grayLevel(i) && v < grayLevel(i)-v:
+		return 16
+	case v > grayLevel(i) && 255-v < v-grayLevel(i):
+		return 231
+	}
+	return grayFirst + i
+}
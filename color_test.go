@@ -0,0 +1,105 @@
+package fwsprotocol
+
+import "testing"
+
+func TestColorIndex(t *testing.T) {
+	var (
+		black  = Color{255, 0, 0, 0}
+		white  = Color{255, 255, 255, 255}
+		red    = Color{255, 255, 0, 0}
+		gray   = Color{255, 128, 128, 128}
+		green  = Color{255, 100, 200, 50}
+		none   = Color{0, 255, 255, 255}
+		silver = Color{255, 230, 230, 230}
+	)
+	tests := []struct {
+		mode     OutputMode
+		color    Color
+		expected int
+	}{
+		{OutputNormal, black, 0},
+		{OutputNormal, white, 15},
+		{OutputNormal, silver, 7},
+		{OutputNormal, red, 9},
+		{OutputNormal, Color{255, 200, 0, 0}, 1},
+		{OutputNormal, gray, 8},
+		{OutputNormal, Color{255, 90, 90, 250}, 12},
+		{OutputNormal, none, -1},
+		{Output256, black, 16},
+		{Output256, white, 231},
+		{Output256, red, 196},
+		{Output256, gray, 244},
+		{Output256, Color{255, 8, 8, 8}, 232},
+		{Output256, green, 77},
+		{Output256, none, -1},
+		{Output216, black, 16},
+		{Output216, white, 231},
+		{Output216, red, 196},
+		{Output216, gray, 102},
+		{Output216, green, 77},
+		{Output216, none, -1},
+		{OutputGrayscale, black, 16},
+		{OutputGrayscale, white, 231},
+		{OutputGrayscale, gray, 244},
+		{OutputGrayscale, red, 239},
+		{OutputGrayscale, none, -1},
+		{OutputRGB, black, 16},
+		{OutputRGB, green, 77},
+		{OutputRGB, none, -1},
+	}
+	for _, test := range tests {
+		if got := test.color.Index(test.mode); got != test.expected {
+			t.Errorf("Mode %d color %v: expected %d, got %d\n", test.mode, test.color, test.expected, got)
+		}
+	}
+}
+
+func TestColorTo8Mode(t *testing.T) {
+	tests := []struct {
+		color    Color
+		expected int
+	}{
+		{Color{255, 255, 0, 0}, 1},
+		{Color{255, 180, 180, 180}, 7},
+		{Color{255, 255, 255, 255}, 7},
+		{Color{255, 20, 20, 20}, 0},
+		{Color{255, 0, 180, 190}, 6},
+	}
+	for _, test := range tests {
+		if got := test.color.To8Mode(); got != test.expected {
+			t.Errorf("Color %v: expected %d, got %d\n", test.color, test.expected, got)
+		}
+	}
+}
+
+// Every palette color is mapped to itself
+func TestColorIndexExact(t *testing.T) {
+	for i, c := range systemColors {
+		if got := c.To16Mode(); got != i {
+			t.Errorf("System color %d: got %d\n", i, got)
+		}
+	}
+	for r := range cubeLevels {
+		for g := range cubeLevels {
+			for b := range cubeLevels {
+				c := Color{255, uint8(cubeLevels[r]), uint8(cubeLevels[g]), uint8(cubeLevels[b])}
+				expected := r*36 + g*6 + b + 1
+				if got := c.To216Mode(); got != expected {
+					t.Errorf("Cube color %v: expected %d, got %d\n", c, expected, got)
+				}
+				if got := c.To256Mode(); got != expected+15 {
+					t.Errorf("Cube color %v: expected %d, got %d\n", c, expected+15, got)
+				}
+			}
+		}
+	}
+	for i := 0; i < grayLevels; i++ {
+		c := gray(grayLevel(i))
+		if got := c.To256Mode(); got != grayFirst+i {
+			t.Errorf("Gray %v: expected %d, got %d\n", c, grayFirst+i, got)
+		}
+		if got := c.ToGrayscaleMode(); got != grayFirst+i {
+			t.Errorf("Gray %v: expected %d, got %d\n", c, grayFirst+i, got)
+		}
+	}
+}
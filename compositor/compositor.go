@@ -0,0 +1,428 @@
+// Window compositor
+// Keeps window images and stacks them into final screen image
+package compositor
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+var (
+	ErrUnknownWindow = errors.New("compositor: unknown window")
+	ErrWindowExists  = errors.New("compositor: window already exists")
+)
+
+// Screen cell shown where no window covers it
+var DefaultBackground = fws.Cell{
+	Ch: ' ',
+	Fg: fws.Color{A: 255, R: 255, G: 255, B: 255},
+	Bg: fws.Color{A: 255},
+}
+
+// Largest window area accepted by default, as large as the biggest image
+// app can send
+const DefaultMaxWindowCells = fws.MaxPackedCells
+
+// Window buffer
+type window struct {
+	id            fws.ID
+	x, y          int // Global position of top left corner, may be offscreen
+	width, height int
+	layer         fws.LayerAttribute
+	img           [][]fws.Cell      // Window image, img[x][y]
+	links         map[uint32]uint32 // Window link indices and their global links
+}
+
+func newImg(width, height int) [][]fws.Cell {
+	img := make([][]fws.Cell, width)
+	for i := range img {
+		img[i] = make([]fws.Cell, height)
+	}
+	return img
+}
+
+// Stacking rank of layer, windows with lower rank are drawn first
+func rank(layer fws.LayerAttribute) int {
+	switch layer {
+	case fws.BOTTOM:
+		return 0
+	case fws.TOP:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// Window stack.
+// Windows are blended back-to-front with Cell.Over: BOTTOM windows first,
+// then ANY windows, then TOP windows, each group in raising order.
+// New windows start fully transparent.
+// Safe for concurrent use
+type Compositor struct {
+	mu            sync.Mutex
+	width, height int
+	background    fws.Cell
+	maxCells      int // Largest window area
+	windows       map[fws.ID]*window
+	stack         []*window         // Back to front, ignoring layers
+	front         [][]fws.Cell      // Screen image last sent to backend, nil before first render
+	back          [][]fws.Cell      // Scratch screen image for composing damaged areas
+	damage        []rect            // Screen areas changed since last render
+	links         map[uint32]string // URIs of global links
+	lastLink      uint32
+}
+
+// New compositor for screen of specified size
+func New(width, height int) *Compositor {
+	return &Compositor{
+		width:      width,
+		height:     height,
+		background: DefaultBackground,
+		maxCells:   DefaultMaxWindowCells,
+		windows:    make(map[fws.ID]*window),
+		links:      make(map[uint32]string),
+	}
+}
+
+// Screen size
+func (c *Compositor) Size() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.width, c.height
+}
+
+// Changes screen size, windows keep their positions.
+// Next render repaints whole screen
+func (c *Compositor) SetSize(width, height int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.width, c.height = width, height
+	c.front, c.back = nil, nil
+	c.damage = nil
+}
+
+// Sets cell shown where no window covers the screen.
+// Should be opaque, windows are blended over it
+func (c *Compositor) SetBackground(cell fws.Cell) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.background = cell
+	c.damageAll()
+}
+
+// Limits window area, sizes come from apps and window images are
+// allocated at once. Existing windows are kept
+func (c *Compositor) SetMaxWindowCells(cells int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxCells = cells
+}
+
+// Checks window size against area limit before anything is allocated.
+// Sides are limited too, window with zero area still allocates columns
+func (c *Compositor) checkSize(width, height int) error {
+	if width < 0 || height < 0 || width > c.maxCells || height > c.maxCells ||
+		(width != 0 && height > c.maxCells/width) {
+		return fmt.Errorf("%w: %dx%d", fws.ErrBadDimensions, width, height)
+	}
+	return nil
+}
+
+// Adds window on top of its layer
+func (c *Compositor) Add(id fws.ID, req *fws.NewWindowRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkSize(req.Width, req.Height); err != nil {
+		return err
+	}
+	if _, ok := c.windows[id]; ok {
+		return fmt.Errorf("%w: %d", ErrWindowExists, id)
+	}
+	w := &window{
+		id:     id,
+		x:      req.X,
+		y:      req.Y,
+		width:  req.Width,
+		height: req.Height,
+		layer:  req.LayerAttr,
+		img:    newImg(req.Width, req.Height),
+	}
+	c.windows[id] = w
+	c.stack = append(c.stack, w)
+	c.damageWindow(w)
+	return nil
+}
+
+func (c *Compositor) window(id fws.ID) (*window, error) {
+	w, ok := c.windows[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownWindow, id)
+	}
+	return w, nil
+}
+
+func (c *Compositor) unstack(w *window) {
+	for i, s := range c.stack {
+		if s == w {
+			c.stack = append(c.stack[:i], c.stack[i+1:]...)
+			return
+		}
+	}
+}
+
+// Removes window from stack
+func (c *Compositor) Remove(id fws.ID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, err := c.window(id)
+	if err != nil {
+		return err
+	}
+	delete(c.windows, id)
+	c.unstack(w)
+	c.unlink(w)
+	c.damageWindow(w)
+	return nil
+}
+
+// Puts window on top of its layer
+func (c *Compositor) Raise(id fws.ID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, err := c.window(id)
+	if err != nil {
+		return err
+	}
+	c.unstack(w)
+	c.stack = append(c.stack, w)
+	c.damageWindow(w)
+	return nil
+}
+
+// Moves window top left corner to global position, which may be offscreen
+func (c *Compositor) Move(id fws.ID, x, y int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, err := c.window(id)
+	if err != nil {
+		return err
+	}
+	c.damageWindow(w)
+	w.x, w.y = x, y
+	c.damageWindow(w)
+	return nil
+}
+
+// Resizes window keeping image in overlapping area
+func (c *Compositor) Resize(id fws.ID, width, height int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.checkSize(width, height); err != nil {
+		return err
+	}
+	w, err := c.window(id)
+	if err != nil {
+		return err
+	}
+	img := newImg(width, height)
+	for i := 0; i < width && i < w.width; i++ {
+		copy(img[i], w.img[i])
+	}
+	c.damageWindow(w)
+	w.img, w.width, w.height = img, width, height
+	w.fixEdges()
+	c.damageWindow(w)
+	return nil
+}
+
+// Sets single cell in window local coordinates.
+// Cells outside window are clipped
+func (c *Compositor) Draw(id fws.ID, x, y int, cell fws.Cell) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, err := c.window(id)
+	if err != nil {
+		return err
+	}
+	if x >= 0 && x < w.width && y >= 0 && y < w.height {
+		w.put(x, y, cell)
+		// Neighbours may have become halves of wide glyph or lost them
+		c.damageRect(rect{w.x + x - 1, w.y + y, 3, 1})
+	}
+	return nil
+}
+
+// Copies image into window starting with its top left corner.
+// Parts of image outside window are clipped
+func (c *Compositor) DrawFill(id fws.ID, img [][]fws.Cell) error {
+	return c.DrawRect(id, 0, 0, img)
+}
+
+// Copies image into window with top left corner at local coordinates.
+// Parts of image outside window are clipped
+func (c *Compositor) DrawRect(id fws.ID, x, y int, img [][]fws.Cell) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, err := c.window(id)
+	if err != nil {
+		return err
+	}
+	var drawn rect
+	for i := range img {
+		column := rect{x + i, y, 1, len(img[i])}.intersect(rect{0, 0, w.width, w.height})
+		if column.empty() {
+			continue
+		}
+		for j := column.y; j < column.y+column.height; j++ {
+			w.put(column.x, j, img[i][j-y])
+		}
+		drawn = drawn.union(column)
+	}
+	if !drawn.empty() {
+		c.damageRect(rect{w.x + drawn.x - 1, w.y + drawn.y, drawn.width + 2, drawn.height})
+	}
+	return nil
+}
+
+// Cell of window image in local coordinates
+func (c *Compositor) Cell(id fws.ID, x, y int) (fws.Cell, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, err := c.window(id)
+	if err != nil {
+		return fws.Cell{}, err
+	}
+	if x < 0 || x >= w.width || y < 0 || y >= w.height {
+		return fws.Cell{}, nil
+	}
+	return w.img[x][y], nil
+}
+
+// Applies window request to stack.
+// Focus raises window, requests not changing window images are ignored
+func (c *Compositor) Apply(req fws.Request) error {
+	switch req := req.(type) {
+	case *fws.DrawRequest:
+		return c.Draw(req.Id, req.X, req.Y, req.Cell)
+	case *fws.DrawFillRequest:
+		return c.DrawFill(req.Id, req.Img)
+	case *fws.DrawRectRequest:
+		return c.DrawRect(req.Id, req.X, req.Y, req.Img)
+	case *fws.DrawTextRequest:
+		return c.DrawRect(req.Id, req.X, req.Y, req.Rect().Img)
+	case *fws.LinkRequest:
+		return c.Link(req.Id, req.Link, req.URI)
+	case *fws.MoveRequest:
+		return c.Move(req.Id, req.X, req.Y)
+	case *fws.ResizeRequest:
+		return c.Resize(req.Id, req.Width, req.Height)
+	case *fws.DeleteRequest:
+		return c.Remove(req.Id)
+	case *fws.FocusRequest:
+		return c.Raise(req.Id)
+	}
+	return nil
+}
+
+// Windows in drawing order, back to front
+func (c *Compositor) ordered() []*window {
+	ordered := make([]*window, 0, len(c.stack))
+	for r := 0; r < 3; r++ {
+		for _, w := range c.stack {
+			if rank(w.layer) == r {
+				ordered = append(ordered, w)
+			}
+		}
+	}
+	return ordered
+}
+
+// Window IDs in drawing order, back to front
+func (c *Compositor) Stack() []fws.ID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ids []fws.ID
+	for _, w := range c.ordered() {
+		ids = append(ids, w.id)
+	}
+	return ids
+}
+
+// Blends windows into final screen image, screen[x][y].
+// Cell links are global, see URI
+func (c *Compositor) Compose() [][]fws.Cell {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	raw := newImg(c.width, c.height)
+	c.compose(raw, rect{0, 0, c.width, c.height})
+	screen := newImg(c.width, c.height)
+	for x := range screen {
+		for y := range screen[x] {
+			screen[x][y] = fixed(raw, x, y)
+		}
+	}
+	return screen
+}
+
+// Blends windows into area of screen image
+func (c *Compositor) compose(screen [][]fws.Cell, area rect) {
+	area = area.intersect(rect{0, 0, c.width, c.height})
+	for x := area.x; x < area.x+area.width; x++ {
+		for y := area.y; y < area.y+area.height; y++ {
+			screen[x][y] = c.background
+		}
+	}
+	for _, w := range c.ordered() {
+		r := area.intersect(w.rect())
+		for x := r.x; x < r.x+r.width; x++ {
+			for y := r.y; y < r.y+r.height; y++ {
+				cell := w.global(w.img[x-w.x][y-w.y])
+				screen[x][y] = cell.Over(screen[x][y])
+			}
+		}
+	}
+}
+
+// Sends cells changed since previous render to backend and flushes it.
+// First render after creation or screen resize sends every cell.
+// Linker backends get URI of every link before cells refering to it
+func (c *Compositor) Render(b Backend) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	damage := c.damage
+	c.damage = nil
+	full := c.front == nil
+	if full {
+		c.front = newImg(c.width, c.height)
+		c.back = newImg(c.width, c.height)
+		damage = []rect{{0, 0, c.width, c.height}}
+	}
+	// Back image keeps raw composition of the whole screen, so wide
+	// glyph halves next to damaged area are fixed up with it as well
+	for _, area := range damage {
+		c.compose(c.back, area)
+	}
+	linker, _ := b.(Linker)
+	sent := make(map[uint32]bool)
+	for _, area := range damage {
+		area = rect{area.x - 1, area.y, area.width + 2, area.height}.intersect(rect{0, 0, c.width, c.height})
+		for x := area.x; x < area.x+area.width; x++ {
+			for y := area.y; y < area.y+area.height; y++ {
+				cell := fixed(c.back, x, y)
+				if !full && cell == c.front[x][y] {
+					continue
+				}
+				c.front[x][y] = cell
+				if linker != nil && cell.Link != 0 && !sent[cell.Link] {
+					linker.SetLink(cell.Link, c.links[cell.Link])
+					sent[cell.Link] = true
+				}
+				b.SetCell(x, y, cell)
+			}
+		}
+	}
+	return b.Flush()
+}
@@ -0,0 +1,269 @@
+package compositor
+
+import (
+	"errors"
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+var (
+	white = fws.Color{A: 255, R: 255, G: 255, B: 255}
+	black = fws.Color{A: 255}
+	red   = fws.Color{A: 255, R: 255}
+	blue  = fws.Color{A: 255, B: 255}
+)
+
+func solid(ch rune, bg fws.Color) fws.Cell {
+	return fws.Cell{Ch: ch, Fg: white, Bg: bg}
+}
+
+func fill(c *Compositor, id fws.ID, cell fws.Cell) {
+	width, height := 0, 0
+	c.mu.Lock()
+	if w, ok := c.windows[id]; ok {
+		width, height = w.width, w.height
+	}
+	c.mu.Unlock()
+	img := newImg(width, height)
+	for i := range img {
+		for j := range img[i] {
+			img[i][j] = cell
+		}
+	}
+	c.DrawFill(id, img)
+}
+
+func add(t *testing.T, c *Compositor, id fws.ID, req fws.NewWindowRequest) {
+	if err := c.Add(id, &req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Screen rows as strings
+func rows(screen [][]fws.Cell) []string {
+	if len(screen) == 0 {
+		return nil
+	}
+	rows := make([]string, len(screen[0]))
+	for y := range rows {
+		line := make([]rune, len(screen))
+		for x := range screen {
+			line[x] = screen[x][y].Ch
+		}
+		rows[y] = string(line)
+	}
+	return rows
+}
+
+func expectRows(t *testing.T, screen [][]fws.Cell, expected ...string) {
+	t.Helper()
+	got := rows(screen)
+	if len(got) != len(expected) {
+		t.Fatalf("Screen height: expected %d, got %d\n", len(expected), len(got))
+	}
+	for y := range expected {
+		if got[y] != expected[y] {
+			t.Errorf("Row %d: expected %q, got %q\n", y, expected[y], got[y])
+		}
+	}
+}
+
+func TestComposeStacking(t *testing.T) {
+	c := New(6, 3)
+	add(t, c, 1, fws.NewWindowRequest{X: 0, Y: 0, Width: 4, Height: 2})
+	add(t, c, 2, fws.NewWindowRequest{X: 2, Y: 1, Width: 4, Height: 2})
+	fill(c, 1, solid('a', red))
+	fill(c, 2, solid('b', blue))
+	expectRows(t, c.Compose(),
+		"aaaa  ",
+		"aabbbb",
+		"  bbbb")
+
+	if err := c.Apply(&fws.FocusRequest{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	expectRows(t, c.Compose(),
+		"aaaa  ",
+		"aaaabb",
+		"  bbbb")
+	if stack := c.Stack(); len(stack) != 2 || stack[0] != 2 || stack[1] != 1 {
+		t.Errorf("Stack order: expected [2 1], got %v\n", stack)
+	}
+}
+
+func TestComposeLayers(t *testing.T) {
+	c := New(3, 1)
+	add(t, c, 1, fws.NewWindowRequest{Width: 3, Height: 1, LayerAttr: fws.TOP})
+	add(t, c, 2, fws.NewWindowRequest{Width: 3, Height: 1, LayerAttr: fws.ANY})
+	add(t, c, 3, fws.NewWindowRequest{Width: 3, Height: 1, LayerAttr: fws.BOTTOM})
+	c.Draw(1, 0, 0, solid('t', red))
+	fill(c, 2, solid('a', red))
+	fill(c, 3, solid('b', red))
+	c.Raise(3)
+	c.Raise(2)
+	c.Draw(2, 2, 0, fws.Cell{})
+
+	expectRows(t, c.Compose(), "tab")
+	c.Remove(2)
+	expectRows(t, c.Compose(), "tbb")
+	stack := c.Stack()
+	if len(stack) != 2 || stack[0] != 3 || stack[1] != 1 {
+		t.Errorf("Stack order: expected [3 1], got %v\n", stack)
+	}
+}
+
+func TestComposeClipping(t *testing.T) {
+	c := New(4, 3)
+	add(t, c, 1, fws.NewWindowRequest{X: -2, Y: -1, Width: 3, Height: 2})
+	add(t, c, 2, fws.NewWindowRequest{X: 3, Y: 2, Width: 5, Height: 5})
+	add(t, c, 3, fws.NewWindowRequest{X: 10, Y: 0, Width: 2, Height: 2})
+	add(t, c, 4, fws.NewWindowRequest{X: -10, Y: -10, Width: 2, Height: 2})
+	for id := fws.ID(1); id <= 4; id++ {
+		fill(c, id, solid(rune('0'+id), red))
+	}
+	c.Draw(1, 2, 1, solid('x', red))
+	expectRows(t, c.Compose(),
+		"x   ",
+		"    ",
+		"   2")
+
+	c.Apply(&fws.MoveRequest{Id: 3, X: -1, Y: 1})
+	c.Apply(&fws.MoveRequest{Id: 1, X: 100, Y: 100})
+	expectRows(t, c.Compose(),
+		"    ",
+		"3   ",
+		"3  2")
+}
+
+func TestComposeBlending(t *testing.T) {
+	c := New(2, 1)
+	add(t, c, 1, fws.NewWindowRequest{Width: 2, Height: 1})
+	add(t, c, 2, fws.NewWindowRequest{Width: 2, Height: 1})
+	c.Draw(1, 0, 0, fws.Cell{Ch: 'u', Fg: white, Bg: red, Attribute: fws.Bold})
+	c.Draw(1, 1, 0, fws.Cell{Ch: 'u', Fg: white, Bg: red})
+	c.Draw(2, 0, 0, fws.Cell{Ch: ' ', Bg: fws.Color{A: 127, B: 255}})
+	c.Draw(2, 1, 0, fws.Cell{Ch: 'o', Fg: black, Bg: blue})
+
+	screen := c.Compose()
+	tinted := screen[0][0]
+	if tinted.Ch != 'u' || tinted.Attribute != fws.Bold {
+		t.Errorf("Glyph under translucent window lost: got %v\n", tinted)
+	}
+	if tinted.Bg.R == 0 || tinted.Bg.B == 0 || tinted.Bg.A != 255 {
+		t.Errorf("Background blending failed: got %v\n", tinted.Bg)
+	}
+	if screen[1][0] != (fws.Cell{Ch: 'o', Fg: black, Bg: blue}) {
+		t.Errorf("Opaque cell blending failed: got %v\n", screen[1][0])
+	}
+}
+
+func TestResizeKeepsImage(t *testing.T) {
+	c := New(3, 2)
+	add(t, c, 1, fws.NewWindowRequest{Width: 2, Height: 2})
+	fill(c, 1, solid('a', red))
+	if err := c.Apply(&fws.ResizeRequest{Id: 1, Width: 3, Height: 1}); err != nil {
+		t.Fatal(err)
+	}
+	expectRows(t, c.Compose(),
+		"aa ",
+		"   ")
+	if cell, _ := c.Cell(1, 1, 0); cell.Ch != 'a' {
+		t.Errorf("Cell after resize: expected %q, got %q\n", 'a', cell.Ch)
+	}
+	if cell, _ := c.Cell(1, 0, 1); cell != (fws.Cell{}) {
+		t.Errorf("Cell outside window: expected empty cell, got %v\n", cell)
+	}
+}
+
+func TestUnknownWindow(t *testing.T) {
+	c := New(1, 1)
+	add(t, c, 1, fws.NewWindowRequest{Width: 1, Height: 1})
+	if err := c.Add(1, &fws.NewWindowRequest{}); !errors.Is(err, ErrWindowExists) {
+		t.Errorf("Expected ErrWindowExists, got %v\n", err)
+	}
+	requests := []fws.Request{
+		&fws.DrawRequest{Id: 2},
+		&fws.DrawFillRequest{Id: 2},
+		&fws.MoveRequest{Id: 2},
+		&fws.ResizeRequest{Id: 2},
+		&fws.DeleteRequest{Id: 2},
+		&fws.FocusRequest{Id: 2},
+	}
+	for _, req := range requests {
+		if err := c.Apply(req); !errors.Is(err, ErrUnknownWindow) {
+			t.Errorf("%T: expected ErrUnknownWindow, got %v\n", req, err)
+		}
+	}
+	if err := c.Apply(&fws.RenderRequest{Id: 2}); err != nil {
+		t.Errorf("Render request: unexpected error %v\n", err)
+	}
+}
+
+func TestWindowSizeLimit(t *testing.T) {
+	c := New(1, 1)
+	sizes := [][2]int{{-1, 1}, {1 << 40, 1}, {1 << 31, 1 << 31}, {DefaultMaxWindowCells + 1, 1}, {1 << 40, 0}, {0, 1 << 40}}
+	for _, size := range sizes {
+		if err := c.Add(1, &fws.NewWindowRequest{Width: size[0], Height: size[1]}); !errors.Is(err, fws.ErrBadDimensions) {
+			t.Errorf("New %dx%d: expected ErrBadDimensions, got %v\n", size[0], size[1], err)
+		}
+	}
+	add(t, c, 1, fws.NewWindowRequest{Width: DefaultMaxWindowCells, Height: 1})
+	for _, size := range sizes {
+		if err := c.Resize(1, size[0], size[1]); !errors.Is(err, fws.ErrBadDimensions) {
+			t.Errorf("Resize %dx%d: expected ErrBadDimensions, got %v\n", size[0], size[1], err)
+		}
+	}
+	c.SetMaxWindowCells(6)
+	if err := c.Resize(1, 2, 3); err != nil {
+		t.Errorf("Resize within limit: unexpected error %v\n", err)
+	}
+	if err := c.Resize(1, 7, 1); !errors.Is(err, fws.ErrBadDimensions) {
+		t.Errorf("Resize over configured limit: expected ErrBadDimensions, got %v\n", err)
+	}
+}
+
+func TestDrawRectClipping(t *testing.T) {
+	c := New(5, 3)
+	add(t, c, 1, fws.NewWindowRequest{X: 1, Y: 0, Width: 3, Height: 3})
+	img := [][]fws.Cell{
+		{solid('a', red), solid('b', red)},
+		{solid('c', red), solid('d', red)},
+	}
+	requests := []*fws.DrawRectRequest{
+		{Id: 1, X: 1, Y: 1, Width: 2, Height: 2, Img: img},
+		{Id: 1, X: -1, Y: -1, Width: 2, Height: 2, Img: img},
+		{Id: 1, X: 2, Y: -1, Width: 2, Height: 2, Img: img},
+		{Id: 1, X: 100, Y: 100, Width: 2, Height: 2, Img: img},
+		{Id: 1, X: -100, Y: 0, Width: 2, Height: 2, Img: img},
+	}
+	for _, req := range requests {
+		if err := c.Apply(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectRows(t, c.Compose(),
+		" d b ",
+		"  ac ",
+		"  bd ")
+}
+
+func TestDrawText(t *testing.T) {
+	c := New(6, 1)
+	add(t, c, 1, fws.NewWindowRequest{X: 1, Width: 4, Height: 1})
+	if err := c.Apply(&fws.DrawTextRequest{Id: 1, X: -1, Text: "label", Bg: red}); err != nil {
+		t.Fatal(err)
+	}
+	expectRows(t, c.Compose(), " abel ")
+}
+
+func TestRenderDrawRect(t *testing.T) {
+	c := New(10, 10)
+	b := newRecordingBackend()
+	add(t, c, 1, fws.NewWindowRequest{X: 2, Y: 2, Width: 4, Height: 4})
+	render(t, c, b)
+	img := [][]fws.Cell{{solid('a', red)}, {solid('b', red)}, {solid('c', red)}}
+	c.Apply(&fws.DrawRectRequest{Id: 1, X: 2, Y: 3, Width: 3, Height: 1, Img: img})
+	render(t, c, b)
+	expectFlushed(t, b, [2]int{4, 5}, [2]int{5, 5})
+}
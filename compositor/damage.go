@@ -0,0 +1,104 @@
+package compositor
+
+import fws "github.com/Nekhaevalex/fwsprotocol"
+
+// Output device receiving composed screen cells
+type Backend interface {
+	SetCell(x, y int, cell fws.Cell) // Sets screen cell, may be buffered until Flush
+	Flush() error                    // Shows cells set since previous flush
+}
+
+// Damage rectangles kept before they are merged into their bounding box
+const maxDamage = 64
+
+// Screen area
+type rect struct {
+	x, y, width, height int
+}
+
+func (r rect) empty() bool {
+	return r.width <= 0 || r.height <= 0
+}
+
+// Common part of two rectangles, empty if they do not overlap
+func (r rect) intersect(o rect) rect {
+	left, top := r.x, r.y
+	right, bottom := r.x+r.width, r.y+r.height
+	if o.x > left {
+		left = o.x
+	}
+	if o.y > top {
+		top = o.y
+	}
+	if o.x+o.width < right {
+		right = o.x + o.width
+	}
+	if o.y+o.height < bottom {
+		bottom = o.y + o.height
+	}
+	if right < left || bottom < top {
+		return rect{}
+	}
+	return rect{left, top, right - left, bottom - top}
+}
+
+// Smallest rectangle containing both rectangles
+func (r rect) union(o rect) rect {
+	if r.empty() {
+		return o
+	}
+	if o.empty() {
+		return r
+	}
+	left, top := r.x, r.y
+	right, bottom := r.x+r.width, r.y+r.height
+	if o.x < left {
+		left = o.x
+	}
+	if o.y < top {
+		top = o.y
+	}
+	if o.x+o.width > right {
+		right = o.x + o.width
+	}
+	if o.y+o.height > bottom {
+		bottom = o.y + o.height
+	}
+	return rect{left, top, right - left, bottom - top}
+}
+
+// Screen area covered by window
+func (w *window) rect() rect {
+	return rect{w.x, w.y, w.width, w.height}
+}
+
+// Marks screen area for repaint on next render
+func (c *Compositor) damageRect(r rect) {
+	r = r.intersect(rect{0, 0, c.width, c.height})
+	if r.empty() || c.front == nil {
+		// Nothing visible changed or whole screen is repainted anyway
+		return
+	}
+	for _, d := range c.damage {
+		if d.intersect(r) == r {
+			return
+		}
+	}
+	if len(c.damage) == maxDamage {
+		bounds := r
+		for _, d := range c.damage {
+			bounds = bounds.union(d)
+		}
+		c.damage = append(c.damage[:0], bounds)
+		return
+	}
+	c.damage = append(c.damage, r)
+}
+
+func (c *Compositor) damageWindow(w *window) {
+	c.damageRect(w.rect())
+}
+
+func (c *Compositor) damageAll() {
+	c.damage = append(c.damage[:0], rect{0, 0, c.width, c.height})
+}
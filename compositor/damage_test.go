@@ -0,0 +1,169 @@
+package compositor
+
+import (
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+// Backend recording cells set since last reset
+type recordingBackend struct {
+	cells   map[[2]int]fws.Cell
+	sets    int
+	flushes int
+}
+
+func newRecordingBackend() *recordingBackend {
+	return &recordingBackend{cells: make(map[[2]int]fws.Cell)}
+}
+
+func (b *recordingBackend) SetCell(x, y int, cell fws.Cell) {
+	b.cells[[2]int{x, y}] = cell
+	b.sets++
+}
+
+func (b *recordingBackend) Flush() error {
+	b.flushes++
+	return nil
+}
+
+func (b *recordingBackend) reset() {
+	b.cells = make(map[[2]int]fws.Cell)
+	b.sets = 0
+}
+
+func render(t *testing.T, c *Compositor, b *recordingBackend) {
+	t.Helper()
+	b.reset()
+	if err := c.Render(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Checks that exactly expected screen cells were flushed
+func expectFlushed(t *testing.T, b *recordingBackend, expected ...[2]int) {
+	t.Helper()
+	if b.sets != len(expected) {
+		t.Errorf("Flushed cells: expected %d, got %d: %v\n", len(expected), b.sets, b.cells)
+	}
+	for _, xy := range expected {
+		if _, ok := b.cells[xy]; !ok {
+			t.Errorf("Cell %v was not flushed\n", xy)
+		}
+	}
+}
+
+func TestRenderSingleCell(t *testing.T) {
+	c := New(80, 25)
+	b := newRecordingBackend()
+	add(t, c, 1, fws.NewWindowRequest{X: 10, Y: 5, Width: 20, Height: 10})
+	fill(c, 1, solid('a', red))
+	render(t, c, b)
+	if b.sets != 80*25 {
+		t.Errorf("First render: expected %d cells, got %d\n", 80*25, b.sets)
+	}
+
+	c.Apply(&fws.DrawRequest{Id: 1, X: 3, Y: 4, Cell: solid('b', blue)})
+	render(t, c, b)
+	expectFlushed(t, b, [2]int{13, 9})
+	if cell := b.cells[[2]int{13, 9}]; cell.Ch != 'b' {
+		t.Errorf("Flushed cell: expected %q, got %q\n", 'b', cell.Ch)
+	}
+
+	// Nothing changed
+	render(t, c, b)
+	expectFlushed(t, b)
+	c.Apply(&fws.DrawRequest{Id: 1, X: 3, Y: 4, Cell: solid('b', blue)})
+	render(t, c, b)
+	expectFlushed(t, b)
+	if b.flushes != 4 {
+		t.Errorf("Backend flushes: expected %d, got %d\n", 4, b.flushes)
+	}
+}
+
+func TestRenderDamage(t *testing.T) {
+	c := New(10, 4)
+	b := newRecordingBackend()
+	add(t, c, 1, fws.NewWindowRequest{X: 1, Y: 1, Width: 2, Height: 2})
+	add(t, c, 2, fws.NewWindowRequest{X: 6, Y: 0, Width: 2, Height: 1})
+	fill(c, 1, solid('a', red))
+	render(t, c, b)
+
+	// Old and new window areas
+	c.Apply(&fws.MoveRequest{Id: 1, X: 2, Y: 1})
+	render(t, c, b)
+	expectFlushed(t, b, [2]int{1, 1}, [2]int{1, 2}, [2]int{3, 1}, [2]int{3, 2})
+
+	c.Apply(&fws.ResizeRequest{Id: 1, Width: 1, Height: 2})
+	render(t, c, b)
+	expectFlushed(t, b, [2]int{3, 1}, [2]int{3, 2})
+
+	c.Apply(&fws.DrawFillRequest{Id: 2, Width: 2, Height: 1, Img: [][]fws.Cell{{solid('b', blue)}, {solid('b', blue)}}})
+	render(t, c, b)
+	expectFlushed(t, b, [2]int{6, 0}, [2]int{7, 0})
+
+	c.Apply(&fws.DeleteRequest{Id: 1})
+	render(t, c, b)
+	expectFlushed(t, b, [2]int{2, 1}, [2]int{2, 2})
+
+	// Offscreen changes flush nothing
+	c.Apply(&fws.MoveRequest{Id: 2, X: -5, Y: 0})
+	render(t, c, b)
+	c.Apply(&fws.DrawRequest{Id: 2, X: 0, Y: 0, Cell: solid('c', red)})
+	render(t, c, b)
+	expectFlushed(t, b)
+}
+
+func TestRenderMatchesCompose(t *testing.T) {
+	c := New(12, 6)
+	b := newRecordingBackend()
+	render(t, c, b)
+	screen := make(map[[2]int]fws.Cell)
+	for xy, cell := range b.cells {
+		screen[xy] = cell
+	}
+	for i := 0; i < 100; i++ {
+		id := fws.ID(i%3 + 1)
+		if i < 3 {
+			add(t, c, id, fws.NewWindowRequest{Width: 4, Height: 3, LayerAttr: fws.LayerAttribute(i)})
+		}
+		switch i % 4 {
+		case 0:
+			c.Apply(&fws.MoveRequest{Id: id, X: i%13 - 3, Y: i%7 - 2})
+		case 1:
+			c.Apply(&fws.DrawRequest{Id: id, X: i % 4, Y: i % 3, Cell: solid(rune('a'+i%26), red)})
+		case 2:
+			c.Apply(&fws.ResizeRequest{Id: id, Width: 2 + i%5, Height: 1 + i%4})
+		case 3:
+			c.Apply(&fws.FocusRequest{Id: id})
+		}
+		render(t, c, b)
+		for xy, cell := range b.cells {
+			screen[xy] = cell
+		}
+		composed := c.Compose()
+		for x := range composed {
+			for y := range composed[x] {
+				if screen[[2]int{x, y}] != composed[x][y] {
+					t.Fatalf("Step %d: backend cell %d,%d: expected %v, got %v\n", i, x, y, composed[x][y], screen[[2]int{x, y}])
+				}
+			}
+		}
+	}
+}
+
+func TestRenderAfterScreenResize(t *testing.T) {
+	c := New(4, 2)
+	b := newRecordingBackend()
+	render(t, c, b)
+	c.SetSize(5, 3)
+	render(t, c, b)
+	if b.sets != 5*3 {
+		t.Errorf("Render after resize: expected %d cells, got %d\n", 5*3, b.sets)
+	}
+	c.SetBackground(solid('.', black))
+	render(t, c, b)
+	if b.sets != 5*3 {
+		t.Errorf("Render after background change: expected %d cells, got %d\n", 5*3, b.sets)
+	}
+}
@@ -0,0 +1,58 @@
+package compositor
+
+import fws "github.com/Nekhaevalex/fwsprotocol"
+
+// Backend showing cell links as hyperlinks
+type Linker interface {
+	SetLink(link uint32, uri string) // Sets URI of global link
+}
+
+// Defines link of window link table, empty URI removes it.
+// Window cells are relinked, so each definition gets new global link
+func (c *Compositor) Link(id fws.ID, link uint32, uri string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, err := c.window(id)
+	if err != nil {
+		return err
+	}
+	if link == 0 {
+		return nil
+	}
+	if global, ok := w.links[link]; ok {
+		delete(c.links, global)
+		delete(w.links, link)
+	}
+	if uri != "" {
+		if w.links == nil {
+			w.links = make(map[uint32]uint32)
+		}
+		c.lastLink++
+		w.links[link] = c.lastLink
+		c.links[c.lastLink] = uri
+	}
+	c.damageWindow(w)
+	return nil
+}
+
+// URI of global link of composed cell, empty if link is not defined
+func (c *Compositor) URI(link uint32) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.links[link]
+}
+
+// Drops global links of removed window
+func (c *Compositor) unlink(w *window) {
+	for _, global := range w.links {
+		delete(c.links, global)
+	}
+}
+
+// Cell with window link replaced by global link, undefined links are dropped
+func (w *window) global(cell fws.Cell) fws.Cell {
+	if cell.Link != 0 {
+		cell.Link = w.links[cell.Link]
+	}
+	return cell
+}
@@ -0,0 +1,63 @@
+package compositor
+
+import (
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+// Backend recording cells and link URIs
+type linkingBackend struct {
+	*recordingBackend
+	links map[uint32]string
+}
+
+func (b *linkingBackend) SetLink(link uint32, uri string) {
+	b.links[link] = uri
+}
+
+func TestLinks(t *testing.T) {
+	c := New(4, 1)
+	add(t, c, 1, fws.NewWindowRequest{Width: 2, Height: 1})
+	add(t, c, 2, fws.NewWindowRequest{X: 2, Width: 2, Height: 1})
+	linked := fws.Cell{Ch: 'l', Fg: white, Bg: red, Link: 1}
+	fill(c, 1, linked)
+	fill(c, 2, linked)
+	c.Apply(&fws.LinkRequest{Id: 1, Link: 1, URI: "file:///one"})
+	c.Apply(&fws.LinkRequest{Id: 2, Link: 1, URI: "file:///two"})
+
+	screen := c.Compose()
+	if screen[0][0].Link == screen[2][0].Link {
+		t.Errorf("Windows share global link %d\n", screen[0][0].Link)
+	}
+	if uri := c.URI(screen[0][0].Link); uri != "file:///one" {
+		t.Errorf("First window link: expected %q, got %q\n", "file:///one", uri)
+	}
+	if uri := c.URI(screen[3][0].Link); uri != "file:///two" {
+		t.Errorf("Second window link: expected %q, got %q\n", "file:///two", uri)
+	}
+
+	// Linker gets every link before its cells
+	b := &linkingBackend{newRecordingBackend(), make(map[uint32]string)}
+	render(t, c, b.recordingBackend)
+	c.Link(1, 1, "file:///three")
+	b.reset()
+	if err := c.Render(b); err != nil {
+		t.Fatal(err)
+	}
+	expectFlushed(t, b.recordingBackend, [2]int{0, 0}, [2]int{1, 0})
+	if uri := b.links[b.cells[[2]int{0, 0}].Link]; uri != "file:///three" {
+		t.Errorf("Redefined link: expected %q, got %q\n", "file:///three", uri)
+	}
+
+	// Removed and undefined links are dropped
+	old := screen[3][0].Link
+	c.Remove(2)
+	if uri := c.URI(old); uri != "" {
+		t.Errorf("Link of removed window: got %q\n", uri)
+	}
+	c.Link(1, 1, "")
+	if link := c.Compose()[0][0].Link; link != 0 {
+		t.Errorf("Removed link: got %d\n", link)
+	}
+}
@@ -0,0 +1,108 @@
+package compositor
+
+import (
+	"sync"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+// Cell leaving screen under it unchanged, see Cell.Over
+func transparent(c fws.Cell) bool {
+	return (c.Ch == ' ' || c.Ch == 0) && !c.Continuation && c.Bg.A == 0
+}
+
+// Topmost window with visible cell at screen point and point in its
+// local coordinates. Transparent cells let pointer through to windows
+// below, ok is false where only background is seen
+func (c *Compositor) WindowAt(x, y int) (id fws.ID, lx, ly int, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if x < 0 || x >= c.width || y < 0 || y >= c.height {
+		return 0, 0, 0, false
+	}
+	ordered := c.ordered()
+	for i := len(ordered) - 1; i >= 0; i-- {
+		w := ordered[i]
+		lx, ly := x-w.x, y-w.y
+		if lx < 0 || lx >= w.width || ly < 0 || ly >= w.height {
+			continue
+		}
+		if !transparent(w.img[lx][ly]) {
+			return w.id, lx, ly, true
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// Global position of window top left corner
+func (c *Compositor) Position(id fws.ID) (int, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, err := c.window(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	return w.x, w.y, nil
+}
+
+// Mouse event delivery
+type Route struct {
+	Id    fws.ID
+	Event fws.Event // Event with MouseX, MouseY in window local coordinates
+	Focus bool      // Window was raised by click and should get keyboard input
+}
+
+// Routes screen mouse events to windows of compositor.
+// Events go to the window under pointer, see WindowAt. Button press grabs
+// pointer: motion and release go to the pressed window until release,
+// even outside of it
+type Router struct {
+	FocusOnClick bool // Button press raises window under pointer
+
+	c       *Compositor
+	mu      sync.Mutex
+	grab    fws.ID
+	grabbed bool
+}
+
+func NewRouter(c *Compositor) *Router {
+	return &Router{c: c}
+}
+
+func pressed(ev fws.Event) bool {
+	return ev.Mod&fws.ModMotion == 0 && (ev.Key == fws.MouseLeft || ev.Key == fws.MouseMiddle || ev.Key == fws.MouseRight)
+}
+
+// Window receiving mouse event, ok is false if there is none
+func (r *Router) Route(ev fws.Event) (route Route, ok bool) {
+	if ev.Type != fws.EventMouse {
+		return Route{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.grabbed && !pressed(ev) {
+		if ev.Key == fws.MouseRelease {
+			r.grabbed = false
+		}
+		if x, y, err := r.c.Position(r.grab); err == nil {
+			ev.MouseX -= x
+			ev.MouseY -= y
+			return Route{Id: r.grab, Event: ev}, true
+		}
+		// Grabbing window is gone
+		r.grabbed = false
+	}
+	id, x, y, ok := r.c.WindowAt(ev.MouseX, ev.MouseY)
+	if !ok {
+		return Route{}, false
+	}
+	ev.MouseX, ev.MouseY = x, y
+	route = Route{Id: id, Event: ev}
+	if pressed(ev) {
+		r.grab, r.grabbed = id, true
+		if r.FocusOnClick {
+			route.Focus = r.c.Raise(id) == nil
+		}
+	}
+	return route, true
+}
@@ -0,0 +1,119 @@
+package compositor
+
+import (
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+func TestWindowAt(t *testing.T) {
+	c := New(6, 4)
+	add(t, c, 1, fws.NewWindowRequest{X: 0, Y: 0, Width: 4, Height: 3})
+	add(t, c, 2, fws.NewWindowRequest{X: 2, Y: 1, Width: 4, Height: 3})
+	add(t, c, 3, fws.NewWindowRequest{X: -2, Y: -1, Width: 3, Height: 2, LayerAttr: fws.TOP})
+	add(t, c, 4, fws.NewWindowRequest{X: 0, Y: 3, Width: 2, Height: 1, LayerAttr: fws.BOTTOM})
+	fill(c, 1, solid('1', red))
+	fill(c, 2, solid('2', blue))
+	fill(c, 3, solid('3', black))
+	fill(c, 4, solid('4', black))
+	// Hole in window 2 lets pointer through to window 1
+	c.Draw(2, 0, 0, fws.Cell{Ch: ' ', Bg: fws.Color{}})
+	// Translucent cell still catches pointer
+	c.Draw(2, 1, 0, fws.Cell{Bg: fws.Color{A: 128}})
+	c.Raise(1)
+	c.Raise(4)
+
+	tests := []struct {
+		x, y   int
+		id     fws.ID
+		lx, ly int
+		ok     bool
+	}{
+		{0, 0, 3, 2, 1, true}, // TOP window partially offscreen
+		{1, 0, 1, 1, 0, true}, // Raised window 1 over window 2
+		{2, 1, 1, 2, 1, true},
+		{4, 1, 2, 2, 0, true}, // Window 1 ends at x 3
+		{5, 3, 2, 3, 2, true},
+		{0, 3, 4, 0, 0, true}, // Raised BOTTOM window stays under others
+		{2, 3, 2, 0, 2, true},
+		{4, 0, 0, 0, 0, false}, // Background
+		{6, 0, 0, 0, 0, false}, // Outside of screen
+		{-1, 0, 0, 0, 0, false},
+	}
+	for _, test := range tests {
+		id, lx, ly, ok := c.WindowAt(test.x, test.y)
+		if id != test.id || lx != test.lx || ly != test.ly || ok != test.ok {
+			t.Errorf("Point %d,%d: expected window %d at %d,%d %t, got %d at %d,%d %t\n",
+				test.x, test.y, test.id, test.lx, test.ly, test.ok, id, lx, ly, ok)
+		}
+	}
+
+	c.Raise(2)
+	if id, lx, ly, _ := c.WindowAt(2, 1); id != 1 || lx != 2 || ly != 1 {
+		t.Errorf("Transparent cell: expected window 1 at 2,1, got %d at %d,%d\n", id, lx, ly)
+	}
+	if id, _, _, _ := c.WindowAt(3, 1); id != 2 {
+		t.Errorf("Translucent cell: expected window 2, got %d\n", id)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	c := New(8, 4)
+	add(t, c, 1, fws.NewWindowRequest{X: 0, Y: 0, Width: 4, Height: 2})
+	add(t, c, 2, fws.NewWindowRequest{X: 2, Y: 1, Width: 4, Height: 2})
+	fill(c, 1, solid('1', red))
+	fill(c, 2, solid('2', blue))
+	r := NewRouter(c)
+	mouse := func(x, y int, key fws.Key, mod fws.Modifier) fws.Event {
+		return fws.Event{Type: fws.EventMouse, Key: key, Mod: mod, MouseX: x, MouseY: y}
+	}
+
+	tests := []struct {
+		name   string
+		ev     fws.Event
+		id     fws.ID
+		lx, ly int
+		focus  bool
+		ok     bool
+	}{
+		{"wheel", mouse(1, 1, fws.MouseWheelUp, 0), 1, 1, 1, false, true},
+		{"press", mouse(3, 1, fws.MouseLeft, 0), 2, 1, 0, false, true},
+		{"drag outside", mouse(7, 3, fws.MouseLeft, fws.ModMotion), 2, 5, 2, false, true},
+		{"release outside", mouse(0, 0, fws.MouseRelease, 0), 2, -2, -1, false, true},
+		{"after release", mouse(0, 0, fws.MouseRelease, 0), 1, 0, 0, false, true},
+		{"background", mouse(7, 0, fws.MouseLeft, 0), 0, 0, 0, false, false},
+		{"key", fws.Event{Type: fws.EventKey, Ch: 'a'}, 0, 0, 0, false, false},
+	}
+	for _, test := range tests {
+		route, ok := r.Route(test.ev)
+		if route.Id != test.id || route.Event.MouseX != test.lx || route.Event.MouseY != test.ly || route.Focus != test.focus || ok != test.ok {
+			t.Errorf("Route %s: expected window %d at %d,%d focus %t %t, got %d at %d,%d focus %t %t\n", test.name,
+				test.id, test.lx, test.ly, test.focus, test.ok, route.Id, route.Event.MouseX, route.Event.MouseY, route.Focus, ok)
+		}
+		if ok && route.Event.Key != test.ev.Key {
+			t.Errorf("Route %s: expected key %#x, got %#x\n", test.name, test.ev.Key, route.Event.Key)
+		}
+	}
+
+	// Click raises window under pointer
+	r.FocusOnClick = true
+	if route, _ := r.Route(mouse(1, 1, fws.MouseRight, 0)); route.Id != 1 || !route.Focus {
+		t.Errorf("Focus on click: got %+v\n", route)
+	}
+	if stack := c.Stack(); stack[len(stack)-1] != 1 {
+		t.Errorf("Clicked window was not raised, stack %v\n", stack)
+	}
+	if route, _ := r.Route(mouse(1, 1, fws.MouseWheelDown, 0)); route.Focus {
+		t.Errorf("Wheel is not a click: got %+v\n", route)
+	}
+
+	// Grab ends with removal of grabbing window
+	r.Route(mouse(3, 2, fws.MouseLeft, 0))
+	c.Remove(2)
+	if _, ok := r.Route(mouse(3, 2, fws.MouseRelease, 0)); ok {
+		t.Error("Release over background after grabbing window removal was routed")
+	}
+	if route, ok := r.Route(mouse(1, 1, fws.MouseLeft, fws.ModMotion)); !ok || route.Id != 1 {
+		t.Errorf("Motion after grab end: got %+v %t\n", route, ok)
+	}
+}
@@ -0,0 +1,82 @@
+package compositor
+
+import fws "github.com/Nekhaevalex/fwsprotocol"
+
+// Narrow empty cell replacing half of wide glyph that lost its other half
+func blank(c fws.Cell) fws.Cell {
+	return fws.Cell{Ch: ' ', Fg: c.Fg, Bg: c.Bg}
+}
+
+func isWide(c fws.Cell) bool {
+	return c.Width() == 2
+}
+
+// Sets window cell keeping wide glyphs whole: wide glyph reserves
+// continuation cell to the right, overwritten half of wide glyph clears
+// its other half. Wide glyph in the last column is replaced with blank
+func (w *window) put(x, y int, cell fws.Cell) {
+	old := w.img[x][y]
+	if cell.Continuation {
+		if x > 0 && isWide(w.img[x-1][y]) {
+			w.img[x][y] = cell
+		} else {
+			w.img[x][y] = blank(cell)
+		}
+		if isWide(old) && x+1 < w.width && w.img[x+1][y].Continuation {
+			w.img[x+1][y] = blank(w.img[x+1][y])
+		}
+		return
+	}
+	if old.Continuation && x > 0 && isWide(w.img[x-1][y]) {
+		w.img[x-1][y] = blank(w.img[x-1][y])
+	}
+	if !isWide(cell) {
+		if isWide(old) && x+1 < w.width && w.img[x+1][y].Continuation {
+			w.img[x+1][y] = blank(w.img[x+1][y])
+		}
+		w.img[x][y] = cell
+		return
+	}
+	if x+1 >= w.width {
+		w.img[x][y] = blank(cell)
+		return
+	}
+	right := w.img[x+1][y]
+	if isWide(right) && x+2 < w.width && w.img[x+2][y].Continuation {
+		w.img[x+2][y] = blank(w.img[x+2][y])
+	}
+	w.img[x][y] = cell
+	w.img[x+1][y] = fws.Cell{Fg: cell.Fg, Bg: cell.Bg, Attribute: cell.Attribute, Continuation: true}
+}
+
+// Clears wide glyph halves cut by window edges after resize
+func (w *window) fixEdges() {
+	if w.width == 0 {
+		return
+	}
+	for y := 0; y < w.height; y++ {
+		if last := w.img[w.width-1][y]; isWide(last) {
+			w.img[w.width-1][y] = blank(last)
+		}
+		if first := w.img[0][y]; first.Continuation {
+			w.img[0][y] = blank(first)
+		}
+	}
+}
+
+// Composed screen cell with wide glyph halves covered by other windows
+// or cut by screen edges replaced with blanks
+func fixed(screen [][]fws.Cell, x, y int) fws.Cell {
+	cell := screen[x][y]
+	switch {
+	case cell.Continuation:
+		if x == 0 || !isWide(screen[x-1][y]) {
+			return blank(cell)
+		}
+	case isWide(cell):
+		if x+1 >= len(screen) || !screen[x+1][y].Continuation {
+			return blank(cell)
+		}
+	}
+	return cell
+}
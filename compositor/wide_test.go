@@ -0,0 +1,75 @@
+package compositor
+
+import (
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+func TestDrawWideGlyph(t *testing.T) {
+	c := New(5, 1)
+	add(t, c, 1, fws.NewWindowRequest{Width: 5, Height: 1})
+	fill(c, 1, solid('a', red))
+	c.Draw(1, 1, 0, solid('世', red))
+	expectRows(t, c.Compose(), "a世\x00aa")
+
+	// Narrow glyph over continuation clears the wide glyph
+	c.Draw(1, 2, 0, solid('b', red))
+	expectRows(t, c.Compose(), "a baa")
+
+	// Wide glyph over left half of another one clears its continuation
+	c.Draw(1, 2, 0, solid('世', red))
+	c.Draw(1, 1, 0, solid('界', red))
+	expectRows(t, c.Compose(), "a界\x00 a")
+
+	// Wide glyph does not fit into the last column
+	c.Draw(1, 4, 0, solid('世', red))
+	expectRows(t, c.Compose(), "a界\x00  ")
+
+	// Stray continuation is drawn as blank
+	c.Draw(1, 0, 0, fws.Cell{Bg: red, Continuation: true})
+	expectRows(t, c.Compose(), " 界\x00  ")
+}
+
+func TestResizeCutsWideGlyph(t *testing.T) {
+	c := New(4, 1)
+	add(t, c, 1, fws.NewWindowRequest{Width: 4, Height: 1})
+	fill(c, 1, solid('a', red))
+	c.Draw(1, 2, 0, solid('世', red))
+	c.Resize(1, 3, 1)
+	expectRows(t, c.Compose(), "aa  ")
+}
+
+func TestComposeCoveredWideGlyph(t *testing.T) {
+	c := New(6, 1)
+	add(t, c, 1, fws.NewWindowRequest{Width: 6, Height: 1})
+	add(t, c, 2, fws.NewWindowRequest{X: 2, Width: 2, Height: 1})
+	fill(c, 1, solid(' ', red))
+	c.Draw(1, 1, 0, solid('世', red))
+	c.Draw(1, 3, 0, solid('界', red))
+	fill(c, 2, solid('b', blue))
+	expectRows(t, c.Compose(), "  bb  ")
+
+	// Glyphs are restored when cover moves away
+	b := newRecordingBackend()
+	render(t, c, b)
+	c.Move(2, 0, 1)
+	render(t, c, b)
+	if b.cells[[2]int{1, 0}].Ch != '世' || !b.cells[[2]int{4, 0}].Continuation {
+		t.Errorf("Uncovered glyphs were not flushed: %v\n", b.cells)
+	}
+	expectRows(t, c.Compose(), " 世\x00界\x00 ")
+}
+
+func TestComposeWideGlyphScreenEdge(t *testing.T) {
+	c := New(4, 1)
+	add(t, c, 1, fws.NewWindowRequest{X: -1, Width: 6, Height: 1})
+	fill(c, 1, solid(' ', red))
+	c.Draw(1, 0, 0, solid('世', red))
+	c.Draw(1, 3, 0, solid('界', red))
+	expectRows(t, c.Compose(), "  界\x00")
+	c.Move(1, 0, 0)
+	expectRows(t, c.Compose(), "世\x00  ")
+	c.Move(1, 1, 0)
+	expectRows(t, c.Compose(), " 世\x00 ")
+}
@@ -0,0 +1,313 @@
+package fwsprotocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Packed image encodings, combined as bit flags.
+// Packer applies them in order delta, palette, run-length
+type Encoding uint8
+
+const (
+	EncodingRLE     Encoding = 1 << iota // Runs of equal cells are sent once with repeat count
+	EncodingPalette                      // Colors are sent as indices into image color table
+	EncodingDelta                        // Cells are XORed with previous packed image of the same window
+
+	encodingMask = EncodingRLE | EncodingPalette | EncodingDelta
+)
+
+// Largest packed image, unpacked it is as large as DRAW_FILL in a frame of
+// default maximum size
+const MaxPackedCells = DefaultMaxFrameSize / cellSize
+
+// Encoded cell size with palette colors and without extension
+const paletteCellSize = 8
+
+// Palette size limit, color indices are single bytes
+const maxPaletteColors = 256
+
+var (
+	ErrBadEncoding = errors.New("fwsprotocol: malformed packed image")
+	ErrNoReference = errors.New("fwsprotocol: no reference image for delta encoding")
+)
+
+// Encodings allowed by negotiated capabilities
+func Encodings(caps Capability) Encoding {
+	var enc Encoding
+	if caps&CapCompression != 0 {
+		enc |= EncodingRLE
+	}
+	if caps&CapPalette != 0 {
+		enc |= EncodingPalette
+	}
+	if caps&CapDelta != 0 {
+		enc |= EncodingDelta
+	}
+	return enc
+}
+
+// Compressed whole window image, unpacks into DrawFillRequest
+// (25 bytes + data)
+type PackedFillRequest struct {
+	Id            ID
+	Width, Height int
+	Encoding      Encoding
+	Data          []uint8 // Packed cells, indexed as DRAW_FILL image
+}
+
+func (o *PackedFillRequest) Encode() Msg {
+	msg := []uint8{uint8(DRAW_PACKED)}
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(o.Id))
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(o.Width))
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(o.Height))
+	msg = append(msg, uint8(o.Encoding))
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(len(o.Data)))
+	return append(msg, o.Data...)
+}
+
+func (r *payloadReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = ErrTruncated
+		r.buf = nil
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func xorColor(a, b Color) Color {
+	return Color{a.A ^ b.A, a.R ^ b.R, a.G ^ b.G, a.B ^ b.B}
+}
+
+// Cell fields XORed with reference cell.
+// Grapheme cluster is not delta encoded, it is taken from the first cell
+func xorCell(a, b Cell) Cell {
+	return Cell{
+		Ch:             a.Ch ^ b.Ch,
+		Fg:             xorColor(a.Fg, b.Fg),
+		Bg:             xorColor(a.Bg, b.Bg),
+		Attribute:      a.Attribute ^ b.Attribute,
+		Grapheme:       a.Grapheme,
+		Continuation:   a.Continuation != b.Continuation,
+		UnderlineColor: xorColor(a.UnderlineColor, b.UnderlineColor),
+		Link:           a.Link ^ b.Link,
+	}
+}
+
+func copyImg(img [][]Cell) [][]Cell {
+	dup := make([][]Cell, len(img))
+	for i := range img {
+		dup[i] = append([]Cell(nil), img[i]...)
+	}
+	return dup
+}
+
+func sameSize(img [][]Cell, width, height int) bool {
+	return len(img) == width && (width == 0 || len(img[0]) == height)
+}
+
+// Image packer of one connection.
+// Keeps previous packed image of every window as delta reference, so
+// images of a window must be sent in the order they were packed.
+// Safe for concurrent use
+type Packer struct {
+	mu   sync.Mutex
+	prev map[ID][][]Cell
+}
+
+func NewPacker() *Packer {
+	return &Packer{prev: make(map[ID][][]Cell)}
+}
+
+// Packs image with encodings from allowed set that apply to it:
+// delta needs previous image of the same size, palette needs at most
+// 256 distinct colors. Image must not exceed MaxPackedCells
+func (p *Packer) Pack(fill *DrawFillRequest, allowed Encoding) *PackedFillRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	enc := allowed & encodingMask
+	prev, ok := p.prev[fill.Id]
+	if !ok || !sameSize(prev, fill.Width, fill.Height) {
+		enc &^= EncodingDelta
+	}
+	cells := make([]Cell, 0, fill.Width*fill.Height)
+	for i := 0; i < fill.Width; i++ {
+		for j := 0; j < fill.Height; j++ {
+			cell := fill.Img[i][j]
+			if enc&EncodingDelta != 0 {
+				cell = xorCell(cell, prev[i][j])
+			}
+			cells = append(cells, cell)
+		}
+	}
+	p.prev[fill.Id] = copyImg(fill.Img)
+
+	var palette map[Color]uint8
+	var data []uint8
+	if enc&EncodingPalette != 0 {
+		palette = make(map[Color]uint8)
+		var colors []Color
+		for _, cell := range cells {
+			for _, c := range [2]Color{cell.Fg, cell.Bg} {
+				if _, ok := palette[c]; !ok && len(colors) <= maxPaletteColors {
+					palette[c] = uint8(len(colors))
+					colors = append(colors, c)
+				}
+			}
+		}
+		if len(colors) > maxPaletteColors {
+			enc &^= EncodingPalette
+			palette = nil
+		} else {
+			data = binary.LittleEndian.AppendUint16(data, uint16(len(colors)))
+			for _, c := range colors {
+				data = append(data, c.Encode()...)
+			}
+		}
+	}
+	for n := 0; n < len(cells); {
+		run := 1
+		if enc&EncodingRLE != 0 {
+			for n+run < len(cells) && cells[n+run] == cells[n] {
+				run++
+			}
+			data = binary.AppendUvarint(data, uint64(run))
+		}
+		cell := cells[n]
+		if palette != nil {
+			data = binary.LittleEndian.AppendUint32(data, uint32(cell.Ch))
+			data = append(data, palette[cell.Fg], palette[cell.Bg])
+			data = cell.appendAttribute(data)
+		} else {
+			data = append(data, cell.Encode()...)
+		}
+		n += run
+	}
+	return &PackedFillRequest{Id: fill.Id, Width: fill.Width, Height: fill.Height, Encoding: enc, Data: data}
+}
+
+// Drops delta reference of deleted window
+func (p *Packer) Forget(id ID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.prev, id)
+}
+
+// Image unpacker of one connection, counterpart of Packer.
+// Safe for concurrent use
+type Unpacker struct {
+	mu   sync.Mutex
+	prev map[ID][][]Cell
+}
+
+func NewUnpacker() *Unpacker {
+	return &Unpacker{prev: make(map[ID][][]Cell)}
+}
+
+// Unpacks image, validating packed data before allocating image
+func (u *Unpacker) Unpack(req *PackedFillRequest) (*DrawFillRequest, error) {
+	if req.Encoding&^encodingMask != 0 {
+		return nil, fmt.Errorf("%w: unknown encoding %b", ErrBadEncoding, req.Encoding)
+	}
+	// Zero area image still allocates its columns, so sides are bounded too
+	if req.Width < 0 || req.Height < 0 || req.Width > MaxPackedCells || req.Height > MaxPackedCells ||
+		(req.Width != 0 && req.Height > MaxPackedCells/req.Width) {
+		return nil, fmt.Errorf("%w: packed %dx%d", ErrBadDimensions, req.Width, req.Height)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	prev, ok := u.prev[req.Id]
+	if req.Encoding&EncodingDelta != 0 && (!ok || !sameSize(prev, req.Width, req.Height)) {
+		return nil, fmt.Errorf("%w: window %d", ErrNoReference, req.Id)
+	}
+
+	r := payloadReader{buf: req.Data}
+	paletted := req.Encoding&EncodingPalette != 0
+	var palette []Color
+	if paletted {
+		n := int(r.uint16())
+		if n > maxPaletteColors {
+			return nil, fmt.Errorf("%w: %d palette colors", ErrBadEncoding, n)
+		}
+		for i := 0; i < n && r.err == nil; i++ {
+			palette = append(palette, r.color())
+		}
+	}
+	total := req.Width * req.Height
+	size := cellSize
+	if paletted {
+		size = paletteCellSize
+	}
+	// Without runs every cell has its own record
+	if req.Encoding&EncodingRLE == 0 {
+		if err := r.rect(total, 1, size); err != nil {
+			return nil, err
+		}
+	}
+	cells := make([]Cell, 0, total)
+	for len(cells) < total && r.err == nil {
+		run := uint64(1)
+		if req.Encoding&EncodingRLE != 0 {
+			run = r.uvarint()
+			if r.err == nil && (run == 0 || run > uint64(total-len(cells))) {
+				return nil, fmt.Errorf("%w: run of %d cells", ErrBadEncoding, run)
+			}
+		}
+		var cell Cell
+		if paletted {
+			cell.Ch = rune(r.uint32())
+			fg, bg := int(r.uint8()), int(r.uint8())
+			cell.Attribute = Attr(r.uint16())
+			r.extension(&cell)
+			if fg >= len(palette) || bg >= len(palette) {
+				if r.err == nil {
+					return nil, fmt.Errorf("%w: color index out of palette", ErrBadEncoding)
+				}
+			} else {
+				cell.Fg, cell.Bg = palette[fg], palette[bg]
+			}
+		} else {
+			cell = r.rawCell()
+		}
+		for i := uint64(0); i < run && r.err == nil; i++ {
+			cells = append(cells, cell)
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: packed image of window %d", r.err, req.Id)
+	}
+	if len(r.buf) != 0 {
+		return nil, fmt.Errorf("%w: %d bytes after packed image", ErrTrailingBytes, len(r.buf))
+	}
+
+	img := make([][]Cell, req.Width)
+	for i := range img {
+		img[i] = cells[i*req.Height : (i+1)*req.Height : (i+1)*req.Height]
+		if req.Encoding&EncodingDelta != 0 {
+			for j := range img[i] {
+				img[i][j] = xorCell(img[i][j], prev[i][j])
+			}
+		}
+		for _, cell := range img[i] {
+			if !cell.graphemeMatches() {
+				return nil, fmt.Errorf("%w: grapheme %q of rune %q: packed image of window %d", ErrBadCell, cell.Grapheme, cell.Ch, req.Id)
+			}
+		}
+	}
+	u.prev[req.Id] = copyImg(img)
+	return &DrawFillRequest{Id: req.Id, Width: req.Width, Height: req.Height, Img: img}, nil
+}
+
+// Drops delta reference of deleted window
+func (u *Unpacker) Forget(id ID) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.prev, id)
+}
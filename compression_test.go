@@ -0,0 +1,186 @@
+package fwsprotocol
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// Image with few colors and long runs of equal cells
+func testScreen(rnd *rand.Rand, width, height int) *DrawFillRequest {
+	fill := testFill(width, height)
+	colors := []Color{{255, 0, 0, 0}, {255, 255, 255, 255}, {255, 200, 0, 0}, {127, 0, 0, 200}}
+	for i := range fill.Img {
+		for j := range fill.Img[i] {
+			fill.Img[i][j] = Cell{Ch: ' ', Fg: colors[1], Bg: colors[0]}
+		}
+	}
+	for n := 0; n < width*height/10; n++ {
+		fill.Img[rnd.Intn(width)][rnd.Intn(height)] = Cell{
+			Ch:        rune('a' + rnd.Intn(26)),
+			Fg:        colors[rnd.Intn(len(colors))],
+			Bg:        colors[rnd.Intn(len(colors))],
+			Attribute: Attr(rnd.Intn(4)) << 9,
+		}
+	}
+	return fill
+}
+
+func expectSameImage(t *testing.T, expected, received *DrawFillRequest) {
+	t.Helper()
+	if received.Id != expected.Id || received.Width != expected.Width || received.Height != expected.Height {
+		t.Fatalf("Image header: expected %d %dx%d, got %d %dx%d\n", expected.Id, expected.Width, expected.Height, received.Id, received.Width, received.Height)
+	}
+	for i := 0; i < expected.Width; i++ {
+		for j := 0; j < expected.Height; j++ {
+			if received.Img[i][j] != expected.Img[i][j] {
+				t.Fatalf("[%d][%d] cell: expected %v, got %v\n", i, j, expected.Img[i][j], received.Img[i][j])
+			}
+		}
+	}
+}
+
+// Packs, sends and unpacks image, comparing result with uncompressed DRAW_FILL
+func packRoundTrip(t *testing.T, p *Packer, u *Unpacker, fill *DrawFillRequest, allowed Encoding) *PackedFillRequest {
+	t.Helper()
+	packed := p.Pack(fill, allowed)
+	decoded, err := DecodeMsg(packed.Encode())
+	if err != nil {
+		t.Fatalf("Encoding %b: unexpected decoding error: %v\n", allowed, err)
+	}
+	unpacked, err := u.Unpack(decoded.(*PackedFillRequest))
+	if err != nil {
+		t.Fatalf("Encoding %b: unexpected unpacking error: %v\n", allowed, err)
+	}
+	plain, err := DecodeMsg(fill.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSameImage(t, plain.(*DrawFillRequest), unpacked)
+	return packed
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for allowed := Encoding(0); allowed <= encodingMask; allowed++ {
+		p, u := NewPacker(), NewUnpacker()
+		for frame := 0; frame < 5; frame++ {
+			packed := packRoundTrip(t, p, u, testScreen(rnd, 30, 12), allowed)
+			expected := allowed
+			if frame == 0 {
+				expected &^= EncodingDelta
+			}
+			if packed.Encoding != expected {
+				t.Errorf("Encoding %b frame %d: expected %b applied, got %b\n", allowed, frame, expected, packed.Encoding)
+			}
+		}
+		packRoundTrip(t, p, u, testFill(0, 0), allowed)
+		packRoundTrip(t, p, u, testFill(7, 3), allowed)
+	}
+}
+
+func TestPackSize(t *testing.T) {
+	blank := testFill(200, 60)
+	for i := range blank.Img {
+		for j := range blank.Img[i] {
+			blank.Img[i][j] = Cell{Ch: ' ', Bg: Color{A: 255}}
+		}
+	}
+	plain := len(blank.Encode())
+	for _, allowed := range []Encoding{EncodingRLE, EncodingPalette, EncodingRLE | EncodingPalette} {
+		packed := len(NewPacker().Pack(blank, allowed).Encode())
+		if packed >= plain {
+			t.Errorf("Encoding %b: packed %d bytes, plain %d bytes\n", allowed, packed, plain)
+		}
+		if allowed&EncodingRLE != 0 && packed > 64 {
+			t.Errorf("Encoding %b: blank window packed into %d bytes\n", allowed, packed)
+		}
+	}
+
+	// Single changed cell costs two runs of unchanged cells and one changed cell
+	p := NewPacker()
+	p.Pack(blank, EncodingRLE|EncodingDelta)
+	blank.Img[100][30].Ch = 'x'
+	if packed := p.Pack(blank, EncodingRLE|EncodingDelta); len(packed.Data) > 3*(3+cellSize) {
+		t.Errorf("Delta frame packed into %d bytes\n", len(packed.Data))
+	}
+}
+
+func TestPackPaletteOverflow(t *testing.T) {
+	fill := testFill(30, 20)
+	for i := range fill.Img {
+		for j := range fill.Img[i] {
+			fill.Img[i][j].Fg = Color{255, uint8(i), uint8(j), 0}
+		}
+	}
+	p, u := NewPacker(), NewUnpacker()
+	if packed := packRoundTrip(t, p, u, fill, EncodingPalette|EncodingRLE); packed.Encoding != EncodingRLE {
+		t.Errorf("Palette with %d colors: expected encoding %b, got %b\n", 30*20, EncodingRLE, packed.Encoding)
+	}
+}
+
+func TestPackDeltaReference(t *testing.T) {
+	p, u := NewPacker(), NewUnpacker()
+	packRoundTrip(t, p, u, testFill(4, 4), EncodingDelta)
+	// Resized window is sent without delta
+	if packed := packRoundTrip(t, p, u, testFill(5, 4), EncodingDelta); packed.Encoding != 0 {
+		t.Errorf("Delta against image of other size: got encoding %b\n", packed.Encoding)
+	}
+	delta := p.Pack(testFill(5, 4), EncodingDelta)
+	u.Forget(7)
+	if _, err := u.Unpack(delta); !errors.Is(err, ErrNoReference) {
+		t.Errorf("Delta without reference: expected ErrNoReference, got %v\n", err)
+	}
+	p.Forget(7)
+	if packed := p.Pack(testFill(5, 4), EncodingDelta); packed.Encoding != 0 {
+		t.Errorf("Delta after forget: got encoding %b\n", packed.Encoding)
+	}
+}
+
+func TestUnpackErrors(t *testing.T) {
+	valid := NewPacker().Pack(testFill(3, 2), EncodingRLE|EncodingPalette)
+	truncated := *valid
+	truncated.Data = valid.Data[:len(valid.Data)-1]
+	trailing := *valid
+	trailing.Data = append(append([]uint8(nil), valid.Data...), 0)
+	tests := []struct {
+		name     string
+		req      *PackedFillRequest
+		expected error
+	}{
+		{"truncated", &truncated, ErrTruncated},
+		{"trailing", &trailing, ErrTrailingBytes},
+		{"unknown encoding", &PackedFillRequest{Id: 1, Encoding: 1 << 7}, ErrBadEncoding},
+		{"huge", &PackedFillRequest{Id: 1, Width: 1 << 20, Height: 1 << 20, Encoding: EncodingRLE, Data: []uint8{0xff, 0xff, 0xff, 0xff, 0x0f}}, ErrBadDimensions},
+		{"wide empty", &PackedFillRequest{Id: 1, Width: 1 << 40}, ErrBadDimensions},
+		{"tall empty", &PackedFillRequest{Id: 1, Height: 1 << 40}, ErrBadDimensions},
+		{"empty run", &PackedFillRequest{Id: 1, Width: 1, Height: 1, Encoding: EncodingRLE, Data: append([]uint8{0}, Cell{}.Encode()...)}, ErrBadEncoding},
+		{"long run", &PackedFillRequest{Id: 1, Width: 1, Height: 1, Encoding: EncodingRLE, Data: append([]uint8{2}, Cell{}.Encode()...)}, ErrBadEncoding},
+		{"plain short", &PackedFillRequest{Id: 1, Width: 2, Height: 1, Data: Cell{}.Encode()}, ErrTruncated},
+		{"palette index", &PackedFillRequest{Id: 1, Width: 1, Height: 1, Encoding: EncodingPalette, Data: []uint8{1, 0, 0, 0, 0, 0, 'a', 0, 0, 0, 0, 1, 0, 0}}, ErrBadEncoding},
+		{"palette size", &PackedFillRequest{Id: 1, Encoding: EncodingPalette, Data: []uint8{0xff, 0xff}}, ErrBadEncoding},
+		{"grapheme rune", &PackedFillRequest{Id: 1, Width: 1, Height: 1, Data: Cell{Ch: 'x', Grapheme: "e\u0301"}.Encode()}, ErrBadCell},
+	}
+	for _, test := range tests {
+		if _, err := NewUnpacker().Unpack(test.req); !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v\n", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestPackExtendedCells(t *testing.T) {
+	fill := testFill(4, 2)
+	fill.Img[0][0] = Cell{Ch: '世', Bg: Color{255, 0, 0, 0}}
+	fill.Img[1][0] = Cell{Bg: Color{255, 0, 0, 0}, Continuation: true}
+	fill.Img[3][0] = Cell{Ch: 'w', Attribute: CurlyUnderline, UnderlineColor: Color{255, 255, 0, 0}, Link: 4}
+	fill.Img[2][1] = Cell{Ch: 'e', Grapheme: "é"}
+	for allowed := Encoding(0); allowed <= encodingMask; allowed++ {
+		p, u := NewPacker(), NewUnpacker()
+		packRoundTrip(t, p, u, fill, allowed)
+		fill.Img[3][0].UnderlineColor.R ^= 0x80
+		fill.Img[3][0].Link ^= 1
+		fill.Img[3][1].SetGrapheme("👍🏽")
+		packRoundTrip(t, p, u, fill, allowed)
+		fill.Img[3][1] = Cell{}
+	}
+}
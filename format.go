@@ -0,0 +1,382 @@
+package fwsprotocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrBadText = errors.New("fwsprotocol: malformed text form")
+
+var headerNames = [...]string{
+	NEW:            "NEW",
+	GET:            "GET",
+	REPLY_CREATION: "REPLY_CREATION",
+	REPLY_GET:      "REPLY_GET",
+	EVENT:          "EVENT",
+	DRAW:           "DRAW",
+	DRAW_FILL:      "DRAW_FILL",
+	RENDER:         "RENDER",
+	RESIZE:         "RESIZE",
+	DELETE:         "DELETE",
+	MOVE:           "MOVE",
+	FOCUS:          "FOCUS",
+	UNFOCUS:        "UNFOCUS",
+	ACK:            "ACK",
+	REPEAT:         "REPEAT",
+	SCREEN:         "SCREEN",
+	REPLY_SCREEN:   "REPLY_SCREEN",
+	HELLO:          "HELLO",
+	REPLY_HELLO:    "REPLY_HELLO",
+	DRAW_RECT:      "DRAW_RECT",
+	DRAW_PACKED:    "DRAW_PACKED",
+	DRAW_TEXT:      "DRAW_TEXT",
+	LINK:           "LINK",
+}
+
+// Header constant name
+func (h Header) String() string {
+	if int(h) < len(headerNames) {
+		return headerNames[h]
+	}
+	return fmt.Sprintf("Header(%d)", uint8(h))
+}
+
+// Header by its constant name, case insensitive
+func ParseHeader(name string) (Header, error) {
+	for h, n := range headerNames {
+		if strings.EqualFold(n, name) {
+			return Header(h), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownHeader, name)
+}
+
+var layerNames = [...]string{ANY: "ANY", TOP: "TOP", BOTTOM: "BOTTOM"}
+
+func (l LayerAttribute) String() string {
+	if int(l) < len(layerNames) {
+		return layerNames[l]
+	}
+	return fmt.Sprintf("LayerAttribute(%d)", uint8(l))
+}
+
+// Named flags of bitmask type
+type flagName struct {
+	bit  uint32
+	name string
+}
+
+// Flag names joined by '|', unnamed bits as hex number
+func formatFlags(v uint32, names []flagName, zero string) string {
+	if v == 0 {
+		return zero
+	}
+	var parts []string
+	for _, n := range names {
+		if v&n.bit != 0 {
+			parts = append(parts, n.name)
+			v &^= n.bit
+		}
+	}
+	if v != 0 {
+		parts = append(parts, fmt.Sprintf("%#x", v))
+	}
+	return strings.Join(parts, "|")
+}
+
+func parseFlags(s string, names []flagName, zero string) (uint32, error) {
+	if s == zero || s == "" {
+		return 0, nil
+	}
+	var v uint32
+	for _, part := range strings.Split(s, "|") {
+		found := false
+		for _, n := range names {
+			if n.name == part {
+				v, found = v|n.bit, true
+			}
+		}
+		if found {
+			continue
+		}
+		bits, err := strconv.ParseUint(part, 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%w: flag %q", ErrBadText, part)
+		}
+		v |= uint32(bits)
+	}
+	return v, nil
+}
+
+var attrNames = []flagName{
+	{uint32(Bold), "bold"},
+	{uint32(Dim), "dim"},
+	{uint32(Cursive), "cursive"},
+	{uint32(Underline), "underline"},
+	{uint32(DoubleUnderline), "double-underline"},
+	{uint32(CurlyUnderline), "curly-underline"},
+	{uint32(Blink), "blink"},
+	{uint32(Reverse), "reverse"},
+	{uint32(Hidden), "hidden"},
+	{uint32(Strikethrough), "strikethrough"},
+}
+
+// Attribute names joined by '|', e.g. "bold|underline", "none" for 0
+func (a Attr) String() string {
+	return formatFlags(uint32(a), attrNames, "none")
+}
+
+func (a Attr) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Attr) UnmarshalText(text []byte) error {
+	v, err := parseFlags(string(text), attrNames, "none")
+	*a = Attr(v)
+	return err
+}
+
+var capNames = []flagName{
+	{uint32(CapTrueColor), "truecolor"},
+	{uint32(CapMouse), "mouse"},
+	{uint32(CapCompression), "compression"},
+	{uint32(CapClipboard), "clipboard"},
+	{uint32(CapPalette), "palette"},
+	{uint32(CapDelta), "delta"},
+	{uint32(CapHyperlinks), "hyperlinks"},
+}
+
+// Capability names joined by '|', "none" for 0
+func (c Capability) String() string {
+	return formatFlags(uint32(c), capNames, "none")
+}
+
+func (c Capability) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Capability) UnmarshalText(text []byte) error {
+	v, err := parseFlags(string(text), capNames, "none")
+	*c = Capability(v)
+	return err
+}
+
+var modeNames = [...]string{
+	OutputCurrent:   "current",
+	OutputNormal:    "normal",
+	Output256:       "256",
+	Output216:       "216",
+	OutputGrayscale: "grayscale",
+	OutputRGB:       "rgb",
+}
+
+func (m OutputMode) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return fmt.Sprintf("OutputMode(%d)", uint32(m))
+}
+
+var eventNames = [...]string{
+	EventKey:       "key",
+	EventResize:    "resize",
+	EventMouse:     "mouse",
+	EventError:     "error",
+	EventInterrupt: "interrupt",
+	EventRaw:       "raw",
+	EventNone:      "none",
+}
+
+func (t EventType) String() string {
+	if int(t) < len(eventNames) {
+		return eventNames[t]
+	}
+	return fmt.Sprintf("EventType(%d)", uint8(t))
+}
+
+func (ev Event) String() string {
+	switch ev.Type {
+	case EventKey:
+		return fmt.Sprintf("type=key mod=%d key=%#x ch=%q", ev.Mod, uint16(ev.Key), ev.Ch)
+	case EventResize:
+		return fmt.Sprintf("type=resize width=%d height=%d", ev.Width, ev.Height)
+	case EventMouse:
+		return fmt.Sprintf("type=mouse mod=%d key=%#x x=%d y=%d", ev.Mod, uint16(ev.Key), ev.MouseX, ev.MouseY)
+	case EventError:
+		return fmt.Sprintf("type=error err=%q", fmt.Sprint(ev.Err))
+	case EventRaw:
+		return fmt.Sprintf("type=raw n=%d", ev.N)
+	}
+	return "type=" + ev.Type.String()
+}
+
+// #rrggbb for opaque colors, #rrggbbaa otherwise, "none" for zero color
+func (a Color) String() string {
+	switch {
+	case a == Color{}:
+		return "none"
+	case a.A == 255:
+		return fmt.Sprintf("#%02x%02x%02x", a.R, a.G, a.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", a.R, a.G, a.B, a.A)
+}
+
+func (a Color) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Parses String form
+func (a *Color) UnmarshalText(text []byte) error {
+	s := string(text)
+	if s == "none" {
+		*a = Color{}
+		return nil
+	}
+	if len(s) != 7 && len(s) != 9 || s[0] != '#' {
+		return fmt.Errorf("%w: color %q", ErrBadText, s)
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return fmt.Errorf("%w: color %q", ErrBadText, s)
+	}
+	if len(s) == 7 {
+		v = v<<8 | 255
+	}
+	*a = Color{A: uint8(v), R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8)}
+	return nil
+}
+
+// Glyph, colors and set fields, e.g. 'x' fg=#ffffff bg=none attr=bold
+func (c Cell) String() string {
+	var glyph string
+	switch {
+	case c.Continuation:
+		glyph = "cont"
+	case c.Grapheme != "":
+		glyph = strconv.Quote(c.Grapheme)
+	default:
+		glyph = strconv.QuoteRune(c.Ch)
+	}
+	s := fmt.Sprintf("%s fg=%v bg=%v", glyph, c.Fg, c.Bg)
+	if c.UnderlineColor != (Color{}) {
+		s += " ul=" + c.UnderlineColor.String()
+	}
+	if c.Attribute != 0 {
+		s += " attr=" + c.Attribute.String()
+	}
+	if c.Link != 0 {
+		s += fmt.Sprintf(" link=%d", c.Link)
+	}
+	return s
+}
+
+// Decoded message, or header and bytes of malformed one
+func (msg Msg) String() string {
+	req, err := DecodeMsg(msg)
+	if err != nil {
+		if len(msg) == 0 {
+			return "empty message"
+		}
+		return fmt.Sprintf("%v % x (%v)", Header(msg[0]), []uint8(msg[1:]), err)
+	}
+	return fmt.Sprint(req)
+}
+
+func (o *NewWindowRequest) String() string {
+	return fmt.Sprintf("NEW pid=%d x=%d y=%d width=%d height=%d layer=%v", o.Pid, o.X, o.Y, o.Width, o.Height, o.LayerAttr)
+}
+
+func (o *GetRequest) String() string {
+	return fmt.Sprintf("GET id=%d x=%d y=%d", o.Id, o.X, o.Y)
+}
+
+func (o *ReplyCreationRequest) String() string {
+	return fmt.Sprintf("REPLY_CREATION id=%d", o.Id)
+}
+
+func (o *ReplyGetRequest) String() string {
+	return fmt.Sprintf("REPLY_GET id=%d x=%d y=%d cell=%v", o.Id, o.X, o.Y, o.C)
+}
+
+func (o *EventRequest) String() string {
+	return fmt.Sprintf("EVENT id=%d %v", o.Id, o.Event)
+}
+
+func (o *DrawRequest) String() string {
+	return fmt.Sprintf("DRAW id=%d x=%d y=%d cell=%v", o.Id, o.X, o.Y, o.Cell)
+}
+
+// Image is described by its size only
+func (o *DrawFillRequest) String() string {
+	return fmt.Sprintf("DRAW_FILL id=%d width=%d height=%d", o.Id, o.Width, o.Height)
+}
+
+func (o *DrawRectRequest) String() string {
+	return fmt.Sprintf("DRAW_RECT id=%d x=%d y=%d width=%d height=%d", o.Id, o.X, o.Y, o.Width, o.Height)
+}
+
+func (o *PackedFillRequest) String() string {
+	return fmt.Sprintf("DRAW_PACKED id=%d width=%d height=%d encoding=%#x size=%d", o.Id, o.Width, o.Height, uint8(o.Encoding), len(o.Data))
+}
+
+func (o *DrawTextRequest) String() string {
+	s := fmt.Sprintf("DRAW_TEXT id=%d x=%d y=%d text=%q fg=%v bg=%v", o.Id, o.X, o.Y, o.Text, o.Fg, o.Bg)
+	if o.Attribute != 0 {
+		s += " attr=" + o.Attribute.String()
+	}
+	return s
+}
+
+func (o *LinkRequest) String() string {
+	return fmt.Sprintf("LINK id=%d link=%d uri=%q", o.Id, o.Link, o.URI)
+}
+
+func (o *RenderRequest) String() string {
+	return fmt.Sprintf("RENDER id=%d", o.Id)
+}
+
+func (o *DeleteRequest) String() string {
+	return fmt.Sprintf("DELETE id=%d", o.Id)
+}
+
+func (o *ResizeRequest) String() string {
+	return fmt.Sprintf("RESIZE id=%d width=%d height=%d", o.Id, o.Width, o.Height)
+}
+
+func (o *MoveRequest) String() string {
+	return fmt.Sprintf("MOVE id=%d x=%d y=%d", o.Id, o.X, o.Y)
+}
+
+func (o *FocusRequest) String() string {
+	return fmt.Sprintf("FOCUS id=%d", o.Id)
+}
+
+func (o *UnfocusRequest) String() string {
+	return fmt.Sprintf("UNFOCUS id=%d", o.Id)
+}
+
+func (o *AckRequest) String() string {
+	return fmt.Sprintf("ACK id=%d seq=%d", o.Id, o.Seq)
+}
+
+func (o *RepeatRequest) String() string {
+	return fmt.Sprintf("REPEAT id=%d seq=%d", o.Id, o.Seq)
+}
+
+func (o *ScreenRequest) String() string {
+	return fmt.Sprintf("SCREEN id=%d", o.Id)
+}
+
+func (o *ReplyScreenRequest) String() string {
+	return fmt.Sprintf("REPLY_SCREEN width=%d height=%d mode=%v", o.Width, o.Height, o.Mode)
+}
+
+func (o *HelloRequest) String() string {
+	return fmt.Sprintf("HELLO version=%d.%d caps=%v", o.Major, o.Minor, o.Caps)
+}
+
+func (o *ReplyHelloRequest) String() string {
+	return fmt.Sprintf("REPLY_HELLO version=%d.%d caps=%v accepted=%t", o.Major, o.Minor, o.Caps, o.Accepted)
+}
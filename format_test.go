@@ -0,0 +1,173 @@
+package fwsprotocol
+
+import (
+	"errors"
+	"testing"
+)
+
+var white = Color{A: 255, R: 255, G: 255, B: 255}
+
+// One request of every message type
+func sampleRequests() []Request {
+	img := [][]Cell{{{Ch: 'a', Fg: white}}, {{Ch: 'é', Grapheme: "é", Bg: Color{A: 128, G: 1}}}}
+	return []Request{
+		&NewWindowRequest{Pid: 42, X: -1, Y: 2, Width: 10, Height: 5, LayerAttr: TOP},
+		&GetRequest{Id: 1, X: 2, Y: 3},
+		&ReplyCreationRequest{Id: 1},
+		&ReplyGetRequest{Id: 1, X: 2, Y: 3, C: Cell{Ch: 'x', Fg: white, Attribute: Bold}},
+		&EventRequest{Id: 4, Event: Event{Type: EventMouse, Key: MouseLeft, MouseX: 1, MouseY: 2}},
+		&DrawRequest{Id: 3, X: 1, Y: 2, Cell: Cell{Ch: 'x', Fg: white, UnderlineColor: white, Attribute: Underline | CurlyUnderline, Link: 2}},
+		&DrawFillRequest{Id: 3, Width: 2, Height: 1, Img: img},
+		&RenderRequest{Id: 5},
+		&ResizeRequest{Id: 5, Width: 3, Height: 4},
+		&DeleteRequest{Id: 5},
+		&MoveRequest{Id: 5, X: -2, Y: 7},
+		&FocusRequest{Id: 5},
+		&UnfocusRequest{Id: 5},
+		&AckRequest{Id: 5, Seq: 9},
+		&RepeatRequest{Id: 5, Seq: 10},
+		&ScreenRequest{Id: 5},
+		&ReplyScreenRequest{Width: 80, Height: 24, Mode: Output256},
+		&HelloRequest{Major: 1, Minor: 6, Caps: CapMouse | CapTrueColor},
+		&ReplyHelloRequest{Major: 1, Minor: 6, Caps: CapMouse, Accepted: true},
+		&DrawRectRequest{Id: 3, X: 1, Y: 1, Width: 2, Height: 1, Img: img},
+		&PackedFillRequest{Id: 3, Width: 2, Height: 1, Encoding: 1, Data: []uint8{1, 2, 3}},
+		&DrawTextRequest{Id: 3, X: 1, Text: "hi", Fg: white, Attribute: Strikethrough},
+		&LinkRequest{Id: 1, Link: 2, URI: "https://example.com"},
+	}
+}
+
+func TestHeaderString(t *testing.T) {
+	for _, req := range sampleRequests() {
+		h := Header(req.Encode()[0])
+		got, err := ParseHeader(h.String())
+		if err != nil || got != h {
+			t.Errorf("Header %d: %s parsed as %d, %v\n", h, h, got, err)
+		}
+	}
+	if got := Header(200).String(); got != "Header(200)" {
+		t.Errorf("Unknown header: got %s\n", got)
+	}
+	if h, err := ParseHeader("draw_text"); err != nil || h != DRAW_TEXT {
+		t.Errorf("Lower case header: got %v, %v\n", h, err)
+	}
+	if _, err := ParseHeader("PAINT"); !errors.Is(err, ErrUnknownHeader) {
+		t.Errorf("Unknown header name: expected ErrUnknownHeader, got %v\n", err)
+	}
+}
+
+func TestRequestString(t *testing.T) {
+	expected := []string{
+		"NEW pid=42 x=-1 y=2 width=10 height=5 layer=TOP",
+		"GET id=1 x=2 y=3",
+		"REPLY_CREATION id=1",
+		"REPLY_GET id=1 x=2 y=3 cell='x' fg=#ffffff bg=none attr=bold",
+		"EVENT id=4 type=mouse mod=0 key=0xffe8 x=1 y=2",
+		"DRAW id=3 x=1 y=2 cell='x' fg=#ffffff bg=none ul=#ffffff attr=underline|curly-underline link=2",
+		"DRAW_FILL id=3 width=2 height=1",
+		"RENDER id=5",
+		"RESIZE id=5 width=3 height=4",
+		"DELETE id=5",
+		"MOVE id=5 x=-2 y=7",
+		"FOCUS id=5",
+		"UNFOCUS id=5",
+		"ACK id=5 seq=9",
+		"REPEAT id=5 seq=10",
+		"SCREEN id=5",
+		"REPLY_SCREEN width=80 height=24 mode=256",
+		"HELLO version=1.6 caps=truecolor|mouse",
+		"REPLY_HELLO version=1.6 caps=mouse accepted=true",
+		"DRAW_RECT id=3 x=1 y=1 width=2 height=1",
+		"DRAW_PACKED id=3 width=2 height=1 encoding=0x1 size=3",
+		"DRAW_TEXT id=3 x=1 y=0 text=\"hi\" fg=#ffffff bg=none attr=strikethrough",
+		"LINK id=1 link=2 uri=\"https://example.com\"",
+	}
+	for i, req := range sampleRequests() {
+		if got := req.(interface{ String() string }).String(); got != expected[i] {
+			t.Errorf("Request %T: expected %q, got %q\n", req, expected[i], got)
+		}
+	}
+	if got := (&RenderRequest{Id: 5}).Encode().String(); got != "RENDER id=5" {
+		t.Errorf("Msg: expected %q, got %q\n", "RENDER id=5", got)
+	}
+	if got := (Msg{uint8(RENDER), 1}).String(); got[:9] != "RENDER 01" {
+		t.Errorf("Malformed msg: got %q\n", got)
+	}
+	if got := (Msg{}).String(); got != "empty message" {
+		t.Errorf("Empty msg: got %q\n", got)
+	}
+}
+
+func TestCellString(t *testing.T) {
+	tests := []struct {
+		cell     Cell
+		expected string
+	}{
+		{Cell{}, "'\\x00' fg=none bg=none"},
+		{Cell{Ch: '世', Fg: white, Attribute: Bold | Reverse}, "'世' fg=#ffffff bg=none attr=bold|reverse"},
+		{Cell{Grapheme: "é", Bg: Color{A: 128, G: 1}}, "\"é\" fg=none bg=#00010080"},
+		{Cell{Continuation: true, Link: 3}, "cont fg=none bg=none link=3"},
+	}
+	for _, test := range tests {
+		if got := test.cell.String(); got != test.expected {
+			t.Errorf("Cell %#v: expected %q, got %q\n", test.cell, test.expected, got)
+		}
+	}
+}
+
+func TestColorText(t *testing.T) {
+	tests := []struct {
+		color    Color
+		expected string
+	}{
+		{Color{}, "none"},
+		{Color{A: 255}, "#000000"},
+		{white, "#ffffff"},
+		{Color{A: 128, R: 1, G: 2, B: 3}, "#01020380"},
+		{Color{R: 255}, "#ff000000"},
+	}
+	for _, test := range tests {
+		if got := test.color.String(); got != test.expected {
+			t.Errorf("Color %v: expected %s, got %s\n", [4]uint8{test.color.A, test.color.R, test.color.G, test.color.B}, test.expected, got)
+		}
+		var c Color
+		if err := c.UnmarshalText([]byte(test.expected)); err != nil || c != test.color {
+			t.Errorf("Color %s: parsed as %v, %v\n", test.expected, c, err)
+		}
+	}
+	for _, bad := range []string{"", "#fff", "ffffff", "#gggggg", "#ffffffff00"} {
+		var c Color
+		if err := c.UnmarshalText([]byte(bad)); !errors.Is(err, ErrBadText) {
+			t.Errorf("Color %q: expected ErrBadText, got %v\n", bad, err)
+		}
+	}
+}
+
+func TestAttrText(t *testing.T) {
+	tests := []struct {
+		attr     Attr
+		expected string
+	}{
+		{0, "none"},
+		{Bold, "bold"},
+		{Bold | Underline | Strikethrough, "bold|underline|strikethrough"},
+		{Attr(0x0100), "0x100"},
+	}
+	for _, test := range tests {
+		if got := test.attr.String(); got != test.expected {
+			t.Errorf("Attr %#x: expected %s, got %s\n", uint16(test.attr), test.expected, got)
+		}
+		var a Attr
+		if err := a.UnmarshalText([]byte(test.expected)); err != nil || a != test.attr {
+			t.Errorf("Attr %s: parsed as %#x, %v\n", test.expected, uint16(a), err)
+		}
+	}
+	var a Attr
+	if err := a.UnmarshalText([]byte("bold|shiny")); !errors.Is(err, ErrBadText) {
+		t.Errorf("Unknown attr: expected ErrBadText, got %v\n", err)
+	}
+	var c Capability
+	if err := c.UnmarshalText([]byte("mouse|hyperlinks")); err != nil || c != CapMouse|CapHyperlinks {
+		t.Errorf("Capabilities: got %v, %v\n", c, err)
+	}
+}
@@ -0,0 +1,175 @@
+package fwsprotocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Default limit for a single framed message.
+// Large enough for a 500x500 DRAW_FILL
+const DefaultMaxFrameSize = 4 << 20
+
+// Frame header size: message length, sequence number and reply reference
+const frameHeaderSize = 12
+
+var ErrFrameTooLarge = errors.New("fwsprotocol: frame exceeds maximum size")
+
+// Framed message.
+// Every sender numbers its messages starting with 1, replies refer to
+// the sequence number of request they answer, so apps can match replies
+// with outstanding requests and tell them from asynchronous events
+// (12 bytes + message)
+type Frame struct {
+	Seq uint32 // Sender assigned sequence number
+	Ref uint32 // Sequence number of answered request, 0 if message is not a reply
+	Msg Msg
+}
+
+func appendFrame(b []uint8, f Frame) []uint8 {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(f.Msg)))
+	b = binary.LittleEndian.AppendUint32(b, f.Seq)
+	b = binary.LittleEndian.AppendUint32(b, f.Ref)
+	return append(b, f.Msg...)
+}
+
+// Parses frame from single datagram, message is copied
+func parseFrame(b []uint8) (Frame, error) {
+	if len(b) < frameHeaderSize {
+		return Frame{}, ErrTruncated
+	}
+	size := binary.LittleEndian.Uint32(b[0:4])
+	if uint64(size) < uint64(len(b)-frameHeaderSize) {
+		return Frame{}, ErrTrailingBytes
+	}
+	if uint64(size) > uint64(len(b)-frameHeaderSize) {
+		return Frame{}, ErrTruncated
+	}
+	return Frame{
+		Seq: binary.LittleEndian.Uint32(b[4:8]),
+		Ref: binary.LittleEndian.Uint32(b[8:12]),
+		Msg: append(Msg(nil), b[frameHeaderSize:]...),
+	}, nil
+}
+
+// Length-prefixed message stream writer.
+// Every message is written as 4 byte little endian length, sequence
+// number and reply reference followed by the message itself (header and payload)
+type Encoder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	seq uint32 // Last assigned sequence number
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Writes frame as is, without assigning sequence number.
+// Frame is written with one Write call, so encoder is safe for concurrent use
+func (e *Encoder) WriteFrame(f Frame) error {
+	frame := appendFrame(make([]uint8, 0, frameHeaderSize+len(f.Msg)), f)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(frame)
+	return err
+}
+
+// Writes message with next sequence number, ref is 0 for non-reply messages.
+// Returns assigned sequence number
+func (e *Encoder) SendMsg(msg Msg, ref uint32) (uint32, error) {
+	return e.sendMsg(msg, ref, nil)
+}
+
+// Send calling reserve with sequence number of request before it is
+// written, so reply arriving while write blocks can be matched.
+// Reserve must not send through the encoder
+func (e *Encoder) SendReserved(req Request, ref uint32, reserve func(seq uint32)) (uint32, error) {
+	return e.sendMsg(req.Encode(), ref, reserve)
+}
+
+func (e *Encoder) sendMsg(msg Msg, ref uint32, reserve func(seq uint32)) (uint32, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.seq++
+	if e.seq == 0 {
+		e.seq = 1
+	}
+	if reserve != nil {
+		reserve(e.seq)
+	}
+	frame := appendFrame(make([]uint8, 0, frameHeaderSize+len(msg)), Frame{e.seq, ref, msg})
+	_, err := e.w.Write(frame)
+	return e.seq, err
+}
+
+// Encodes request and sends it with next sequence number
+func (e *Encoder) Send(req Request, ref uint32) (uint32, error) {
+	return e.SendMsg(req.Encode(), ref)
+}
+
+// Writes single non-reply message
+func (e *Encoder) WriteMsg(msg Msg) error {
+	_, err := e.SendMsg(msg, 0)
+	return err
+}
+
+// Encodes request and writes it as single non-reply message
+func (e *Encoder) Encode(req Request) error {
+	return e.WriteMsg(req.Encode())
+}
+
+// Length-prefixed message stream reader.
+// Reassembles complete messages regardless of how they were split
+// or coalesced by the underlying stream
+type Decoder struct {
+	r            io.Reader
+	MaxFrameSize int // Frames longer than this are rejected with ErrFrameTooLarge
+	header       [frameHeaderSize]uint8
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, MaxFrameSize: DefaultMaxFrameSize}
+}
+
+// Reads next complete frame.
+// Returns io.EOF if stream ended between frames and io.ErrUnexpectedEOF
+// if it ended in the middle of one
+func (d *Decoder) ReadFrame() (Frame, error) {
+	if _, err := io.ReadFull(d.r, d.header[:]); err != nil {
+		return Frame{}, err
+	}
+	size := binary.LittleEndian.Uint32(d.header[0:4])
+	if uint64(size) > uint64(d.MaxFrameSize) {
+		return Frame{}, fmt.Errorf("%w: %d > %d bytes", ErrFrameTooLarge, size, d.MaxFrameSize)
+	}
+	f := Frame{
+		Seq: binary.LittleEndian.Uint32(d.header[4:8]),
+		Ref: binary.LittleEndian.Uint32(d.header[8:12]),
+		Msg: make(Msg, size),
+	}
+	if _, err := io.ReadFull(d.r, f.Msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+	return f, nil
+}
+
+// Reads next complete message
+func (d *Decoder) ReadMsg() (Msg, error) {
+	f, err := d.ReadFrame()
+	return f.Msg, err
+}
+
+// Reads and decodes next message
+func (d *Decoder) Decode() (Request, error) {
+	msg, err := d.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	return DecodeMsg(msg)
+}
@@ -0,0 +1,207 @@
+package fwsprotocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+)
+
+func testFill(width, height int) *DrawFillRequest {
+	img := make([][]Cell, width)
+	for i := range img {
+		img[i] = make([]Cell, height)
+		for j := range img[i] {
+			img[i][j] = Cell{Ch: rune('a' + (i+j)%26), Fg: Color{255, 1, 2, 3}, Bg: Color{255, 4, 5, 6}}
+		}
+	}
+	return &DrawFillRequest{Id: 7, Width: width, Height: height, Img: img}
+}
+
+func TestFramingCoalescedMessages(t *testing.T) {
+	var stream bytes.Buffer
+	encoder := NewEncoder(&stream)
+	first := &DrawRequest{Id: 1, X: 2, Y: 3, Cell: Cell{Ch: 'x'}}
+	second := &DrawRequest{Id: 1, X: 4, Y: 5, Cell: Cell{Ch: 'y'}}
+	if err := encoder.Encode(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode(second); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoder(&stream)
+	for _, expected := range []*DrawRequest{first, second} {
+		decoded, err := decoder.Decode()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v\n", err)
+		}
+		draw, ok := decoded.(*DrawRequest)
+		if !ok {
+			t.Fatalf("Wrong decoded type: %v\n", decoded)
+		}
+		if *draw != *expected {
+			t.Errorf("Decoding failed: expected %v, got %v\n", expected, draw)
+		}
+	}
+	if _, err := decoder.ReadMsg(); err != io.EOF {
+		t.Errorf("Expected io.EOF at end of stream, got %v\n", err)
+	}
+}
+
+func TestFramingSplitMessage(t *testing.T) {
+	var stream bytes.Buffer
+	fill := testFill(40, 30)
+	if err := NewEncoder(&stream).Encode(fill); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := NewDecoder(iotest.OneByteReader(&stream)).Decode()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	received, ok := decoded.(*DrawFillRequest)
+	if !ok {
+		t.Fatalf("Wrong decoded type: %v\n", decoded)
+	}
+	if received.Width != fill.Width || received.Height != fill.Height {
+		t.Fatalf("Size decoding failed: expected %dx%d, got %dx%d\n", fill.Width, fill.Height, received.Width, received.Height)
+	}
+	if received.Img[39][29] != fill.Img[39][29] {
+		t.Errorf("Cell decoding failed: expected %v, got %v\n", fill.Img[39][29], received.Img[39][29])
+	}
+}
+
+func TestFramingTooLarge(t *testing.T) {
+	stream := binary.LittleEndian.AppendUint32(nil, 1<<31)
+	stream = append(stream, make([]uint8, 8)...)
+	decoder := NewDecoder(bytes.NewReader(stream))
+	if _, err := decoder.ReadMsg(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Expected ErrFrameTooLarge, got %v\n", err)
+	}
+
+	var small bytes.Buffer
+	if err := NewEncoder(&small).Encode(testFill(4, 4)); err != nil {
+		t.Fatal(err)
+	}
+	decoder = NewDecoder(&small)
+	decoder.MaxFrameSize = 64
+	if _, err := decoder.ReadMsg(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Expected ErrFrameTooLarge with custom limit, got %v\n", err)
+	}
+}
+
+func TestFramingTruncatedStream(t *testing.T) {
+	var stream bytes.Buffer
+	if err := NewEncoder(&stream).Encode(&RenderRequest{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	truncated := stream.Bytes()[:stream.Len()-1]
+	if _, err := NewDecoder(bytes.NewReader(truncated)).ReadMsg(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v\n", err)
+	}
+}
+
+func TestFramingUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fws.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	const count = 50
+	go func() {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		encoder := NewEncoder(conn)
+		for i := 0; i < count; i++ {
+			encoder.Encode(&DrawRequest{Id: 1, X: i, Y: i, Cell: Cell{Ch: 'z'}})
+			encoder.Encode(testFill(60, 20))
+		}
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	decoder := NewDecoder(conn)
+	for i := 0; i < count; i++ {
+		decoded, err := decoder.Decode()
+		if err != nil {
+			t.Fatalf("Message %d: unexpected error: %v\n", 2*i, err)
+		}
+		if draw, ok := decoded.(*DrawRequest); !ok || draw.X != i {
+			t.Fatalf("Message %d: expected draw at %d, got %v\n", 2*i, i, decoded)
+		}
+		decoded, err = decoder.Decode()
+		if err != nil {
+			t.Fatalf("Message %d: unexpected error: %v\n", 2*i+1, err)
+		}
+		if fill, ok := decoded.(*DrawFillRequest); !ok || fill.Width != 60 {
+			t.Fatalf("Message %d: expected fill, got %T\n", 2*i+1, decoded)
+		}
+	}
+}
+
+func TestFramingSequenceNumbers(t *testing.T) {
+	var stream bytes.Buffer
+	encoder := NewEncoder(&stream)
+	first, err := encoder.Send(&GetRequest{Id: 1, X: 2, Y: 3}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := encoder.Send(&ScreenRequest{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 1 || second != 2 {
+		t.Errorf("Sequence numbers: expected 1 and 2, got %d and %d\n", first, second)
+	}
+	if err := encoder.Encode(&ReplyGetRequest{Id: 1, X: 2, Y: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoder.Send(&ReplyScreenRequest{Width: 80}, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.WriteFrame(Frame{Seq: 100, Ref: 7, Msg: (&RenderRequest{Id: 1}).Encode()}); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoder(&stream)
+	expected := []Frame{{Seq: 1}, {Seq: 2}, {Seq: 3}, {Seq: 4, Ref: 1}, {Seq: 100, Ref: 7}}
+	for i, frame := range expected {
+		f, err := decoder.ReadFrame()
+		if err != nil {
+			t.Fatalf("Frame %d: unexpected error: %v\n", i, err)
+		}
+		if f.Seq != frame.Seq || f.Ref != frame.Ref {
+			t.Errorf("Frame %d: expected seq %d ref %d, got seq %d ref %d\n", i, frame.Seq, frame.Ref, f.Seq, f.Ref)
+		}
+		if _, err := DecodeMsg(f.Msg); err != nil {
+			t.Errorf("Frame %d: unexpected decoding error: %v\n", i, err)
+		}
+	}
+}
+
+func TestFramingSequenceWrap(t *testing.T) {
+	encoder := NewEncoder(io.Discard)
+	encoder.seq = math.MaxUint32 - 1
+	for _, expected := range []uint32{math.MaxUint32, 1, 2} {
+		seq, err := encoder.Send(&RenderRequest{}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq != expected {
+			t.Errorf("Sequence number: expected %d, got %d\n", expected, seq)
+		}
+	}
+}
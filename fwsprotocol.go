@@ -3,9 +3,13 @@ package fwsprotocol
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
+	"unicode/utf8"
 
-	"github.com/nsf/termbox-go"
+	"github.com/mattn/go-runewidth"
+	"github.com/rivo/uniseg"
 )
 
 // FWS Protocol socket constatnt
@@ -14,91 +18,258 @@ const FWS_SOCKET = "/tmp/fws_server.sock"
 // Message type – alias for []uint8
 type Msg []uint8
 
-// Decodes message into Request interface implementations
+// Decodes message into Request interface implementations.
+// Returns nil if message is malformed, use DecodeMsg to get the reason.
 func (msg *Msg) Decode() Request {
-	header := Header(Msg(*msg)[0])
-	payload := []uint8(*msg)[1:]
+	req, err := DecodeMsg(*msg)
+	if err != nil {
+		return nil
+	}
+	return req
+}
+
+// Reports whether s is valid UTF-8 without C0 and C1 control characters.
+// Terminals treat control characters as commands, so text shown by server
+// must not contain them
+func Printable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, ch := range s {
+		if ch < ' ' || ch >= 0x7f && ch < 0xa0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Decoding errors
+var (
+	ErrTruncated     = errors.New("fwsprotocol: message truncated")
+	ErrUnknownHeader = errors.New("fwsprotocol: unknown message header")
+	ErrTrailingBytes = errors.New("fwsprotocol: trailing bytes after message")
+	ErrBadDimensions = errors.New("fwsprotocol: bad rectangle dimensions")
+	ErrBadURI        = errors.New("fwsprotocol: control characters in link URI")
+)
+
+// Bounds-checked message decoder
+// Decodes message into Request interface implementations, validating
+// payload size of every message type before allocating anything
+func DecodeMsg(msg Msg) (Request, error) {
+	if len(msg) == 0 {
+		return nil, ErrTruncated
+	}
+	header := Header(msg[0])
+	r := payloadReader{buf: msg[1:]}
+	var req Request
 	switch header {
 	case NEW:
-		return &NewWindowRequest{
-			int(binary.LittleEndian.Uint32(payload[0:4])),
-			int(binary.LittleEndian.Uint32(payload[4:8])),
-			int(binary.LittleEndian.Uint32(payload[8:12])),
-			int(binary.LittleEndian.Uint32(payload[12:16])),
-			int(binary.LittleEndian.Uint32(payload[16:20])),
-			LayerAttribute(payload[20])}
+		req = &NewWindowRequest{
+			int(r.uint32()),
+			int(int32(r.uint32())), // Position may be offscreen
+			int(int32(r.uint32())),
+			int(r.uint32()),
+			int(r.uint32()),
+			LayerAttribute(r.uint8())}
 	case GET:
-		return &GetRequest{
-			ID(binary.LittleEndian.Uint32(payload[0:4])),
-			int(binary.LittleEndian.Uint64(payload[4:12])),
-			int(binary.LittleEndian.Uint64(payload[12:20]))}
+		req = &GetRequest{ID(r.uint32()), int(r.uint64()), int(r.uint64())}
 	case REPLY_CREATION:
-		return &ReplyCreationRequest{ID(binary.LittleEndian.Uint32(payload[0:4]))}
+		req = &ReplyCreationRequest{ID(r.uint32())}
 	case REPLY_GET:
-		return &ReplyGetRequest{decodeCell(payload)}
+		req = &ReplyGetRequest{ID(r.uint32()), int(r.uint64()), int(r.uint64()), r.cell()}
 	case EVENT:
-		return &EventRequest{ID(binary.LittleEndian.Uint32(payload[0:4])),
-			decodeEvent(payload[4:])}
+		req = &EventRequest{ID(r.uint32()), r.event()}
 	case DRAW:
-		return &DrawRequest{ID(binary.LittleEndian.Uint32(payload[0:4])), int(binary.LittleEndian.Uint64(payload[4:12])), int(binary.LittleEndian.Uint64(payload[12:20])), decodeCell(payload[20:34])}
+		req = &DrawRequest{ID(r.uint32()), int(r.uint64()), int(r.uint64()), r.cell()}
 	case DRAW_FILL:
-		id := ID(binary.LittleEndian.Uint32(payload[0:4]))
-		width := int(binary.LittleEndian.Uint64(payload[4:12]))
-		height := int(binary.LittleEndian.Uint64(payload[12:20]))
-		img := make([][]Cell, width)
-		bitmapPayload := payload[20:]
-		index := 0
-		for i := 0; i < width; i++ {
-			img[i] = make([]Cell, height)
-			for j := 0; j < height; j++ {
-				img[i][j] = decodeCell(bitmapPayload[index : index+14])
-				index += 14
-			}
+		id := ID(r.uint32())
+		width := int(r.uint64())
+		height := int(r.uint64())
+		img, err := r.img(width, height)
+		if err != nil {
+			return nil, err
+		}
+		req = &DrawFillRequest{id, width, height, img}
+	case DRAW_RECT:
+		id := ID(r.uint32())
+		x := int(r.uint64())
+		y := int(r.uint64())
+		width := int(r.uint64())
+		height := int(r.uint64())
+		img, err := r.img(width, height)
+		if err != nil {
+			return nil, err
+		}
+		req = &DrawRectRequest{id, x, y, width, height, img}
+	case DRAW_PACKED:
+		id := ID(r.uint32())
+		width := int(r.uint64())
+		height := int(r.uint64())
+		enc := Encoding(r.uint8())
+		data := r.next(int(r.uint32()))
+		if r.err == nil && (width < 0 || height < 0) {
+			return nil, fmt.Errorf("%w: packed %dx%d", ErrBadDimensions, width, height)
+		}
+		req = &PackedFillRequest{id, width, height, enc, append([]uint8(nil), data...)}
+	case DRAW_TEXT:
+		text := &DrawTextRequest{
+			Id:        ID(r.uint32()),
+			X:         int(r.uint64()),
+			Y:         int(r.uint64()),
+			Fg:        r.color(),
+			Bg:        r.color(),
+			Attribute: Attr(r.uint16()),
 		}
-		return &DrawFillRequest{id, width, height, img}
+		text.Text = string(r.next(int(r.uint32())))
+		req = text
+	case LINK:
+		link := &LinkRequest{Id: ID(r.uint32()), Link: r.uint32()}
+		link.URI = string(r.next(int(r.uint32())))
+		if r.err == nil && !Printable(link.URI) {
+			return nil, fmt.Errorf("%w: %q", ErrBadURI, link.URI)
+		}
+		req = link
 	case RENDER:
-		id := ID(binary.LittleEndian.Uint32(payload[0:4]))
-		return &RenderRequest{Id: id}
+		req = &RenderRequest{Id: ID(r.uint32())}
 	case DELETE:
-		id := ID(binary.LittleEndian.Uint32(payload[0:4]))
-		return &DeleteRequest{Id: id}
+		req = &DeleteRequest{Id: ID(r.uint32())}
 	case RESIZE:
-		id := ID(binary.LittleEndian.Uint32(payload[0:4]))
-		width := int(binary.LittleEndian.Uint64(payload[4:12]))
-		height := int(binary.LittleEndian.Uint64(payload[12:20]))
-		return &ResizeRequest{Id: id, Width: width, Height: height}
+		id := ID(r.uint32())
+		width := int(r.uint64())
+		height := int(r.uint64())
+		if r.err == nil && (width < 0 || height < 0) {
+			return nil, fmt.Errorf("%w: resize to %dx%d", ErrBadDimensions, width, height)
+		}
+		req = &ResizeRequest{Id: id, Width: width, Height: height}
 	case MOVE:
-		id := ID(binary.LittleEndian.Uint32(payload[0:4]))
-		x := int(binary.LittleEndian.Uint64(payload[4:12]))
-		y := int(binary.LittleEndian.Uint64(payload[12:20]))
-		return &MoveRequest{Id: id, X: x, Y: y}
+		req = &MoveRequest{Id: ID(r.uint32()), X: int(r.uint64()), Y: int(r.uint64())}
 	case FOCUS:
-		id := ID(binary.LittleEndian.Uint32(payload[0:4]))
-		return &FocusRequest{Id: id}
+		req = &FocusRequest{Id: ID(r.uint32())}
 	case UNFOCUS:
-		id := ID(binary.LittleEndian.Uint32(payload[0:4]))
-		return &UnfocusRequest{Id: id}
+		req = &UnfocusRequest{Id: ID(r.uint32())}
 	case ACK:
-		id := ID(binary.LittleEndian.Uint32(payload[0:4]))
-		return &AckRequest{Id: id}
+		req = &AckRequest{Id: ID(r.uint32()), Seq: r.uint32()}
 	case REPEAT:
-		id := ID(binary.LittleEndian.Uint32(payload[0:4]))
-		return &RepeatRequest{Id: id}
+		req = &RepeatRequest{Id: ID(r.uint32()), Seq: r.uint32()}
 	case SCREEN:
-		id := ID(binary.LittleEndian.Uint32(payload[0:4]))
-		return &ScreenRequest{Id: id}
+		req = &ScreenRequest{Id: ID(r.uint32())}
 	case REPLY_SCREEN:
-		width := int32(binary.LittleEndian.Uint32(payload[0:4]))
-		height := int32(binary.LittleEndian.Uint32(payload[4:8]))
-		mode := termbox.OutputMode(binary.LittleEndian.Uint32(payload[8:12]))
-		return &ReplyScreenRequest{
-			Width:  width,
-			Height: height,
-			Mode:   mode,
+		req = &ReplyScreenRequest{
+			Width:  int32(r.uint32()),
+			Height: int32(r.uint32()),
+			Mode:   OutputMode(r.uint32()),
+		}
+	case HELLO:
+		req = &HelloRequest{Major: r.uint16(), Minor: r.uint16(), Caps: Capability(r.uint32())}
+	case REPLY_HELLO:
+		req = &ReplyHelloRequest{
+			Major:    r.uint16(),
+			Minor:    r.uint16(),
+			Caps:     Capability(r.uint32()),
+			Accepted: r.uint8() != 0,
 		}
 	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnknownHeader, header)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: header %d", r.err, header)
+	}
+	if len(r.buf) != 0 {
+		return nil, fmt.Errorf("%w: %d bytes after header %d", ErrTrailingBytes, len(r.buf), header)
+	}
+	return req, nil
+}
+
+// Bounds-checked little-endian payload reader.
+// After the first failed read all following reads return zero values
+type payloadReader struct {
+	buf []uint8
+	err error
+}
+
+func (r *payloadReader) next(n int) []uint8 {
+	if r.err != nil {
 		return nil
 	}
+	if len(r.buf) < n {
+		r.err = ErrTruncated
+		r.buf = nil
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *payloadReader) uint8() uint8 {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *payloadReader) uint16() uint16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *payloadReader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *payloadReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// Validates that payload holds at least width x height items of specified
+// minimum size before anything is allocated for them.
+// Sides are bounded too, as image with zero area still allocates columns
+func (r *payloadReader) rect(width, height, size int) error {
+	if width < 0 || height < 0 {
+		return fmt.Errorf("%w: %dx%d", ErrBadDimensions, width, height)
+	}
+	if width > MaxPackedCells || height > MaxPackedCells {
+		return fmt.Errorf("%w: %dx%d side exceeds %d cells", ErrBadDimensions, width, height, MaxPackedCells)
+	}
+	if width != 0 && height > math.MaxInt/size/width {
+		return fmt.Errorf("%w: %dx%d overflows", ErrBadDimensions, width, height)
+	}
+	need := width * height * size
+	if len(r.buf) < need {
+		return fmt.Errorf("%w: %dx%d rectangle needs %d bytes, got %d", ErrTruncated, width, height, need, len(r.buf))
+	}
+	return nil
+}
+
+// Reads width x height cell image after validating payload size.
+// Earlier read errors are left for DecodeMsg to report
+func (r *payloadReader) img(width, height int) ([][]Cell, error) {
+	if r.err != nil {
+		return nil, nil
+	}
+	if err := r.rect(width, height, cellSize); err != nil {
+		return nil, err
+	}
+	img := make([][]Cell, width)
+	for i := 0; i < width; i++ {
+		img[i] = make([]Cell, height)
+		for j := 0; j < height; j++ {
+			img[i][j] = r.cell()
+		}
+	}
+	return img, nil
 }
 
 // Message class descriptor
@@ -122,6 +293,12 @@ const (
 	REPEAT                       // Request message repeat
 	SCREEN                       // Message containing request for screen size and color space information
 	REPLY_SCREEN                 // Message with screen size and color space information
+	HELLO                        // Message opening connection with protocol version and capabilities
+	REPLY_HELLO                  // Message with negotiated protocol version and capabilities
+	DRAW_RECT                    // Message containing rectangle image at offset inside window
+	DRAW_PACKED                  // Message containing compressed whole window image
+	DRAW_TEXT                    // Message containing single line of text with shared style
+	LINK                         // Message defining hyperlink of window link table
 )
 
 type LayerAttribute uint8
@@ -149,26 +326,29 @@ const (
 	Reverse
 )
 
+// Extended attributes, revision 5. Underline styles are drawn instead of
+// Underline where terminal supports them
+const (
+	Strikethrough Attr = 1 << iota
+	DoubleUnderline
+	CurlyUnderline
+)
+
 // aRGB cell color
 // (4 bytes)
 type Color struct {
 	A, R, G, B uint8
 }
 
-func ColorFromTermboxAttr(attr termbox.Attribute) Color {
-	r, g, b := termbox.AttributeToRGB(attr)
-	return Color{A: 255, R: r, G: g, B: b}
-}
-
-func (c *Color) ToTerboxAttr() termbox.Attribute {
-	return termbox.RGBToAttribute(c.R, c.G, c.B)
-}
-
 // aRGB overlaying operator
 func (a *Color) Over(b Color) Color {
 	alphaA := float32(a.A) / 255
 	alphaB := float32(b.A) / 255
 	alpha0 := alphaA + alphaB*(1-alphaA)
+	if alpha0 == 0 {
+		// Both colors are fully transparent
+		return Color{}
+	}
 
 	Ra := float32(a.R) / 255
 	Ga := float32(a.G) / 255
@@ -184,15 +364,12 @@ func (a *Color) Over(b Color) Color {
 	return Color{uint8(alpha0 * 255), nr, ng, nb}
 }
 
-func (a Color) To216Mode() int {
-	blue := int(math.Round((float64(a.B) / 255) * 6))
-	green := int(math.Round((float64(a.G)/255)*6)) * 6
-	red := int(math.Round((float64(a.R)/255)*6)) * 36
-	return red + green + blue + 1
-}
-
-func decodeColor(encoded []uint8) Color {
-	return Color{encoded[0], encoded[1], encoded[2], encoded[3]}
+func (r *payloadReader) color() Color {
+	b := r.next(4)
+	if b == nil {
+		return Color{}
+	}
+	return Color{b[0], b[1], b[2], b[3]}
 }
 
 // Color type binary encoder
@@ -200,51 +377,237 @@ func (c *Color) Encode() []uint8 {
 	return []uint8{c.A, c.R, c.G, c.B}
 }
 
-// Text cell decriptor
-// (14 bytes)
+// Text cell decriptor.
+// Cells with grapheme cluster or continuation flag are encoded with
+// extension, which needs protocol revision 4, underline color needs 5,
+// link needs 6
+// (14 bytes + extension)
 type Cell struct {
-	Ch        rune
-	Fg        Color
-	Bg        Color
-	Attribute Attr
+	Ch             rune // Glyph, first rune of grapheme cluster
+	Fg             Color
+	Bg             Color
+	Attribute      Attr
+	Grapheme       string // Whole grapheme cluster if it has several runes, see MaxGraphemeSize
+	Continuation   bool   // Right half of wide glyph in cell to the left
+	UnderlineColor Color  // Underline color, transparent to draw underline with Fg
+	Link           uint32 // Hyperlink index in window link table, 0 if none
+}
+
+// Cell for protocol revision minor, fields newer than it are dropped:
+// continuation cells become empty and graphemes their first rune before 4,
+// underline styles become Underline and underline color is cleared
+// before 5, links are cleared before 6
+func (c Cell) Downgrade(minor uint16) Cell {
+	if minor < 4 {
+		if c.Continuation {
+			c.Ch = 0
+		}
+		c.Grapheme, c.Continuation = "", false
+	}
+	if minor < 5 {
+		if c.Attribute&(DoubleUnderline|CurlyUnderline) != 0 {
+			c.Attribute |= Underline
+		}
+		c.Attribute &^= Strikethrough | DoubleUnderline | CurlyUnderline
+		c.UnderlineColor = Color{}
+	}
+	if minor < 6 {
+		c.Link = 0
+	}
+	return c
 }
 
-func FromTermboxCell(cell termbox.Cell) Cell {
-	return Cell{
-		Ch:        cell.Ch,
-		Fg:        ColorFromTermboxAttr(cell.Fg),
-		Bg:        ColorFromTermboxAttr(cell.Bg),
-		Attribute: 0,
+// Number of screen columns taken by cell glyph before clamping,
+// 0 for control and lone combining runes
+func (c Cell) glyphWidth() int {
+	if c.Grapheme != "" {
+		return uniseg.StringWidth(c.Grapheme)
 	}
+	return runewidth.RuneWidth(c.Ch)
 }
 
-func (cell *Cell) ToTerboxCell() termbox.Cell {
-	return termbox.Cell{
-		Ch: cell.Ch,
-		Fg: cell.Fg.ToTerboxAttr() | termbox.Attribute(cell.Attribute),
-		Bg: cell.Bg.ToTerboxAttr()}
+// Number of screen columns taken by cell: 2 for wide glyphs,
+// 0 for continuation cells and 1 otherwise
+func (c Cell) Width() int {
+	if c.Continuation {
+		return 0
+	}
+	if c.glyphWidth() >= 2 {
+		return 2
+	}
+	return 1
+}
+
+// Sets cell glyph to grapheme cluster
+func (c *Cell) SetGrapheme(cluster string) {
+	c.Ch, c.Grapheme = 0, ""
+	for i, ch := range cluster {
+		if i == 0 {
+			c.Ch = ch
+		} else {
+			c.Grapheme = cluster
+			break
+		}
+	}
+}
+
+// Glyph as string, grapheme cluster or single rune
+func (c Cell) Glyph() string {
+	if c.Grapheme != "" {
+		return c.Grapheme
+	}
+	return string(c.Ch)
 }
 
 func (over *Cell) Over(underlying Cell) Cell {
-	new_ch := over.Ch
-	new_fg := over.Fg
-	if (over.Ch == rune(" "[0]) || over.Ch == 0) && over.Bg.A < 255 {
-		new_ch = underlying.Ch
-		new_fg = over.Bg.Over(underlying.Fg)
-	}
-	newCell := Cell{Ch: new_ch,
-		Fg:        new_fg,
-		Bg:        over.Bg.Over(underlying.Bg),
-		Attribute: over.Attribute}
+	newCell := *over
+	if (over.Ch == rune(" "[0]) || over.Ch == 0) && !over.Continuation && over.Bg.A < 255 {
+		// Underlying glyph shows through, keeping its attributes.
+		// Halves of wide glyph covered by different cells are fixed by compositor
+		newCell = underlying
+		newCell.Fg = over.Bg.Over(underlying.Fg)
+		if underlying.UnderlineColor.A != 0 {
+			newCell.UnderlineColor = over.Bg.Over(underlying.UnderlineColor)
+		}
+	}
+	newCell.Bg = over.Bg.Over(underlying.Bg)
 	return newCell
 }
 
-func decodeCell(encoded []uint8) Cell {
-	ch := rune(binary.LittleEndian.Uint32(encoded[0:4]))
-	fg := decodeColor(encoded[4:8])
-	bg := decodeColor(encoded[8:12])
-	attr := Attr(binary.LittleEndian.Uint16(encoded[12:14]))
-	return Cell{ch, fg, bg, attr}
+// Encoded cell size without extension
+const cellSize = 14
+
+// Attribute bit marking encoded cell followed by extension:
+// flags byte and fields selected by flags
+const attrExtended Attr = 1 << 8
+
+// Cell extension flags
+const (
+	extContinuation   uint8 = 1 << iota // Cell is continuation, no field
+	extGrapheme                         // Grapheme cluster, length byte and UTF-8 bytes
+	extUnderlineColor                   // Underline color, 4 bytes
+	extLink                             // Hyperlink index, 4 bytes
+
+	extKnown = extContinuation | extGrapheme | extUnderlineColor | extLink
+)
+
+var ErrBadCell = errors.New("fwsprotocol: malformed cell extension")
+
+func (r *payloadReader) cell() Cell {
+	cell := r.rawCell()
+	if r.err == nil && !cell.graphemeMatches() {
+		r.err = fmt.Errorf("%w: grapheme %q of rune %q", ErrBadCell, cell.Grapheme, cell.Ch)
+		r.buf = nil
+	}
+	return cell
+}
+
+// Cell without check of grapheme rune, which is XORed in delta images
+func (r *payloadReader) rawCell() Cell {
+	ch := rune(r.uint32())
+	fg := r.color()
+	bg := r.color()
+	attr := Attr(r.uint16())
+	cell := Cell{Ch: ch, Fg: fg, Bg: bg, Attribute: attr}
+	r.extension(&cell)
+	return cell
+}
+
+// Grapheme must be single printable cluster
+func validGrapheme(grapheme string) bool {
+	if !Printable(grapheme) {
+		return false
+	}
+	_, rest, _, _ := uniseg.FirstGraphemeClusterInString(grapheme, -1)
+	return rest == ""
+}
+
+// Reports whether grapheme of cell, if any, starts with cell rune
+func (c Cell) graphemeMatches() bool {
+	first, _ := utf8.DecodeRuneInString(c.Grapheme)
+	return c.Grapheme == "" || first == c.Ch
+}
+
+// Reads cell extension if attribute marks it
+func (r *payloadReader) extension(c *Cell) {
+	if r.err != nil || c.Attribute&attrExtended == 0 {
+		return
+	}
+	c.Attribute &^= attrExtended
+	flags := r.uint8()
+	if flags&^extKnown != 0 {
+		// Unknown fields can't be skipped
+		r.err = fmt.Errorf("%w: flags %b", ErrBadCell, flags)
+		r.buf = nil
+		return
+	}
+	c.Continuation = flags&extContinuation != 0
+	if flags&extGrapheme != 0 {
+		c.Grapheme = string(r.next(int(r.uint8())))
+		if r.err == nil && !validGrapheme(c.Grapheme) {
+			r.err = fmt.Errorf("%w: grapheme %q", ErrBadCell, c.Grapheme)
+			r.buf = nil
+			return
+		}
+	}
+	if flags&extUnderlineColor != 0 {
+		c.UnderlineColor = r.color()
+	}
+	if flags&extLink != 0 {
+		c.Link = r.uint32()
+	}
+}
+
+// Longest encoded grapheme cluster. Longer clusters are cut to whole runes
+// fitting the limit when encoded, so cell keeps its rune and leading marks
+const MaxGraphemeSize = math.MaxUint8
+
+// Grapheme as encoded, cut to MaxGraphemeSize bytes on rune boundary
+func (c Cell) encodedGrapheme() string {
+	grapheme := c.Grapheme
+	if len(grapheme) > MaxGraphemeSize {
+		n := MaxGraphemeSize
+		for n > 0 && !utf8.RuneStart(grapheme[n]) {
+			n--
+		}
+		grapheme = grapheme[:n]
+	}
+	return grapheme
+}
+
+// Appends encoded cell attribute and extension
+func (c Cell) appendAttribute(b []uint8) []uint8 {
+	var flags uint8
+	if c.Continuation {
+		flags |= extContinuation
+	}
+	grapheme := c.encodedGrapheme()
+	if grapheme != "" {
+		flags |= extGrapheme
+	}
+	if c.UnderlineColor != (Color{}) {
+		flags |= extUnderlineColor
+	}
+	if c.Link != 0 {
+		flags |= extLink
+	}
+	attr := c.Attribute &^ attrExtended
+	if flags == 0 {
+		return binary.LittleEndian.AppendUint16(b, uint16(attr))
+	}
+	b = binary.LittleEndian.AppendUint16(b, uint16(attr|attrExtended))
+	b = append(b, flags)
+	if flags&extGrapheme != 0 {
+		b = append(b, uint8(len(grapheme)))
+		b = append(b, grapheme...)
+	}
+	if flags&extUnderlineColor != 0 {
+		b = append(b, c.UnderlineColor.Encode()...)
+	}
+	if flags&extLink != 0 {
+		b = binary.LittleEndian.AppendUint32(b, c.Link)
+	}
+	return b
 }
 
 // Cell type binary encoder
@@ -253,8 +616,7 @@ func (c Cell) Encode() []uint8 {
 	code = binary.LittleEndian.AppendUint32(code, uint32(c.Ch))
 	code = append(code, c.Fg.Encode()...)
 	code = append(code, c.Bg.Encode()...)
-	code = binary.LittleEndian.AppendUint16(code, uint16(c.Attribute))
-	return code
+	return c.appendAttribute(code)
 }
 
 // General interface for all FWS messages
@@ -314,39 +676,44 @@ func (o *ReplyCreationRequest) Encode() Msg {
 }
 
 // Cell data reply
-// (14 bytes)
+// (34 bytes)
 type ReplyGetRequest struct {
-	C Cell // Request-specified cell descriptor
+	Id   ID   // Window ID from request
+	X, Y int  // Local X, Y coordinates from request
+	C    Cell // Request-specified cell descriptor
 }
 
 func (o *ReplyGetRequest) Encode() Msg {
 	msg := []uint8{uint8(REPLY_GET)}
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(o.Id))
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(o.X))
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(o.Y))
 	msg = append(msg, o.C.Encode()...)
 	return Msg(msg)
 }
 
 // Event data message descriptor
-// uses termbox Event with overwritten XY local coordinates
+// uses Event with overwritten XY local coordinates
 // (48 bytes)
 type EventRequest struct {
-	Id            ID // Layer ID to identify window
-	termbox.Event    // Dispatched termbox event
-}
-
-func decodeEvent(encoded []uint8) termbox.Event {
-	typ := encoded[0]
-	mod := encoded[1]
-	key := binary.LittleEndian.Uint16(encoded[2:4])
-	ch := binary.LittleEndian.Uint32(encoded[4:8])
-	width := binary.LittleEndian.Uint64(encoded[8:16])
-	height := binary.LittleEndian.Uint64(encoded[16:24])
-	mousex := binary.LittleEndian.Uint64(encoded[24:32])
-	mousey := binary.LittleEndian.Uint64(encoded[32:40])
-	n := binary.LittleEndian.Uint64(encoded[40:48])
-	return termbox.Event{
-		Type:   termbox.EventType(typ),
-		Mod:    termbox.Modifier(mod),
-		Key:    termbox.Key(key),
+	Id    ID // Layer ID to identify window
+	Event    // Dispatched event
+}
+
+func (r *payloadReader) event() Event {
+	typ := r.uint8()
+	mod := r.uint8()
+	key := r.uint16()
+	ch := r.uint32()
+	width := r.uint64()
+	height := r.uint64()
+	mousex := r.uint64()
+	mousey := r.uint64()
+	n := r.uint64()
+	return Event{
+		Type:   EventType(typ),
+		Mod:    Modifier(mod),
+		Key:    Key(key),
 		Ch:     rune(ch),
 		Width:  int(width),
 		Height: int(height),
@@ -410,6 +777,101 @@ func (o *DrawFillRequest) Encode() Msg {
 	return msg
 }
 
+// Rectangle image request at offset inside window.
+// Parts of rectangle outside window are clipped
+type DrawRectRequest struct {
+	Id            ID
+	X, Y          int // Local X, Y coordinates of rectangle top left corner
+	Width, Height int
+	Img           [][]Cell
+}
+
+// Rectangle image request binary encoder
+func (o *DrawRectRequest) Encode() Msg {
+	msg := []uint8{uint8(DRAW_RECT)}
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(o.Id))
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(o.X))
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(o.Y))
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(o.Width))
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(o.Height))
+	for i := 0; i < o.Width; i++ {
+		for j := 0; j < o.Height; j++ {
+			msg = append(msg, o.Img[i][j].Encode()...)
+		}
+	}
+	return msg
+}
+
+// Single line text request, every rune gets the same colors and attributes
+// (34 bytes + text)
+type DrawTextRequest struct {
+	Id        ID
+	X, Y      int    // Local X, Y coordinates of first rune
+	Text      string // UTF-8 text
+	Fg, Bg    Color
+	Attribute Attr
+}
+
+func (o *DrawTextRequest) Encode() Msg {
+	msg := []uint8{uint8(DRAW_TEXT)}
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(o.Id))
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(o.X))
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(o.Y))
+	msg = append(msg, o.Fg.Encode()...)
+	msg = append(msg, o.Bg.Encode()...)
+	msg = binary.LittleEndian.AppendUint16(msg, uint16(o.Attribute))
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(len(o.Text)))
+	return append(msg, o.Text...)
+}
+
+// Expands text into row of cells, one per grapheme cluster.
+// Wide glyphs are followed by continuation cells.
+// Zero width clusters, such as control characters, are skipped
+func (o *DrawTextRequest) Cells() []Cell {
+	cells := make([]Cell, 0, len(o.Text))
+	g := uniseg.NewGraphemes(o.Text)
+	for g.Next() {
+		cell := Cell{Fg: o.Fg, Bg: o.Bg, Attribute: o.Attribute}
+		cell.SetGrapheme(g.Str())
+		if cell.glyphWidth() == 0 {
+			continue
+		}
+		cells = append(cells, cell)
+		if cell.Width() == 2 {
+			cells = append(cells, Cell{Fg: o.Fg, Bg: o.Bg, Attribute: o.Attribute, Continuation: true})
+		}
+	}
+	return cells
+}
+
+// Text as one row rectangle image
+func (o *DrawTextRequest) Rect() *DrawRectRequest {
+	cells := o.Cells()
+	img := make([][]Cell, len(cells))
+	for i := range cells {
+		img[i] = cells[i : i+1 : i+1]
+	}
+	return &DrawRectRequest{Id: o.Id, X: o.X, Y: o.Y, Width: len(cells), Height: 1, Img: img}
+}
+
+// Hyperlink definition for cells of window.
+// Cells refer to link by its index, which is chosen by app. Redefining
+// index changes link of cells already drawn, empty URI removes link
+// (12 bytes + URI)
+type LinkRequest struct {
+	Id   ID
+	Link uint32 // Link index, not 0
+	URI  string
+}
+
+func (o *LinkRequest) Encode() Msg {
+	msg := []uint8{uint8(LINK)}
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(o.Id))
+	msg = binary.LittleEndian.AppendUint32(msg, o.Link)
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(len(o.URI)))
+	return append(msg, o.URI...)
+}
+
 type RenderRequest struct {
 	Id ID
 }
@@ -476,23 +938,32 @@ func (o *UnfocusRequest) Encode() Msg {
 	return msg
 }
 
+// Acknowledgement of every frame up to and including Seq
+// (8 bytes)
 type AckRequest struct {
-	Id ID
+	Id  ID     // Window ID, 0 for whole connection
+	Seq uint32 // Last sequence number received in order
 }
 
 func (o *AckRequest) Encode() Msg {
 	msg := []uint8{uint8(ACK)}
 	msg = binary.LittleEndian.AppendUint32(msg, uint32(o.Id))
+	msg = binary.LittleEndian.AppendUint32(msg, o.Seq)
 	return msg
 }
 
+// Request to retransmit every frame starting with Seq.
+// Implicitly acknowledges frames before Seq
+// (8 bytes)
 type RepeatRequest struct {
-	Id ID
+	Id  ID     // Window ID, 0 for whole connection
+	Seq uint32 // First missing sequence number
 }
 
 func (o *RepeatRequest) Encode() Msg {
 	msg := []uint8{uint8(REPEAT)}
 	msg = binary.LittleEndian.AppendUint32(msg, uint32(o.Id))
+	msg = binary.LittleEndian.AppendUint32(msg, o.Seq)
 	return msg
 }
 
@@ -508,7 +979,7 @@ func (o *ScreenRequest) Encode() Msg {
 
 type ReplyScreenRequest struct {
 	Width, Height int32
-	Mode          termbox.OutputMode
+	Mode          OutputMode
 }
 
 func (o *ReplyScreenRequest) Encode() Msg {
@@ -1,9 +1,11 @@
 package fwsprotocol
 
 import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
 	"testing"
-
-	"github.com/nsf/termbox-go"
 )
 
 func TestNewWindowRequest(t *testing.T) {
@@ -79,11 +81,20 @@ func TestReplyCreationRequest(t *testing.T) {
 }
 
 func TestReplyGetRequest(t *testing.T) {
-	replyGetRequest := ReplyGetRequest{Cell{rune(456), Color{1, 2, 3, 4}, Color{5, 6, 7, 8}, Bold}}
+	replyGetRequest := ReplyGetRequest{Id: 123, X: 10, Y: 20, C: Cell{Ch: rune(456), Fg: Color{1, 2, 3, 4}, Bg: Color{5, 6, 7, 8}, Attribute: Bold}}
 	encoded := replyGetRequest.Encode()
 	decoded := encoded.Decode()
 	switch tdecode := decoded.(type) {
 	case *ReplyGetRequest:
+		if tdecode.Id != replyGetRequest.Id {
+			t.Errorf("Id field decoding failed: expected %d, got %d\n", replyGetRequest.Id, tdecode.Id)
+		}
+		if tdecode.X != replyGetRequest.X {
+			t.Errorf("X field decoding failed: expected %d, got %d\n", replyGetRequest.X, tdecode.X)
+		}
+		if tdecode.Y != replyGetRequest.Y {
+			t.Errorf("Y field decoding failed: expected %d, got %d\n", replyGetRequest.Y, tdecode.Y)
+		}
 		if tdecode.C.Ch != replyGetRequest.C.Ch {
 			t.Errorf("Ch field decoding failed: expected %d, got %d\n", replyGetRequest.C.Ch, tdecode.C.Ch)
 		}
@@ -122,10 +133,10 @@ func TestReplyGetRequest(t *testing.T) {
 func TestEventRequest(t *testing.T) {
 	eventRequest := EventRequest{
 		Id: 123,
-		Event: termbox.Event{
-			Type:   termbox.EventKey,
-			Mod:    termbox.ModAlt,
-			Key:    termbox.KeyArrowDown,
+		Event: Event{
+			Type:   EventKey,
+			Mod:    ModAlt,
+			Key:    KeyArrowDown,
 			Ch:     rune(12),
 			Width:  10,
 			Height: 20,
@@ -173,7 +184,7 @@ func TestEventRequest(t *testing.T) {
 }
 
 func TestDrawRequest(t *testing.T) {
-	replyGetRequest := DrawRequest{123, 10, 20, Cell{rune(456), Color{1, 2, 3, 4}, Color{5, 6, 7, 8}, Bold}}
+	replyGetRequest := DrawRequest{123, 10, 20, Cell{Ch: rune(456), Fg: Color{1, 2, 3, 4}, Bg: Color{5, 6, 7, 8}, Attribute: Bold}}
 	encoded := replyGetRequest.Encode()
 	decoded := encoded.Decode()
 	switch tdecode := decoded.(type) {
@@ -293,6 +304,114 @@ func TestDrawFillRequest(t *testing.T) {
 	}
 }
 
+func TestDrawRectRequest(t *testing.T) {
+	drawRectRequest := DrawRectRequest{Id: 123, X: 7, Y: -2, Width: 3, Height: 2}
+	drawRectRequest.Img = make([][]Cell, drawRectRequest.Width)
+	for i := 0; i < drawRectRequest.Width; i++ {
+		drawRectRequest.Img[i] = make([]Cell, drawRectRequest.Height)
+		for j := 0; j < drawRectRequest.Height; j++ {
+			drawRectRequest.Img[i][j] = Cell{Ch: rune('a' + i + j), Fg: Color{10, 20, 30, 40}, Attribute: Bold}
+		}
+	}
+	encoded := drawRectRequest.Encode()
+	decoded := encoded.Decode()
+	switch tdecode := decoded.(type) {
+	case *DrawRectRequest:
+		if tdecode.Id != drawRectRequest.Id {
+			t.Errorf("Id field decoding failed: expected %d, got %d\n", drawRectRequest.Id, tdecode.Id)
+		}
+		if tdecode.X != drawRectRequest.X || tdecode.Y != drawRectRequest.Y {
+			t.Errorf("Origin decoding failed: expected %d,%d, got %d,%d\n", drawRectRequest.X, drawRectRequest.Y, tdecode.X, tdecode.Y)
+		}
+		if tdecode.Width != drawRectRequest.Width || tdecode.Height != drawRectRequest.Height {
+			t.Fatalf("Size decoding failed: expected %dx%d, got %dx%d\n", drawRectRequest.Width, drawRectRequest.Height, tdecode.Width, tdecode.Height)
+		}
+		for i := 0; i < tdecode.Width; i++ {
+			for j := 0; j < tdecode.Height; j++ {
+				if tdecode.Img[i][j] != drawRectRequest.Img[i][j] {
+					t.Errorf("[%d][%d] cell decoding failed: expected %v, got %v\n", i, j, drawRectRequest.Img[i][j], tdecode.Img[i][j])
+				}
+			}
+		}
+	default:
+		t.Errorf("Wrong decoded type: %v\n", tdecode)
+	}
+}
+
+func TestDrawTextRequest(t *testing.T) {
+	drawTextRequest := DrawTextRequest{
+		Id:        5,
+		X:         -1,
+		Y:         2,
+		Text:      "ok, 世界",
+		Fg:        Color{255, 1, 2, 3},
+		Bg:        Color{255, 4, 5, 6},
+		Attribute: Bold | Underline}
+	encoded := drawTextRequest.Encode()
+	decoded := encoded.Decode()
+	switch tdecode := decoded.(type) {
+	case *DrawTextRequest:
+		if *tdecode != drawTextRequest {
+			t.Errorf("Decoding failed: expected %v, got %v\n", drawTextRequest, *tdecode)
+		}
+	default:
+		t.Errorf("Wrong decoded type: %v\n", tdecode)
+	}
+}
+
+func TestLinkRequest(t *testing.T) {
+	linkRequest := LinkRequest{
+		Id:   5,
+		Link: 3,
+		URI:  "https://example.com/?q=世界"}
+	encoded := linkRequest.Encode()
+	decoded := encoded.Decode()
+	switch tdecode := decoded.(type) {
+	case *LinkRequest:
+		if *tdecode != linkRequest {
+			t.Errorf("Decoding failed: expected %v, got %v\n", linkRequest, *tdecode)
+		}
+	default:
+		t.Errorf("Wrong decoded type: %v\n", tdecode)
+	}
+	for _, uri := range []string{"x\x1b]0;pwned\a", "a\nb", "a\u009bb", "\xff"} {
+		msg := (&LinkRequest{Id: 5, Link: 3, URI: uri}).Encode()
+		if _, err := DecodeMsg(msg); !errors.Is(err, ErrBadURI) {
+			t.Errorf("URI %q: expected ErrBadURI, got %v\n", uri, err)
+		}
+	}
+}
+
+func TestDrawTextCells(t *testing.T) {
+	text := DrawTextRequest{Id: 5, X: 1, Y: 2, Text: "a世\tbe\u0301", Fg: Color{255, 1, 2, 3}, Attribute: Bold}
+	cells := text.Cells()
+	expected := []string{"a", "世", "\x00", "b", "e\u0301"}
+	if len(cells) != len(expected) {
+		t.Fatalf("Cell count: expected %d, got %d\n", len(expected), len(cells))
+	}
+	for i, glyph := range expected {
+		if cells[i].Glyph() != glyph {
+			t.Errorf("Cell %d: expected %q, got %q\n", i, glyph, cells[i].Glyph())
+		}
+		if cells[i].Continuation != (i == 2) {
+			t.Errorf("Cell %d: unexpected continuation flag %t\n", i, cells[i].Continuation)
+		}
+		if cells[i].Fg != text.Fg || cells[i].Attribute != Bold {
+			t.Errorf("Cell %d: style lost: %v\n", i, cells[i])
+		}
+	}
+	rect := text.Rect()
+	if rect.Id != 5 || rect.X != 1 || rect.Y != 2 || rect.Width != 5 || rect.Height != 1 {
+		t.Errorf("Rectangle: unexpected header %d %d,%d %dx%d\n", rect.Id, rect.X, rect.Y, rect.Width, rect.Height)
+	}
+	if rect.Img[1][0].Ch != '世' {
+		t.Errorf("Rectangle cell: expected %q, got %q\n", '世', rect.Img[1][0].Ch)
+	}
+	if _, err := DecodeMsg(rect.Encode()); err != nil {
+		t.Errorf("Rectangle encoding: unexpected error %v\n", err)
+	}
+}
+
 func TestRender(t *testing.T) {
 	renderRequest := RenderRequest{Id: 1234}
 	encoded := renderRequest.Encode()
@@ -383,7 +502,7 @@ func TestUnfocus(t *testing.T) {
 }
 
 func TestAck(t *testing.T) {
-	ackRequest := AckRequest{Id: 1234}
+	ackRequest := AckRequest{Id: 1234, Seq: 5678}
 	encoded := ackRequest.Encode()
 	decoded := encoded.Decode()
 	switch tdecode := decoded.(type) {
@@ -391,11 +510,14 @@ func TestAck(t *testing.T) {
 		if tdecode.Id != ackRequest.Id {
 			t.Errorf("Id field decoding failed: expected %d, got %d\n", ackRequest.Id, tdecode.Id)
 		}
+		if tdecode.Seq != ackRequest.Seq {
+			t.Errorf("Seq field decoding failed: expected %d, got %d\n", ackRequest.Seq, tdecode.Seq)
+		}
 	}
 }
 
 func TestRepeatRequest(t *testing.T) {
-	repeatRequest := RepeatRequest{Id: 1234}
+	repeatRequest := RepeatRequest{Id: 1234, Seq: 5678}
 	encoded := repeatRequest.Encode()
 	decoded := encoded.Decode()
 	switch tdecode := decoded.(type) {
@@ -403,6 +525,9 @@ func TestRepeatRequest(t *testing.T) {
 		if tdecode.Id != repeatRequest.Id {
 			t.Errorf("Id field decoding failed: expected %d, got %d\n", repeatRequest.Id, tdecode.Id)
 		}
+		if tdecode.Seq != repeatRequest.Seq {
+			t.Errorf("Seq field decoding failed: expected %d, got %d\n", repeatRequest.Seq, tdecode.Seq)
+		}
 	}
 }
 
@@ -419,7 +544,7 @@ func TestScreenRequest(t *testing.T) {
 }
 
 func TestReplyScreenRequest(t *testing.T) {
-	screenRequest := ReplyScreenRequest{Width: 80, Height: 25, Mode: termbox.Output256}
+	screenRequest := ReplyScreenRequest{Width: 80, Height: 25, Mode: Output256}
 	encoded := screenRequest.Encode()
 	decoded := encoded.Decode()
 
@@ -436,3 +561,334 @@ func TestReplyScreenRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeMsgErrors(t *testing.T) {
+	cell := Cell{Ch: rune(456), Fg: Color{1, 2, 3, 4}, Bg: Color{5, 6, 7, 8}, Attribute: Bold}
+	valid := []Request{
+		&NewWindowRequest{Pid: 1, X: 2, Y: 3, Width: 4, Height: 5, LayerAttr: TOP},
+		&GetRequest{Id: 1, X: 2, Y: 3},
+		&ReplyCreationRequest{Id: 1},
+		&ReplyGetRequest{Id: 1, X: 2, Y: 3, C: cell},
+		&EventRequest{Id: 1, Event: Event{Type: EventKey, Ch: 'a'}},
+		&DrawRequest{Id: 1, X: 2, Y: 3, Cell: cell},
+		&DrawFillRequest{Id: 1, Width: 1, Height: 2, Img: [][]Cell{{cell, cell}}},
+		&DrawRectRequest{Id: 1, X: -1, Y: 2, Width: 2, Height: 1, Img: [][]Cell{{cell}, {cell}}},
+		&DrawTextRequest{Id: 1, X: 2, Y: 3, Text: "text", Fg: Color{1, 2, 3, 4}, Attribute: Bold},
+		&LinkRequest{Id: 1, Link: 2, URI: "file:///tmp"},
+		&PackedFillRequest{Id: 1, Width: 2, Height: 1, Encoding: EncodingRLE, Data: append([]uint8{2}, cell.Encode()...)},
+		&RenderRequest{Id: 1},
+		&DeleteRequest{Id: 1},
+		&ResizeRequest{Id: 1, Width: 2, Height: 3},
+		&MoveRequest{Id: 1, X: -2, Y: 3},
+		&FocusRequest{Id: 1},
+		&UnfocusRequest{Id: 1},
+		&AckRequest{Id: 1, Seq: 2},
+		&RepeatRequest{Id: 1, Seq: 2},
+		&ScreenRequest{Id: 1},
+		&ReplyScreenRequest{Width: 80, Height: 25, Mode: OutputRGB},
+	}
+	for _, req := range valid {
+		encoded := req.Encode()
+		if _, err := DecodeMsg(encoded); err != nil {
+			t.Errorf("Header %d: unexpected error: %v\n", encoded[0], err)
+		}
+		for n := 1; n < len(encoded); n++ {
+			if _, err := DecodeMsg(encoded[:n]); !errors.Is(err, ErrTruncated) {
+				t.Errorf("Header %d truncated to %d bytes: expected ErrTruncated, got %v\n", encoded[0], n, err)
+			}
+		}
+		if _, err := DecodeMsg(append(encoded, 0)); !errors.Is(err, ErrTrailingBytes) {
+			t.Errorf("Header %d with trailing byte: expected ErrTrailingBytes, got %v\n", encoded[0], err)
+		}
+	}
+
+	if _, err := DecodeMsg(Msg{}); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Empty message: expected ErrTruncated, got %v\n", err)
+	}
+	if _, err := DecodeMsg(Msg{0xFF, 1, 2, 3, 4}); !errors.Is(err, ErrUnknownHeader) {
+		t.Errorf("Unknown header: expected ErrUnknownHeader, got %v\n", err)
+	}
+	if decoded := (&Msg{0xFF}).Decode(); decoded != nil {
+		t.Errorf("Decode of unknown header: expected nil, got %v\n", decoded)
+	}
+	if _, err := DecodeMsg((&ResizeRequest{Id: 1, Width: -1, Height: 3}).Encode()); !errors.Is(err, ErrBadDimensions) {
+		t.Errorf("Negative resize: expected ErrBadDimensions, got %v\n", err)
+	}
+}
+
+func TestDecodeMsgDrawFillDimensions(t *testing.T) {
+	fill := func(width, height uint64, cells int) Msg {
+		msg := Msg{uint8(DRAW_FILL), 1, 0, 0, 0}
+		msg = binary.LittleEndian.AppendUint64(msg, width)
+		msg = binary.LittleEndian.AppendUint64(msg, height)
+		return append(msg, make([]uint8, cells*cellSize)...)
+	}
+	tests := []struct {
+		name     string
+		msg      Msg
+		expected error
+	}{
+		{"empty", fill(0, 0, 0), nil},
+		{"exact", fill(3, 2, 6), nil},
+		{"short", fill(3, 2, 5), ErrTruncated},
+		{"long", fill(3, 2, 7), ErrTrailingBytes},
+		{"huge", fill(1<<18, 1<<18, 1), ErrTruncated},
+		{"overflow", fill(1<<62, 1<<62, 1), ErrBadDimensions},
+		{"negative", fill(math.MaxUint64, 1, 1), ErrBadDimensions},
+		{"wide empty", fill(1<<40, 0, 0), ErrBadDimensions},
+		{"tall empty", fill(0, 1<<40, 0), ErrBadDimensions},
+		{"narrow empty", fill(3, 0, 0), nil},
+	}
+	for _, test := range tests {
+		_, err := DecodeMsg(test.msg)
+		if test.expected == nil && err != nil {
+			t.Errorf("%s: unexpected error: %v\n", test.name, err)
+		}
+		if test.expected != nil && !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v\n", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestDecodeMsgDrawRectDimensions(t *testing.T) {
+	rect := func(width, height uint64, cells int) Msg {
+		msg := Msg{uint8(DRAW_RECT), 1, 0, 0, 0}
+		msg = binary.LittleEndian.AppendUint64(msg, 1)
+		msg = binary.LittleEndian.AppendUint64(msg, 2)
+		msg = binary.LittleEndian.AppendUint64(msg, width)
+		msg = binary.LittleEndian.AppendUint64(msg, height)
+		return append(msg, make([]uint8, cells*cellSize)...)
+	}
+	tests := []struct {
+		name     string
+		msg      Msg
+		expected error
+	}{
+		{"exact", rect(2, 3, 6), nil},
+		{"short", rect(2, 3, 5), ErrTruncated},
+		{"negative", rect(1, math.MaxUint64, 1), ErrBadDimensions},
+		{"wide empty", rect(1<<40, 0, 0), ErrBadDimensions},
+		{"tall empty", rect(0, 1<<40, 0), ErrBadDimensions},
+	}
+	for _, test := range tests {
+		_, err := DecodeMsg(test.msg)
+		if test.expected == nil && err != nil {
+			t.Errorf("%s: unexpected error: %v\n", test.name, err)
+		}
+		if test.expected != nil && !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v\n", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestNewWindowNegativePosition(t *testing.T) {
+	windowRequest := NewWindowRequest{X: -3, Y: -4, Width: 10, Height: 20}
+	decoded, err := DecodeMsg(windowRequest.Encode())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if tdecode := decoded.(*NewWindowRequest); tdecode.X != -3 || tdecode.Y != -4 {
+		t.Errorf("Position decoding failed: expected %d,%d, got %d,%d\n", -3, -4, tdecode.X, tdecode.Y)
+	}
+}
+
+func TestCellOver(t *testing.T) {
+	underlying := Cell{Ch: 'u', Fg: Color{255, 200, 0, 0}, Bg: Color{255, 0, 0, 200}, Attribute: Bold}
+	opaque := Cell{Ch: 'o', Fg: Color{255, 0, 200, 0}, Bg: Color{255, 10, 10, 10}, Attribute: Underline}
+	if blended := opaque.Over(underlying); blended != opaque {
+		t.Errorf("Opaque cell blending failed: expected %v, got %v\n", opaque, blended)
+	}
+	transparent := Cell{}
+	if blended := transparent.Over(underlying); blended != underlying {
+		t.Errorf("Transparent cell blending failed: expected %v, got %v\n", underlying, blended)
+	}
+	tinted := Cell{Ch: ' ', Bg: Color{127, 255, 255, 255}}
+	blended := tinted.Over(underlying)
+	if blended.Ch != 'u' || blended.Attribute != Bold {
+		t.Errorf("Glyph under tinted cell lost: got %v\n", blended)
+	}
+	if blended.Bg.A != 255 || blended.Bg.R < 120 || blended.Bg.R > 135 {
+		t.Errorf("Background blending failed: got %v\n", blended.Bg)
+	}
+	none := Color{}
+	if color := none.Over(Color{}); color != (Color{}) {
+		t.Errorf("Transparent color blending failed: got %v\n", color)
+	}
+}
+
+func TestCellWidth(t *testing.T) {
+	tests := []struct {
+		cell     Cell
+		expected int
+	}{
+		{Cell{Ch: 'a'}, 1},
+		{Cell{}, 1},
+		{Cell{Ch: '世'}, 2},
+		{Cell{Ch: 'e', Grapheme: "e\u0301"}, 1},
+		{Cell{Ch: '👍', Grapheme: "👍🏽"}, 2},
+		{Cell{Ch: '🇩', Grapheme: "🇩🇪"}, 2},
+		{Cell{Continuation: true}, 0},
+	}
+	for _, test := range tests {
+		if width := test.cell.Width(); width != test.expected {
+			t.Errorf("Width of %q: expected %d, got %d\n", test.cell.Glyph(), test.expected, width)
+		}
+	}
+
+	var cell Cell
+	cell.SetGrapheme("👍🏽")
+	if cell.Ch != '👍' || cell.Grapheme != "👍🏽" {
+		t.Errorf("Grapheme setting failed: got %q and %q\n", cell.Ch, cell.Grapheme)
+	}
+	cell.SetGrapheme("x")
+	if cell.Ch != 'x' || cell.Grapheme != "" {
+		t.Errorf("Single rune setting failed: got %q and %q\n", cell.Ch, cell.Grapheme)
+	}
+}
+
+func TestCellDowngrade(t *testing.T) {
+	red := Color{255, 255, 0, 0}
+	cell := Cell{Ch: 'e', Grapheme: "e\u0301", Attribute: Bold | CurlyUnderline | Strikethrough, UnderlineColor: red, Link: 2}
+	tests := []struct {
+		minor    uint16
+		expected Cell
+	}{
+		{6, cell},
+		{5, Cell{Ch: 'e', Grapheme: "e\u0301", Attribute: Bold | CurlyUnderline | Strikethrough, UnderlineColor: red}},
+		{4, Cell{Ch: 'e', Grapheme: "e\u0301", Attribute: Bold | Underline}},
+		{0, Cell{Ch: 'e', Attribute: Bold | Underline}},
+	}
+	for _, test := range tests {
+		if got := cell.Downgrade(test.minor); got != test.expected {
+			t.Errorf("Revision %d: expected %v, got %v\n", test.minor, test.expected, got)
+		}
+	}
+	if got := (Cell{Ch: 'x', Bg: red, Continuation: true}).Downgrade(3); got != (Cell{Bg: red}) {
+		t.Errorf("Continuation cell: expected empty cell, got %v\n", got)
+	}
+}
+
+func TestCellExtension(t *testing.T) {
+	cells := []Cell{
+		{Ch: 'a', Fg: Color{255, 1, 2, 3}, Attribute: Bold},
+		{Ch: 'e', Grapheme: "e\u0301", Attribute: Underline},
+		{Bg: Color{255, 4, 5, 6}, Continuation: true},
+		{Ch: '👍', Grapheme: "👍🏽", Continuation: true},
+		{Ch: 'w', Attribute: CurlyUnderline, UnderlineColor: Color{255, 255, 0, 0}},
+		{Ch: 'e', Grapheme: "e\u0301", Attribute: Strikethrough | DoubleUnderline, UnderlineColor: Color{100, 0, 0, 255}},
+		{Ch: 'h', Attribute: Underline, Link: 1},
+		{Ch: '世', Grapheme: "世", UnderlineColor: Color{255, 1, 1, 1}, Link: 1 << 31, Continuation: true},
+	}
+	for _, cell := range cells {
+		draw := DrawRequest{Id: 1, Cell: cell}
+		decoded, err := DecodeMsg(draw.Encode())
+		if err != nil {
+			t.Fatalf("Cell %q: unexpected error: %v\n", cell.Glyph(), err)
+		}
+		if got := decoded.(*DrawRequest).Cell; got != cell {
+			t.Errorf("Cell decoding failed: expected %v, got %v\n", cell, got)
+		}
+	}
+	if size := len(cells[0].Encode()); size != cellSize {
+		t.Errorf("Plain cell size: expected %d, got %d\n", cellSize, size)
+	}
+
+	// Variable size cells in rectangle
+	fill := DrawFillRequest{Id: 1, Width: 2, Height: 2, Img: [][]Cell{cells[:2], cells[2:4]}}
+	decoded, err := DecodeMsg(fill.Encode())
+	if err != nil {
+		t.Fatalf("Fill with extended cells: unexpected error: %v\n", err)
+	}
+	for i := range fill.Img {
+		for j := range fill.Img[i] {
+			if got := decoded.(*DrawFillRequest).Img[i][j]; got != fill.Img[i][j] {
+				t.Errorf("[%d][%d] cell decoding failed: expected %v, got %v\n", i, j, fill.Img[i][j], got)
+			}
+		}
+	}
+
+	// Marker bit is not an attribute
+	marked := Cell{Ch: 'm', Attribute: Bold | attrExtended}
+	if got := (&DrawRequest{Cell: marked}).Encode(); len(got) != 1+4+16+cellSize {
+		t.Errorf("Cell with marker attribute encoded with extension\n")
+	}
+	// Too long cluster is cut to whole runes, 'e' and 127 accents
+	long := Cell{Ch: 'e', Grapheme: "e" + strings.Repeat("\u0301", 150)}
+	decoded, err = DecodeMsg((&DrawRequest{Cell: long}).Encode())
+	if err != nil {
+		t.Fatalf("Too long grapheme cluster: unexpected error: %v\n", err)
+	}
+	if got := decoded.(*DrawRequest).Cell.Grapheme; got != long.Grapheme[:MaxGraphemeSize] {
+		t.Errorf("Too long grapheme cluster: expected %d bytes prefix, got %d bytes %q\n", MaxGraphemeSize, len(got), got)
+	}
+	bad := (&DrawRequest{Cell: Cell{Continuation: true}}).Encode()
+	bad[len(bad)-1] |= 1 << 7
+	if _, err := DecodeMsg(bad); !errors.Is(err, ErrBadCell) {
+		t.Errorf("Unknown extension flags: expected ErrBadCell, got %v\n", err)
+	}
+	for _, cell := range []Cell{
+		{Ch: 'a', Grapheme: "a\xff"},
+		{Ch: 'x', Grapheme: "x\x1b]0;pwned\a"},
+		{Ch: 'a', Grapheme: "ab"},
+		{Ch: 'x', Grapheme: "e\u0301"},
+	} {
+		if _, err := DecodeMsg((&DrawRequest{Cell: cell}).Encode()); !errors.Is(err, ErrBadCell) {
+			t.Errorf("Grapheme %q of rune %q: expected ErrBadCell, got %v\n", cell.Grapheme, cell.Ch, err)
+		}
+	}
+}
+
+func TestCellOverWideGlyph(t *testing.T) {
+	wide := Cell{Ch: '世', Fg: Color{255, 1, 1, 1}, Bg: Color{255, 0, 0, 0}}
+	half := Cell{Fg: wide.Fg, Bg: wide.Bg, Continuation: true}
+	tinted := Cell{Ch: ' ', Bg: Color{100, 255, 0, 0}}
+	if blended := tinted.Over(half); !blended.Continuation {
+		t.Errorf("Continuation under translucent cell lost: got %v\n", blended)
+	}
+	grapheme := Cell{Ch: 'e', Grapheme: "e\u0301", Bg: Color{255, 0, 0, 0}}
+	if blended := tinted.Over(grapheme); blended.Grapheme != grapheme.Grapheme {
+		t.Errorf("Grapheme under translucent cell lost: got %v\n", blended)
+	}
+	// Continuation cell of translucent wide glyph hides underlying glyph
+	over := Cell{Bg: Color{100, 255, 0, 0}, Continuation: true}
+	if blended := over.Over(Cell{Ch: 'u', Bg: Color{255, 0, 0, 0}}); blended.Ch != 0 || !blended.Continuation {
+		t.Errorf("Continuation over glyph: got %v\n", blended)
+	}
+}
+
+func TestCellExtendedAttributes(t *testing.T) {
+	// Extended attributes fit into plain cell
+	cell := Cell{Ch: 's', Attribute: Strikethrough | DoubleUnderline | CurlyUnderline | Underline}
+	if size := len(cell.Encode()); size != cellSize {
+		t.Errorf("Cell with extended attributes: expected %d bytes, got %d\n", cellSize, size)
+	}
+	cell.UnderlineColor = Color{255, 255, 0, 0}
+	if size := len(cell.Encode()); size != cellSize+1+4 {
+		t.Errorf("Cell with underline color: expected %d bytes, got %d\n", cellSize+5, size)
+	}
+
+	// Underline color is tinted as foreground
+	tinted := Cell{Ch: ' ', Bg: Color{128, 0, 0, 255}}
+	blended := tinted.Over(cell)
+	if blended.UnderlineColor != tinted.Bg.Over(cell.UnderlineColor) || blended.Attribute != cell.Attribute {
+		t.Errorf("Underline under translucent cell: got %v\n", blended)
+	}
+	if blended := tinted.Over(Cell{Ch: 'u', Attribute: Underline}); blended.UnderlineColor != (Color{}) {
+		t.Errorf("Default underline color was tinted: got %v\n", blended.UnderlineColor)
+	}
+}
+
+func TestCellOverLink(t *testing.T) {
+	linked := Cell{Ch: 'l', Fg: Color{255, 0, 0, 255}, Bg: Color{255, 0, 0, 0}, Attribute: Underline, Link: 7}
+	tinted := Cell{Ch: ' ', Bg: Color{100, 255, 0, 0}}
+	if blended := tinted.Over(linked); blended.Link != linked.Link {
+		t.Errorf("Link under translucent cell: expected %d, got %d\n", linked.Link, blended.Link)
+	}
+	glyph := Cell{Ch: 'g', Bg: Color{100, 255, 0, 0}}
+	if blended := glyph.Over(linked); blended.Link != 0 {
+		t.Errorf("Link under glyph: expected 0, got %d\n", blended.Link)
+	}
+	if blended := linked.Over(Cell{Ch: 'u', Link: 2}); blended.Link != linked.Link {
+		t.Errorf("Link over cell: expected %d, got %d\n", linked.Link, blended.Link)
+	}
+}
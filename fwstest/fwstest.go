@@ -0,0 +1,203 @@
+// Golden snapshot testing of rendered screens
+//
+// Snapshot is text file with screen runes framed by bars, followed by
+// style grid where every cell is legend key, and the legend itself:
+//
+//	|hello |
+//	|>     |
+//
+//	|AAAAA.|
+//	|B.....|
+//
+//	A fg=#ffffff bg=#0000ff attr=bold
+//	B fg=#ffffff bg=#0000ff link=https://example.com
+//
+// Golden files are rewritten by running tests with -update flag
+package fwstest
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+var update = flag.Bool("update", false, "rewrite golden screen snapshots")
+
+// Rendered screen, implemented by headless.Screen
+type Screen interface {
+	Cells() [][]fws.Cell // Screen image, cells[x][y]
+}
+
+// Screen resolving global links, link URIs are written instead of IDs
+type Linker interface {
+	URI(link uint32) string
+}
+
+// Legend keys in order of first use, empty style is '.'
+const keys = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+func key(i int) rune {
+	if i < len(keys) {
+		return rune(keys[i])
+	}
+	return rune(0xC0 + i - len(keys))
+}
+
+// Cell style, everything except glyph
+type style struct {
+	fg, bg, ul fws.Color
+	attr       fws.Attr
+	link       uint32
+}
+
+func styleOf(cell fws.Cell) style {
+	return style{cell.Fg, cell.Bg, cell.UnderlineColor, cell.Attribute, cell.Link}
+}
+
+func (s style) describe(uri func(uint32) string) string {
+	parts := []string{"fg=" + s.fg.String(), "bg=" + s.bg.String()}
+	if s.ul != (fws.Color{}) {
+		parts = append(parts, "ul="+s.ul.String())
+	}
+	if s.attr != 0 {
+		parts = append(parts, "attr="+s.attr.String())
+	}
+	if s.link != 0 {
+		if uri != nil {
+			parts = append(parts, "link="+uri(s.link))
+		} else {
+			parts = append(parts, fmt.Sprintf("link=%d", s.link))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Serializes screen image cells[x][y] into snapshot text.
+// uri resolves link IDs and may be nil
+func Format(cells [][]fws.Cell, uri func(link uint32) string) string {
+	height := 0
+	if len(cells) > 0 {
+		height = len(cells[0])
+	}
+	var text, grid strings.Builder
+	styles := make(map[style]rune)
+	var legend []string
+	for y := 0; y < height; y++ {
+		text.WriteByte('|')
+		grid.WriteByte('|')
+		for x := range cells {
+			cell := cells[x][y]
+			switch {
+			case cell.Continuation:
+				// Wide glyph already takes this column
+			case cell.Grapheme != "":
+				text.WriteString(cell.Grapheme)
+			case cell.Ch < ' ' || cell.Ch == 0x7F:
+				text.WriteByte(' ')
+			default:
+				text.WriteRune(cell.Ch)
+			}
+			s := styleOf(cell)
+			k, ok := styles[s]
+			if !ok {
+				k = '.'
+				if s != (style{}) {
+					k = key(len(legend))
+					legend = append(legend, string(k)+" "+s.describe(uri))
+				}
+				styles[s] = k
+			}
+			grid.WriteRune(k)
+		}
+		text.WriteString("|\n")
+		grid.WriteString("|\n")
+	}
+	out := text.String() + "\n" + grid.String()
+	if len(legend) > 0 {
+		out += "\n" + strings.Join(legend, "\n") + "\n"
+	}
+	return out
+}
+
+// Compares snapshot of screen with golden file, or rewrites the file
+// when tests run with -update flag. Mismatch is reported with side by
+// side diff
+func AssertScreen(t testing.TB, screen Screen, golden string) {
+	t.Helper()
+	var uri func(uint32) string
+	if l, ok := screen.(Linker); ok {
+		uri = l.URI
+	}
+	AssertSnapshot(t, Format(screen.Cells(), uri), golden)
+}
+
+// AssertScreen for formatted snapshot
+func AssertSnapshot(t testing.TB, got, golden string) {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v (run tests with -update to create it)", err)
+	}
+	if string(expected) != got {
+		t.Errorf("Screen does not match %s (run tests with -update to accept):\n%s", golden, Diff(string(expected), got))
+	}
+}
+
+// Diff colors are disabled by NO_COLOR environment variable
+const (
+	red   = "\x1b[31m"
+	green = "\x1b[32m"
+	reset = "\x1b[0m"
+)
+
+func paint(s, color string) string {
+	if os.Getenv("NO_COLOR") != "" {
+		return s
+	}
+	return color + s + reset
+}
+
+// Side by side line diff, expected on the left and got on the right.
+// Differing lines are marked and colored
+func Diff(expected, got string) string {
+	left := strings.Split(strings.TrimSuffix(expected, "\n"), "\n")
+	right := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	width := len("expected")
+	for _, l := range left {
+		if n := len([]rune(l)); n > width {
+			width = n
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %-*s   %s\n", width, "expected", "got")
+	for i := 0; i < len(left) || i < len(right); i++ {
+		var l, r string
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		pad := strings.Repeat(" ", width-len([]rune(l)))
+		if l == r {
+			fmt.Fprintf(&b, "  %s%s │ %s\n", l, pad, r)
+		} else {
+			fmt.Fprintf(&b, "! %s%s │ %s\n", paint(l, red), pad, paint(r, green))
+		}
+	}
+	return b.String()
+}
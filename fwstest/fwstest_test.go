@@ -0,0 +1,117 @@
+package fwstest
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/Nekhaevalex/fwsprotocol/compositor"
+	"github.com/Nekhaevalex/fwsprotocol/headless"
+)
+
+var (
+	white = fws.Color{A: 255, R: 255, G: 255, B: 255}
+	blue  = fws.Color{A: 255, B: 255}
+)
+
+func TestFormat(t *testing.T) {
+	cells := [][]fws.Cell{
+		{{Ch: 'a', Fg: white, Bg: blue, Attribute: fws.Bold | fws.Strikethrough}, {}},
+		{{Ch: '世', Fg: white}, {Ch: '\t', Link: 3}},
+		{{Continuation: true, Fg: white}, {Grapheme: "é", Fg: fws.Color{A: 128, R: 1}, UnderlineColor: blue}},
+	}
+	expected := "" +
+		"|a世|\n" +
+		"|  é|\n" +
+		"\n" +
+		"|ABB|\n" +
+		"|.CD|\n" +
+		"\n" +
+		"A fg=#ffffff bg=#0000ff attr=bold|strikethrough\n" +
+		"B fg=#ffffff bg=none\n" +
+		"C fg=none bg=none link=3\n" +
+		"D fg=#01000080 bg=none ul=#0000ff\n"
+	if got := Format(cells, nil); got != expected {
+		t.Errorf("Snapshot: expected\n%s\ngot\n%s\n", expected, got)
+	}
+	uri := func(link uint32) string { return fmt.Sprintf("https://example.com/%d", link) }
+	if got := Format(cells, uri); !strings.Contains(got, "C fg=none bg=none link=https://example.com/3\n") {
+		t.Errorf("Snapshot with links: got\n%s\n", got)
+	}
+	if got := Format(nil, nil); got != "\n" {
+		t.Errorf("Empty snapshot: got %q\n", got)
+	}
+}
+
+func TestAssertScreen(t *testing.T) {
+	c := compositor.New(10, 3)
+	c.Add(1, &fws.NewWindowRequest{X: 1, Y: 1, Width: 6, Height: 1})
+	text := &fws.DrawTextRequest{Id: 1, X: 0, Y: 0, Text: "golden", Fg: white, Bg: blue, Attribute: fws.Underline}
+	c.Apply(text)
+	c.Link(1, 1, "https://example.com")
+	c.Draw(1, 5, 0, fws.Cell{Ch: '!', Fg: white, Bg: blue, Link: 1})
+	screen := headless.New(10, 3, fws.OutputRGB)
+	if err := c.Render(screen); err != nil {
+		t.Fatal(err)
+	}
+	AssertScreen(t, screen, filepath.Join("testdata", "screen.golden"))
+}
+
+// Test recording reported failures
+type recorder struct {
+	testing.TB
+	failed  bool
+	message string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failed = true
+	r.message = fmt.Sprintf(format, args...)
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+}
+
+func TestAssertScreenMismatch(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	golden := filepath.Join(t.TempDir(), "screen.golden")
+	r := &recorder{TB: t}
+	AssertSnapshot(r, "|a|\n", golden)
+	if !r.failed || !strings.Contains(r.message, "-update") {
+		t.Errorf("Missing golden file: got %q\n", r.message)
+	}
+
+	*update = true
+	AssertSnapshot(t, "|ab|\n|cd|\n", golden)
+	*update = false
+	r = &recorder{TB: t}
+	AssertSnapshot(r, "|ab|\n|cd|\n", golden)
+	if r.failed {
+		t.Errorf("Updated golden file: got %q\n", r.message)
+	}
+	AssertSnapshot(r, "|ab|\n|ce|\n", golden)
+	expected := "" +
+		"  expected   got\n" +
+		"  |ab|     │ |ab|\n" +
+		"! |cd|     │ |ce|\n"
+	if !r.failed || !strings.HasSuffix(r.message, expected) {
+		t.Errorf("Mismatch: expected diff\n%s\ngot\n%s\n", expected, r.message)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	got := Diff("a\nb\n", "a\n")
+	expected := "" +
+		"  expected   got\n" +
+		"  a        │ a\n" +
+		"! " + red + "b" + reset + "        │ " + green + reset + "\n"
+	if got != expected {
+		t.Errorf("Diff: expected %q, got %q\n", expected, got)
+	}
+}
@@ -2,9 +2,8 @@ module github.com/Nekhaevalex/fwsprotocol
 
 go 1.20
 
-require github.com/nsf/termbox-go v1.1.1
-
 require (
-	github.com/mattn/go-runewidth v0.0.14 // indirect
-	github.com/rivo/uniseg v0.4.4 // indirect
+	github.com/mattn/go-runewidth v0.0.14
+	github.com/nsf/termbox-go v1.1.1
+	github.com/rivo/uniseg v0.4.4
 )
@@ -0,0 +1,145 @@
+package fwsprotocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Protocol revision implemented by this package
+const (
+	ProtocolMajor uint16 = 1 // Incremented on incompatible wire format changes
+	ProtocolMinor uint16 = 6 // Incremented when new messages or cell extensions are added
+)
+
+// Optional protocol features bitmask
+// (4 bytes)
+type Capability uint32
+
+const (
+	CapTrueColor   Capability = 1 << iota // 24 bit colors are shown without palette reduction
+	CapMouse                              // Mouse events are delivered to windows
+	CapCompression                        // Run-length encoded DRAW_PACKED images are accepted
+	CapClipboard                          // Clipboard exchange is available
+	CapPalette                            // Palette encoded DRAW_PACKED images are accepted
+	CapDelta                              // Delta encoded DRAW_PACKED images are accepted
+	CapHyperlinks                         // Cell links are shown by server terminal as hyperlinks
+)
+
+// First message minor revision, headers not listed here exist since 0
+var headerMinor = map[Header]uint16{
+	DRAW_RECT:   1,
+	DRAW_PACKED: 2,
+	DRAW_TEXT:   3,
+	LINK:        6,
+}
+
+var (
+	ErrHandshake       = errors.New("fwsprotocol: handshake failed")
+	ErrVersionMismatch = errors.New("fwsprotocol: incompatible protocol version")
+)
+
+// Handshake message sent by app right after connection
+// (8 bytes)
+type HelloRequest struct {
+	Major, Minor uint16     // Protocol revision implemented by app
+	Caps         Capability // Capabilities supported by app
+}
+
+func (o *HelloRequest) Encode() Msg {
+	msg := []uint8{uint8(HELLO)}
+	msg = binary.LittleEndian.AppendUint16(msg, o.Major)
+	msg = binary.LittleEndian.AppendUint16(msg, o.Minor)
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(o.Caps))
+	return msg
+}
+
+// Handshake reply.
+// If accepted, contains revision and capabilities both sides agreed on,
+// otherwise revision of the server
+// (9 bytes)
+type ReplyHelloRequest struct {
+	Major, Minor uint16
+	Caps         Capability
+	Accepted     bool
+}
+
+func (o *ReplyHelloRequest) Encode() Msg {
+	msg := []uint8{uint8(REPLY_HELLO)}
+	msg = binary.LittleEndian.AppendUint16(msg, o.Major)
+	msg = binary.LittleEndian.AppendUint16(msg, o.Minor)
+	msg = binary.LittleEndian.AppendUint32(msg, uint32(o.Caps))
+	if o.Accepted {
+		msg = append(msg, 1)
+	} else {
+		msg = append(msg, 0)
+	}
+	return msg
+}
+
+// Reports whether negotiated revision includes specified message type
+func (o *ReplyHelloRequest) Supports(h Header) bool {
+	return o.Accepted && headerMinor[h] <= o.Minor
+}
+
+// Negotiates revision and capabilities of server with app HELLO.
+// Major revisions must be equal, minor is downgraded to the older side
+func Negotiate(hello *HelloRequest, caps Capability) *ReplyHelloRequest {
+	if hello.Major != ProtocolMajor {
+		return &ReplyHelloRequest{Major: ProtocolMajor, Minor: ProtocolMinor, Caps: caps, Accepted: false}
+	}
+	minor := ProtocolMinor
+	if hello.Minor < minor {
+		minor = hello.Minor
+	}
+	return &ReplyHelloRequest{Major: ProtocolMajor, Minor: minor, Caps: hello.Caps & caps, Accepted: true}
+}
+
+// Performs app side of handshake: sends HELLO and waits for REPLY_HELLO
+func ClientHandshake(enc *Encoder, dec *Decoder, caps Capability) (*ReplyHelloRequest, error) {
+	seq, err := enc.Send(&HelloRequest{Major: ProtocolMajor, Minor: ProtocolMinor, Caps: caps}, 0)
+	if err != nil {
+		return nil, err
+	}
+	f, err := dec.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	req, err := DecodeMsg(f.Msg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHandshake, err)
+	}
+	reply, ok := req.(*ReplyHelloRequest)
+	if !ok || f.Ref != seq {
+		return nil, fmt.Errorf("%w: expected REPLY_HELLO to %d, got header %d to %d", ErrHandshake, seq, f.Msg[0], f.Ref)
+	}
+	if !reply.Accepted || reply.Major != ProtocolMajor {
+		return reply, fmt.Errorf("%w: app %d.%d, server %d.%d", ErrVersionMismatch, ProtocolMajor, ProtocolMinor, reply.Major, reply.Minor)
+	}
+	return reply, nil
+}
+
+// Performs server side of handshake: waits for HELLO and answers with
+// negotiated revision. Rejected apps get a reply before error is returned
+func ServerHandshake(enc *Encoder, dec *Decoder, caps Capability) (*ReplyHelloRequest, error) {
+	f, err := dec.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	req, err := DecodeMsg(f.Msg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHandshake, err)
+	}
+	hello, ok := req.(*HelloRequest)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected HELLO, got header %d", ErrHandshake, f.Msg[0])
+	}
+	reply := Negotiate(hello, caps)
+	if _, err := enc.Send(reply, f.Seq); err != nil {
+		return nil, err
+	}
+	if !reply.Accepted {
+		return reply, fmt.Errorf("%w: app %d.%d, server %d.%d", ErrVersionMismatch, hello.Major, hello.Minor, ProtocolMajor, ProtocolMinor)
+	}
+	return reply, nil
+}
@@ -0,0 +1,132 @@
+package fwsprotocol
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestHelloRequest(t *testing.T) {
+	helloRequest := HelloRequest{Major: 1, Minor: 2, Caps: CapMouse | CapClipboard}
+	encoded := helloRequest.Encode()
+	decoded := encoded.Decode()
+	switch tdecode := decoded.(type) {
+	case *HelloRequest:
+		if *tdecode != helloRequest {
+			t.Errorf("Decoding failed: expected %v, got %v\n", helloRequest, *tdecode)
+		}
+	default:
+		t.Errorf("Wrong decoded type: %v\n", tdecode)
+	}
+}
+
+func TestReplyHelloRequest(t *testing.T) {
+	replyHelloRequest := ReplyHelloRequest{Major: 1, Minor: 2, Caps: CapTrueColor, Accepted: true}
+	encoded := replyHelloRequest.Encode()
+	decoded := encoded.Decode()
+	switch tdecode := decoded.(type) {
+	case *ReplyHelloRequest:
+		if *tdecode != replyHelloRequest {
+			t.Errorf("Decoding failed: expected %v, got %v\n", replyHelloRequest, *tdecode)
+		}
+	default:
+		t.Errorf("Wrong decoded type: %v\n", tdecode)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	reply := Negotiate(&HelloRequest{Major: ProtocolMajor, Minor: ProtocolMinor + 5, Caps: CapMouse | CapCompression}, CapMouse|CapTrueColor)
+	if !reply.Accepted {
+		t.Fatalf("Newer minor revision was rejected\n")
+	}
+	if reply.Minor != ProtocolMinor {
+		t.Errorf("Minor revision downgrade failed: expected %d, got %d\n", ProtocolMinor, reply.Minor)
+	}
+	if reply.Caps != CapMouse {
+		t.Errorf("Capability intersection failed: expected %b, got %b\n", CapMouse, reply.Caps)
+	}
+
+	reply = Negotiate(&HelloRequest{Major: ProtocolMajor + 1, Caps: CapMouse}, CapMouse)
+	if reply.Accepted {
+		t.Errorf("Different major revision was accepted\n")
+	}
+	if reply.Supports(NEW) {
+		t.Errorf("Rejected session reports supported messages\n")
+	}
+}
+
+func handshake(clientMsg func(enc *Encoder) error, serverCaps Capability) (*ReplyHelloRequest, error, error) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	serverErr := make(chan error, 1)
+	go func() {
+		_, err := ServerHandshake(NewEncoder(server), NewDecoder(server), serverCaps)
+		serverErr <- err
+	}()
+	enc, dec := NewEncoder(client), NewDecoder(client)
+	if clientMsg != nil {
+		if err := clientMsg(enc); err != nil {
+			return nil, err, <-serverErr
+		}
+		return nil, nil, <-serverErr
+	}
+	reply, err := ClientHandshake(enc, dec, CapTrueColor|CapMouse)
+	return reply, err, <-serverErr
+}
+
+func TestHandshake(t *testing.T) {
+	reply, clientErr, serverErr := handshake(nil, CapMouse|CapClipboard)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("Unexpected errors: app %v, server %v\n", clientErr, serverErr)
+	}
+	if reply.Caps != CapMouse {
+		t.Errorf("Negotiated capabilities: expected %b, got %b\n", CapMouse, reply.Caps)
+	}
+	if !reply.Supports(DRAW_FILL) {
+		t.Errorf("Negotiated session does not support DRAW_FILL\n")
+	}
+	if !reply.Supports(DRAW_RECT) {
+		t.Errorf("Negotiated session does not support DRAW_RECT\n")
+	}
+}
+
+func TestSupportsOlderMinor(t *testing.T) {
+	reply := Negotiate(&HelloRequest{Major: ProtocolMajor, Minor: 0}, CapMouse)
+	if !reply.Accepted || reply.Minor != 0 {
+		t.Fatalf("Older minor revision: expected accepted revision 0, got %v\n", reply)
+	}
+	if !reply.Supports(DRAW_FILL) {
+		t.Errorf("Revision 0 session does not support DRAW_FILL\n")
+	}
+	if reply.Supports(DRAW_RECT) {
+		t.Errorf("Revision 0 session supports DRAW_RECT\n")
+	}
+}
+
+func TestHandshakeVersionMismatch(t *testing.T) {
+	var reply Request
+	_, _, serverErr := handshake(func(enc *Encoder) error {
+		if err := enc.Encode(&HelloRequest{Major: ProtocolMajor + 1}); err != nil {
+			return err
+		}
+		var err error
+		reply, err = NewDecoder(enc.w.(net.Conn)).Decode()
+		return err
+	}, CapMouse)
+	if !errors.Is(serverErr, ErrVersionMismatch) {
+		t.Errorf("Expected ErrVersionMismatch on server, got %v\n", serverErr)
+	}
+	if hello, ok := reply.(*ReplyHelloRequest); !ok || hello.Accepted || hello.Major != ProtocolMajor {
+		t.Errorf("Expected rejecting reply with server revision, got %v\n", reply)
+	}
+}
+
+func TestHandshakeUnexpectedMessage(t *testing.T) {
+	_, _, serverErr := handshake(func(enc *Encoder) error {
+		return enc.Encode(&RenderRequest{Id: 1})
+	}, CapMouse)
+	if !errors.Is(serverErr, ErrHandshake) {
+		t.Errorf("Expected ErrHandshake, got %v\n", serverErr)
+	}
+}
@@ -0,0 +1,265 @@
+// In-memory screen for testing FWS apps and servers without terminal
+package headless
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+var (
+	ErrClosed  = errors.New("headless: screen closed")
+	ErrTimeout = errors.New("headless: timeout waiting for screen")
+)
+
+// In-memory screen.
+// Implements fws.Screen, compositor.Backend and compositor.Linker.
+// Cells set by SetCell become visible on Flush, events queued by Inject
+// are returned by PollEvent. All methods are safe for concurrent use
+type Screen struct {
+	mu      sync.Mutex
+	width   int
+	height  int
+	mode    fws.OutputMode
+	front   [][]fws.Cell      // Flushed cells, front[x][y]
+	back    [][]fws.Cell      // Cells set since previous flush
+	links   map[uint32]string // URIs of links
+	flushes int
+	flushed chan struct{} // Closed and replaced on every flush
+	closed  bool
+
+	events chan fws.Event
+	done   chan struct{} // Closed by Close
+}
+
+var _ fws.Screen = (*Screen)(nil)
+
+// Screen of specified size and output mode.
+// Every cell is empty until first flush
+func New(width, height int, mode fws.OutputMode) *Screen {
+	return &Screen{
+		width:   width,
+		height:  height,
+		mode:    mode,
+		front:   newImg(width, height),
+		back:    newImg(width, height),
+		links:   make(map[uint32]string),
+		flushed: make(chan struct{}),
+		events:  make(chan fws.Event, 64),
+		done:    make(chan struct{}),
+	}
+}
+
+func newImg(width, height int) [][]fws.Cell {
+	img := make([][]fws.Cell, width)
+	for x := range img {
+		img[x] = make([]fws.Cell, height)
+	}
+	return img
+}
+
+func copyImg(img [][]fws.Cell) [][]fws.Cell {
+	c := make([][]fws.Cell, len(img))
+	for x := range img {
+		c[x] = append([]fws.Cell(nil), img[x]...)
+	}
+	return c
+}
+
+func (s *Screen) Size() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.width, s.height
+}
+
+func (s *Screen) Mode() fws.OutputMode {
+	return s.mode
+}
+
+// Cells outside of screen are ignored
+func (s *Screen) SetCell(x, y int, cell fws.Cell) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if x < 0 || x >= s.width || y < 0 || y >= s.height {
+		return
+	}
+	s.back[x][y] = cell
+}
+
+// Sets URI of global link
+func (s *Screen) SetLink(link uint32, uri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.links[link] = uri
+}
+
+func (s *Screen) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.front = copyImg(s.back)
+	s.flushes++
+	close(s.flushed)
+	s.flushed = make(chan struct{})
+	return nil
+}
+
+// Returns next injected event, EventError after Close
+func (s *Screen) PollEvent() fws.Event {
+	select {
+	case ev := <-s.events:
+		return ev
+	case <-s.done:
+		return fws.Event{Type: fws.EventError, Err: ErrClosed}
+	}
+}
+
+// Interrupts PollEvent, later flushes fail
+func (s *Screen) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	return nil
+}
+
+// Queues synthetic input event for PollEvent.
+// Blocks when 64 events are already queued
+func (s *Screen) Inject(ev fws.Event) {
+	select {
+	case s.events <- ev:
+	case <-s.done:
+	}
+}
+
+// Queues key event of rune
+func (s *Screen) InjectRune(ch rune) {
+	s.Inject(fws.Event{Type: fws.EventKey, Ch: ch})
+}
+
+// Queues key event of special key
+func (s *Screen) InjectKey(key fws.Key, mod fws.Modifier) {
+	s.Inject(fws.Event{Type: fws.EventKey, Key: key, Mod: mod})
+}
+
+// Queues mouse event at screen coordinates
+func (s *Screen) InjectMouse(x, y int, button fws.Key) {
+	s.Inject(fws.Event{Type: fws.EventMouse, Key: button, MouseX: x, MouseY: y})
+}
+
+// Changes screen size and queues resize event.
+// Cells are kept where they fit
+func (s *Screen) Resize(width, height int) {
+	s.mu.Lock()
+	front, back := newImg(width, height), newImg(width, height)
+	for x := 0; x < width && x < s.width; x++ {
+		for y := 0; y < height && y < s.height; y++ {
+			front[x][y], back[x][y] = s.front[x][y], s.back[x][y]
+		}
+	}
+	s.width, s.height = width, height
+	s.front, s.back = front, back
+	s.mu.Unlock()
+	s.Inject(fws.Event{Type: fws.EventResize, Width: width, Height: height})
+}
+
+// Copy of flushed cells, cells[x][y]
+func (s *Screen) Cells() [][]fws.Cell {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyImg(s.front)
+}
+
+// Flushed cell, empty cell outside of screen
+func (s *Screen) Cell(x, y int) fws.Cell {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if x < 0 || x >= s.width || y < 0 || y >= s.height {
+		return fws.Cell{}
+	}
+	return s.front[x][y]
+}
+
+// URI of link set by compositor, empty if unknown
+func (s *Screen) URI(link uint32) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.links[link]
+}
+
+// Number of flushes
+func (s *Screen) Flushes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushes
+}
+
+// Flushed screen as text, one line per row.
+// Empty cells are spaces, continuation cells are skipped
+func (s *Screen) String() string {
+	return Text(s.Cells())
+}
+
+// Image cells[x][y] as text, one line per row.
+// Empty cells are spaces, continuation cells are skipped
+func Text(cells [][]fws.Cell) string {
+	var b strings.Builder
+	height := 0
+	if len(cells) > 0 {
+		height = len(cells[0])
+	}
+	for y := 0; y < height; y++ {
+		for x := range cells {
+			cell := cells[x][y]
+			switch {
+			case cell.Continuation:
+			case cell.Grapheme != "":
+				b.WriteString(cell.Grapheme)
+			case cell.Ch == 0:
+				b.WriteByte(' ')
+			default:
+				b.WriteRune(cell.Ch)
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Waits until flushed cells satisfy cond, which is checked now and after
+// every flush. Cells passed to cond must not be modified
+func (s *Screen) WaitFor(timeout time.Duration, cond func(cells [][]fws.Cell) bool) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		// Flushed image is replaced, never modified in place
+		s.mu.Lock()
+		front, flushed := s.front, s.flushed
+		s.mu.Unlock()
+		if cond(front) {
+			return nil
+		}
+		select {
+		case <-flushed:
+		case <-s.done:
+			return ErrClosed
+		case <-timer.C:
+			return ErrTimeout
+		}
+	}
+}
+
+// Waits until row y of flushed screen starts with text
+func (s *Screen) WaitText(timeout time.Duration, y int, text string) error {
+	return s.WaitFor(timeout, func(cells [][]fws.Cell) bool {
+		lines := strings.Split(Text(cells), "\n")
+		return y >= 0 && y < len(lines) && strings.HasPrefix(lines[y], text)
+	})
+}
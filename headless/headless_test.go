@@ -0,0 +1,233 @@
+package headless_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/Nekhaevalex/fwsprotocol/client"
+	"github.com/Nekhaevalex/fwsprotocol/compositor"
+	"github.com/Nekhaevalex/fwsprotocol/headless"
+	"github.com/Nekhaevalex/fwsprotocol/server"
+)
+
+var (
+	_ compositor.Backend = (*headless.Screen)(nil)
+	_ compositor.Linker  = (*headless.Screen)(nil)
+)
+
+func TestScreen(t *testing.T) {
+	s := headless.New(4, 2, fws.Output256)
+	if w, h := s.Size(); w != 4 || h != 2 || s.Mode() != fws.Output256 {
+		t.Errorf("Screen: expected 4x2 Output256, got %dx%d %d\n", w, h, s.Mode())
+	}
+	s.SetCell(1, 0, fws.Cell{Ch: 'a'})
+	s.SetCell(2, 1, fws.Cell{Grapheme: "é"})
+	s.SetCell(9, 9, fws.Cell{Ch: 'x'})
+	if s.Cell(1, 0) != (fws.Cell{}) {
+		t.Errorf("Cell is shown before flush\n")
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := s.String(), " a  \n  é \n"; got != expected {
+		t.Errorf("Text: expected %q, got %q\n", expected, got)
+	}
+	if s.Cells()[1][0].Ch != 'a' || s.Flushes() != 1 {
+		t.Errorf("Cells: got %v after %d flushes\n", s.Cells(), s.Flushes())
+	}
+	s.Resize(2, 3)
+	if got, expected := s.String(), " a\n  \n  \n"; got != expected {
+		t.Errorf("Resized text: expected %q, got %q\n", expected, got)
+	}
+	if ev := s.PollEvent(); ev.Type != fws.EventResize || ev.Width != 2 || ev.Height != 3 {
+		t.Errorf("Resize event: got %v\n", ev)
+	}
+	s.InjectKey(fws.KeyEnter, fws.ModAlt)
+	if ev := s.PollEvent(); ev.Type != fws.EventKey || ev.Key != fws.KeyEnter || ev.Mod != fws.ModAlt {
+		t.Errorf("Key event: got %v\n", ev)
+	}
+	if err := s.WaitText(10*time.Millisecond, 0, " b"); !errors.Is(err, headless.ErrTimeout) {
+		t.Errorf("Wait for missing text: expected %v, got %v\n", headless.ErrTimeout, err)
+	}
+	s.Close()
+	if ev := s.PollEvent(); ev.Type != fws.EventError || !errors.Is(ev.Err, headless.ErrClosed) {
+		t.Errorf("Event after close: got %v\n", ev)
+	}
+	if err := s.Flush(); !errors.Is(err, headless.ErrClosed) {
+		t.Errorf("Flush after close: expected %v, got %v\n", headless.ErrClosed, err)
+	}
+}
+
+// Minimal window manager composing windows onto headless screen.
+// Keys go to the top window, mouse events are routed by compositor
+type manager struct {
+	server.BaseHandler
+	comp   *compositor.Compositor
+	screen *headless.Screen
+
+	mu     sync.Mutex
+	nextID fws.ID
+	conns  map[fws.ID]*server.Conn
+}
+
+func (m *manager) OnNewWindow(c *server.Conn, req *fws.NewWindowRequest) (fws.ID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	if err := m.comp.Add(m.nextID, req); err != nil {
+		return 0, err
+	}
+	m.conns[m.nextID] = c
+	return m.nextID, nil
+}
+
+func (m *manager) OnDraw(c *server.Conn, req *fws.DrawRequest)         { m.comp.Apply(req) }
+func (m *manager) OnDrawRect(c *server.Conn, req *fws.DrawRectRequest) { m.comp.Apply(req) }
+func (m *manager) OnLink(c *server.Conn, req *fws.LinkRequest)         { m.comp.Apply(req) }
+func (m *manager) OnMove(c *server.Conn, req *fws.MoveRequest)         { m.comp.Apply(req) }
+func (m *manager) OnRender(c *server.Conn, req *fws.RenderRequest)     { m.comp.Render(m.screen) }
+func (m *manager) OnDelete(c *server.Conn, req *fws.DeleteRequest)     { m.comp.Apply(req) }
+
+func (m *manager) OnScreen(c *server.Conn, req *fws.ScreenRequest) fws.ReplyScreenRequest {
+	w, h := m.screen.Size()
+	return fws.ReplyScreenRequest{Width: int32(w), Height: int32(h), Mode: m.screen.Mode()}
+}
+
+// Sends screen events to windows until screen is closed
+func (m *manager) pollEvents() {
+	mouse := compositor.NewRouter(m.comp)
+	for ev := m.screen.PollEvent(); ev.Type != fws.EventError; ev = m.screen.PollEvent() {
+		var id fws.ID
+		if route, ok := mouse.Route(ev); ok {
+			id, ev = route.Id, route.Event
+		} else if stack := m.comp.Stack(); ev.Type == fws.EventKey && len(stack) > 0 {
+			id = stack[len(stack)-1]
+		}
+		m.mu.Lock()
+		c := m.conns[id]
+		m.mu.Unlock()
+		if c != nil {
+			c.SendEvent(id, ev)
+		}
+	}
+}
+
+func startServer(t *testing.T, screen *headless.Screen) string {
+	path := filepath.Join(t.TempDir(), "fws.sock")
+	l, err := server.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Caps = fws.CapMouse | fws.CapHyperlinks
+	w, h := screen.Size()
+	m := &manager{comp: compositor.New(w, h), screen: screen, conns: make(map[fws.ID]*server.Conn)}
+	go l.Serve(m)
+	go m.pollEvents()
+	t.Cleanup(func() {
+		l.Close()
+		screen.Close()
+	})
+	return path
+}
+
+func nextEvent(t *testing.T, w *client.Window) fws.Event {
+	t.Helper()
+	select {
+	case ev := <-w.Events():
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("No event received")
+	}
+	return fws.Event{}
+}
+
+func TestClientServer(t *testing.T) {
+	screen := headless.New(12, 4, fws.Output256)
+	path := startServer(t, screen)
+	conn, err := client.Dial(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reply, err := conn.Screen(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Width != 12 || reply.Height != 4 || reply.Mode != fws.Output256 {
+		t.Errorf("Screen reply: got %v\n", reply)
+	}
+
+	w, err := conn.NewWindow(client.WindowOptions{X: 2, Y: 1, Width: 8, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	white, blue := fws.Color{A: 255, R: 255, G: 255, B: 255}, fws.Color{A: 255, B: 255}
+	w.DrawText(0, 0, "hello", white, blue, fws.Bold)
+	w.Link(1, "https://example.com")
+	w.Draw(0, 1, fws.Cell{Ch: '>', Fg: white, Bg: blue, Link: 1})
+	// Opaque cell under mouse click below
+	w.Draw(1, 1, fws.Cell{Ch: ' ', Bg: blue})
+	w.Render()
+	if err := screen.WaitText(time.Second, 1, "  hello"); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+	cell := screen.Cell(2, 1)
+	if cell.Fg != white || cell.Bg != blue || cell.Attribute != fws.Bold {
+		t.Errorf("Rendered cell: got %v\n", cell)
+	}
+	if link := screen.Cell(2, 2).Link; link == 0 || screen.URI(link) != "https://example.com" {
+		t.Errorf("Rendered link %d: got URI %q\n", link, screen.URI(link))
+	}
+
+	w.Move(4, 2)
+	w.Render()
+	if err := screen.WaitText(time.Second, 2, "    hello"); err != nil {
+		t.Fatalf("%v, screen:\n%s", err, screen)
+	}
+	if got, expected := screen.String(), "            \n            \n    hello   \n    >       \n"; got != expected {
+		t.Errorf("Screen: expected %q, got %q\n", expected, got)
+	}
+
+	screen.InjectRune('q')
+	if ev := nextEvent(t, w); ev.Type != fws.EventKey || ev.Ch != 'q' {
+		t.Errorf("Key event: got %v\n", ev)
+	}
+	screen.InjectMouse(5, 3, fws.MouseLeft)
+	if ev := nextEvent(t, w); ev.Type != fws.EventMouse || ev.Key != fws.MouseLeft || ev.MouseX != 1 || ev.MouseY != 1 {
+		t.Errorf("Mouse event: expected local 1,1, got %v\n", ev)
+	}
+
+	w.Close()
+	conn.Close()
+	// Windows left by app are deleted, screen is repainted by next render
+	other, err := client.Dial(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	w, err = other.NewWindow(client.WindowOptions{Width: 1, Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Draw(0, 0, fws.Cell{Ch: '#', Fg: white, Bg: blue})
+	// Connections are served concurrently, so deletion may be applied
+	// after render of the other app
+	repainted := func(cells [][]fws.Cell) bool {
+		return cells[0][0].Ch == '#' && cells[4][2].Ch == ' '
+	}
+	for i := 0; ; i++ {
+		w.Render()
+		err := screen.WaitFor(50*time.Millisecond, repainted)
+		if err == nil {
+			break
+		}
+		if i == 20 {
+			t.Fatalf("Deleted window is still shown, screen:\n%s", screen)
+		}
+	}
+}
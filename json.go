@@ -0,0 +1,479 @@
+package fwsprotocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// JSON forms of messages.
+// Request is JSON object with message type name in Header field followed
+// by request fields, colors and attributes use their String forms:
+//
+//	{"Header":"DRAW","Id":3,"X":1,"Y":0,"Cell":{"Ch":"x","Fg":"#ffffff","Bg":"none","Attribute":"bold"}}
+
+func (h Header) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *Header) UnmarshalText(text []byte) error {
+	v, err := ParseHeader(string(text))
+	*h = v
+	return err
+}
+
+func (l LayerAttribute) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *LayerAttribute) UnmarshalText(text []byte) error {
+	for v, name := range layerNames {
+		if name == string(text) {
+			*l = LayerAttribute(v)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: layer %q", ErrBadText, text)
+}
+
+func (m OutputMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *OutputMode) UnmarshalText(text []byte) error {
+	for v, name := range modeNames {
+		if name == string(text) {
+			*m = OutputMode(v)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: output mode %q", ErrBadText, text)
+}
+
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *EventType) UnmarshalText(text []byte) error {
+	for v, name := range eventNames {
+		if name == string(text) {
+			*t = EventType(v)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: event type %q", ErrBadText, text)
+}
+
+// Cell JSON form, rune is string and unset fields are omitted
+type cellJSON struct {
+	Ch             string `json:",omitempty"`
+	Grapheme       string `json:",omitempty"`
+	Continuation   bool   `json:",omitempty"`
+	Fg, Bg         Color
+	UnderlineColor *Color `json:",omitempty"`
+	Attribute      Attr   `json:",omitempty"`
+	Link           uint32 `json:",omitempty"`
+}
+
+func (c Cell) MarshalJSON() ([]byte, error) {
+	j := cellJSON{
+		Grapheme:     c.Grapheme,
+		Continuation: c.Continuation,
+		Fg:           c.Fg,
+		Bg:           c.Bg,
+		Attribute:    c.Attribute,
+		Link:         c.Link,
+	}
+	if c.Ch != 0 {
+		j.Ch = string(c.Ch)
+	}
+	if c.UnderlineColor != (Color{}) {
+		j.UnderlineColor = &c.UnderlineColor
+	}
+	return json.Marshal(j)
+}
+
+func (c *Cell) UnmarshalJSON(data []byte) error {
+	var j cellJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	// JSON decoding replaces invalid UTF-8 with RuneError, it isn't drawable
+	ch, size := utf8.DecodeRuneInString(j.Ch)
+	if size != len(j.Ch) || j.Ch != "" && ch == utf8.RuneError {
+		return fmt.Errorf("%w: cell rune %s", ErrBadText, strconv.Quote(j.Ch))
+	}
+	*c = Cell{
+		Ch:           ch,
+		Grapheme:     j.Grapheme,
+		Continuation: j.Continuation,
+		Fg:           j.Fg,
+		Bg:           j.Bg,
+		Attribute:    j.Attribute,
+		Link:         j.Link,
+	}
+	if j.Ch == "" {
+		c.Ch = 0
+	}
+	if j.UnderlineColor != nil {
+		c.UnderlineColor = *j.UnderlineColor
+	}
+	// Same checks as wire decoding, so JSON and wire forms accept same cells
+	if c.Grapheme != "" && (!validGrapheme(c.Grapheme) || !c.graphemeMatches()) {
+		return fmt.Errorf("%w: grapheme %q of rune %q", ErrBadCell, c.Grapheme, c.Ch)
+	}
+	return nil
+}
+
+// Image must have declared dimensions, otherwise it can't be encoded
+func checkImage(width, height int, img [][]Cell) error {
+	if width < 0 || height < 0 || len(img) != width {
+		return fmt.Errorf("%w: %dx%d image with %d columns", ErrBadDimensions, width, height, len(img))
+	}
+	for i, column := range img {
+		if len(column) != height {
+			return fmt.Errorf("%w: %dx%d image with %d cells in column %d", ErrBadDimensions, width, height, len(column), i)
+		}
+	}
+	return nil
+}
+
+// JSON object of request fields v with Header field first
+func marshalRequest(h Header, v interface{}) ([]byte, error) {
+	fields, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	b := []byte(fmt.Sprintf(`{"Header":%q`, h.String()))
+	if len(fields) > 2 {
+		b = append(b, ',')
+	}
+	return append(b, fields[1:]...), nil
+}
+
+// Decodes request fields into v, Header field must match h if present
+func unmarshalRequest(data []byte, h Header, v interface{}) error {
+	var head struct{ Header *Header }
+	if err := json.Unmarshal(data, &head); err != nil {
+		return err
+	}
+	if head.Header != nil && *head.Header != h {
+		return fmt.Errorf("%w: %v message decoded as %v", ErrBadText, *head.Header, h)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Empty request of message type
+func newRequest(h Header) (Request, error) {
+	switch h {
+	case NEW:
+		return &NewWindowRequest{}, nil
+	case GET:
+		return &GetRequest{}, nil
+	case REPLY_CREATION:
+		return &ReplyCreationRequest{}, nil
+	case REPLY_GET:
+		return &ReplyGetRequest{}, nil
+	case EVENT:
+		return &EventRequest{}, nil
+	case DRAW:
+		return &DrawRequest{}, nil
+	case DRAW_FILL:
+		return &DrawFillRequest{}, nil
+	case RENDER:
+		return &RenderRequest{}, nil
+	case RESIZE:
+		return &ResizeRequest{}, nil
+	case DELETE:
+		return &DeleteRequest{}, nil
+	case MOVE:
+		return &MoveRequest{}, nil
+	case FOCUS:
+		return &FocusRequest{}, nil
+	case UNFOCUS:
+		return &UnfocusRequest{}, nil
+	case ACK:
+		return &AckRequest{}, nil
+	case REPEAT:
+		return &RepeatRequest{}, nil
+	case SCREEN:
+		return &ScreenRequest{}, nil
+	case REPLY_SCREEN:
+		return &ReplyScreenRequest{}, nil
+	case HELLO:
+		return &HelloRequest{}, nil
+	case REPLY_HELLO:
+		return &ReplyHelloRequest{}, nil
+	case DRAW_RECT:
+		return &DrawRectRequest{}, nil
+	case DRAW_PACKED:
+		return &PackedFillRequest{}, nil
+	case DRAW_TEXT:
+		return &DrawTextRequest{}, nil
+	case LINK:
+		return &LinkRequest{}, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnknownHeader, h)
+}
+
+// Decodes request from JSON object, its type is selected by Header field
+func UnmarshalRequest(data []byte) (Request, error) {
+	var head struct{ Header *Header }
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+	if head.Header == nil {
+		return nil, fmt.Errorf("%w: no message header", ErrBadText)
+	}
+	req, err := newRequest(*head.Header)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (o *NewWindowRequest) MarshalJSON() ([]byte, error) {
+	type fields NewWindowRequest
+	return marshalRequest(NEW, (*fields)(o))
+}
+
+func (o *NewWindowRequest) UnmarshalJSON(data []byte) error {
+	type fields NewWindowRequest
+	return unmarshalRequest(data, NEW, (*fields)(o))
+}
+
+func (o *GetRequest) MarshalJSON() ([]byte, error) {
+	type fields GetRequest
+	return marshalRequest(GET, (*fields)(o))
+}
+
+func (o *GetRequest) UnmarshalJSON(data []byte) error {
+	type fields GetRequest
+	return unmarshalRequest(data, GET, (*fields)(o))
+}
+
+func (o *ReplyCreationRequest) MarshalJSON() ([]byte, error) {
+	type fields ReplyCreationRequest
+	return marshalRequest(REPLY_CREATION, (*fields)(o))
+}
+
+func (o *ReplyCreationRequest) UnmarshalJSON(data []byte) error {
+	type fields ReplyCreationRequest
+	return unmarshalRequest(data, REPLY_CREATION, (*fields)(o))
+}
+
+func (o *ReplyGetRequest) MarshalJSON() ([]byte, error) {
+	type fields ReplyGetRequest
+	return marshalRequest(REPLY_GET, (*fields)(o))
+}
+
+func (o *ReplyGetRequest) UnmarshalJSON(data []byte) error {
+	type fields ReplyGetRequest
+	return unmarshalRequest(data, REPLY_GET, (*fields)(o))
+}
+
+func (o *EventRequest) MarshalJSON() ([]byte, error) {
+	type fields EventRequest
+	return marshalRequest(EVENT, (*fields)(o))
+}
+
+func (o *EventRequest) UnmarshalJSON(data []byte) error {
+	type fields EventRequest
+	return unmarshalRequest(data, EVENT, (*fields)(o))
+}
+
+func (o *DrawRequest) MarshalJSON() ([]byte, error) {
+	type fields DrawRequest
+	return marshalRequest(DRAW, (*fields)(o))
+}
+
+func (o *DrawRequest) UnmarshalJSON(data []byte) error {
+	type fields DrawRequest
+	return unmarshalRequest(data, DRAW, (*fields)(o))
+}
+
+func (o *DrawFillRequest) MarshalJSON() ([]byte, error) {
+	type fields DrawFillRequest
+	return marshalRequest(DRAW_FILL, (*fields)(o))
+}
+
+func (o *DrawFillRequest) UnmarshalJSON(data []byte) error {
+	type fields DrawFillRequest
+	if err := unmarshalRequest(data, DRAW_FILL, (*fields)(o)); err != nil {
+		return err
+	}
+	return checkImage(o.Width, o.Height, o.Img)
+}
+
+func (o *RenderRequest) MarshalJSON() ([]byte, error) {
+	type fields RenderRequest
+	return marshalRequest(RENDER, (*fields)(o))
+}
+
+func (o *RenderRequest) UnmarshalJSON(data []byte) error {
+	type fields RenderRequest
+	return unmarshalRequest(data, RENDER, (*fields)(o))
+}
+
+func (o *ResizeRequest) MarshalJSON() ([]byte, error) {
+	type fields ResizeRequest
+	return marshalRequest(RESIZE, (*fields)(o))
+}
+
+func (o *ResizeRequest) UnmarshalJSON(data []byte) error {
+	type fields ResizeRequest
+	return unmarshalRequest(data, RESIZE, (*fields)(o))
+}
+
+func (o *DeleteRequest) MarshalJSON() ([]byte, error) {
+	type fields DeleteRequest
+	return marshalRequest(DELETE, (*fields)(o))
+}
+
+func (o *DeleteRequest) UnmarshalJSON(data []byte) error {
+	type fields DeleteRequest
+	return unmarshalRequest(data, DELETE, (*fields)(o))
+}
+
+func (o *MoveRequest) MarshalJSON() ([]byte, error) {
+	type fields MoveRequest
+	return marshalRequest(MOVE, (*fields)(o))
+}
+
+func (o *MoveRequest) UnmarshalJSON(data []byte) error {
+	type fields MoveRequest
+	return unmarshalRequest(data, MOVE, (*fields)(o))
+}
+
+func (o *FocusRequest) MarshalJSON() ([]byte, error) {
+	type fields FocusRequest
+	return marshalRequest(FOCUS, (*fields)(o))
+}
+
+func (o *FocusRequest) UnmarshalJSON(data []byte) error {
+	type fields FocusRequest
+	return unmarshalRequest(data, FOCUS, (*fields)(o))
+}
+
+func (o *UnfocusRequest) MarshalJSON() ([]byte, error) {
+	type fields UnfocusRequest
+	return marshalRequest(UNFOCUS, (*fields)(o))
+}
+
+func (o *UnfocusRequest) UnmarshalJSON(data []byte) error {
+	type fields UnfocusRequest
+	return unmarshalRequest(data, UNFOCUS, (*fields)(o))
+}
+
+func (o *AckRequest) MarshalJSON() ([]byte, error) {
+	type fields AckRequest
+	return marshalRequest(ACK, (*fields)(o))
+}
+
+func (o *AckRequest) UnmarshalJSON(data []byte) error {
+	type fields AckRequest
+	return unmarshalRequest(data, ACK, (*fields)(o))
+}
+
+func (o *RepeatRequest) MarshalJSON() ([]byte, error) {
+	type fields RepeatRequest
+	return marshalRequest(REPEAT, (*fields)(o))
+}
+
+func (o *RepeatRequest) UnmarshalJSON(data []byte) error {
+	type fields RepeatRequest
+	return unmarshalRequest(data, REPEAT, (*fields)(o))
+}
+
+func (o *ScreenRequest) MarshalJSON() ([]byte, error) {
+	type fields ScreenRequest
+	return marshalRequest(SCREEN, (*fields)(o))
+}
+
+func (o *ScreenRequest) UnmarshalJSON(data []byte) error {
+	type fields ScreenRequest
+	return unmarshalRequest(data, SCREEN, (*fields)(o))
+}
+
+func (o *ReplyScreenRequest) MarshalJSON() ([]byte, error) {
+	type fields ReplyScreenRequest
+	return marshalRequest(REPLY_SCREEN, (*fields)(o))
+}
+
+func (o *ReplyScreenRequest) UnmarshalJSON(data []byte) error {
+	type fields ReplyScreenRequest
+	return unmarshalRequest(data, REPLY_SCREEN, (*fields)(o))
+}
+
+func (o *HelloRequest) MarshalJSON() ([]byte, error) {
+	type fields HelloRequest
+	return marshalRequest(HELLO, (*fields)(o))
+}
+
+func (o *HelloRequest) UnmarshalJSON(data []byte) error {
+	type fields HelloRequest
+	return unmarshalRequest(data, HELLO, (*fields)(o))
+}
+
+func (o *ReplyHelloRequest) MarshalJSON() ([]byte, error) {
+	type fields ReplyHelloRequest
+	return marshalRequest(REPLY_HELLO, (*fields)(o))
+}
+
+func (o *ReplyHelloRequest) UnmarshalJSON(data []byte) error {
+	type fields ReplyHelloRequest
+	return unmarshalRequest(data, REPLY_HELLO, (*fields)(o))
+}
+
+func (o *DrawRectRequest) MarshalJSON() ([]byte, error) {
+	type fields DrawRectRequest
+	return marshalRequest(DRAW_RECT, (*fields)(o))
+}
+
+func (o *DrawRectRequest) UnmarshalJSON(data []byte) error {
+	type fields DrawRectRequest
+	if err := unmarshalRequest(data, DRAW_RECT, (*fields)(o)); err != nil {
+		return err
+	}
+	return checkImage(o.Width, o.Height, o.Img)
+}
+
+func (o *PackedFillRequest) MarshalJSON() ([]byte, error) {
+	type fields PackedFillRequest
+	return marshalRequest(DRAW_PACKED, (*fields)(o))
+}
+
+func (o *PackedFillRequest) UnmarshalJSON(data []byte) error {
+	type fields PackedFillRequest
+	return unmarshalRequest(data, DRAW_PACKED, (*fields)(o))
+}
+
+func (o *DrawTextRequest) MarshalJSON() ([]byte, error) {
+	type fields DrawTextRequest
+	return marshalRequest(DRAW_TEXT, (*fields)(o))
+}
+
+func (o *DrawTextRequest) UnmarshalJSON(data []byte) error {
+	type fields DrawTextRequest
+	return unmarshalRequest(data, DRAW_TEXT, (*fields)(o))
+}
+
+func (o *LinkRequest) MarshalJSON() ([]byte, error) {
+	type fields LinkRequest
+	return marshalRequest(LINK, (*fields)(o))
+}
+
+func (o *LinkRequest) UnmarshalJSON(data []byte) error {
+	type fields LinkRequest
+	if err := unmarshalRequest(data, LINK, (*fields)(o)); err != nil {
+		return err
+	}
+	if !Printable(o.URI) {
+		return fmt.Errorf("%w: %q", ErrBadURI, o.URI)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package fwsprotocol
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, req := range sampleRequests() {
+		data, err := json.Marshal(req)
+		if err != nil {
+			t.Fatalf("Request %T: %v\n", req, err)
+		}
+		header := Header(req.Encode()[0])
+		if !strings.HasPrefix(string(data), `{"Header":"`+header.String()+`"`) {
+			t.Errorf("Request %T: expected header first, got %s\n", req, data)
+		}
+		got, err := UnmarshalRequest(data)
+		if err != nil {
+			t.Errorf("Request %s: %v\n", data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, req) {
+			t.Errorf("Request %s: expected %v, got %v\n", data, req, got)
+		}
+	}
+}
+
+func TestJSONForm(t *testing.T) {
+	req := &DrawRequest{Id: 3, X: 1, Cell: Cell{Ch: 'x', Fg: white, Attribute: Bold | Underline}}
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Header":"DRAW","Id":3,"X":1,"Y":0,"Cell":{"Ch":"x","Fg":"#ffffff","Bg":"none","Attribute":"bold|underline"}}`
+	if string(data) != expected {
+		t.Errorf("JSON: expected\n%s\ngot\n%s\n", expected, data)
+	}
+
+	// Hand written requests may use any key case and omit fields
+	got, err := UnmarshalRequest([]byte(`{"header":"new","width":10,"height":2,"layerattr":"BOTTOM"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nw, ok := got.(*NewWindowRequest); !ok || *nw != (NewWindowRequest{Width: 10, Height: 2, LayerAttr: BOTTOM}) {
+		t.Errorf("Hand written request: got %v\n", got)
+	}
+	got, err = UnmarshalRequest([]byte(`{"Header":"EVENT","Id":1,"Type":"key","Ch":113}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev, ok := got.(*EventRequest); !ok || ev.Type != EventKey || ev.Ch != 'q' {
+		t.Errorf("Event request: got %v\n", got)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected error
+	}{
+		{`{"Header":"PAINT"}`, ErrUnknownHeader},
+		{`{"Id":1}`, ErrBadText},
+		{`{"Header":"DRAW","Cell":{"Ch":"ab"}}`, ErrBadText},
+		{`{"Header":"DRAW","Cell":{"Fg":"white"}}`, ErrBadText},
+		{`{"Header":"NEW","LayerAttr":"MIDDLE"}`, ErrBadText},
+		{"{\"Header\":\"DRAW\",\"Cell\":{\"Ch\":\"\xff\"}}", ErrBadText},
+		{`{"Header":"DRAW","Cell":{"Ch":"\ud800"}}`, ErrBadText},
+		{`{"Header":"DRAW","Cell":{"Ch":"e","Grapheme":"e\u001b"}}`, ErrBadCell},
+		{`{"Header":"DRAW","Cell":{"Ch":"x","Grapheme":"e\u0301"}}`, ErrBadCell},
+		{`{"Header":"DRAW","Cell":{"Grapheme":"e\u0301"}}`, ErrBadCell},
+		{`{"Header":"LINK","Id":1,"Link":1,"URI":"https://example.com/\u001b]8;;"}`, ErrBadURI},
+		{`{"Header":"DRAW_FILL","Width":2,"Height":1,"Img":[[{}]]}`, ErrBadDimensions},
+		{`{"Header":"DRAW_FILL","Width":1,"Height":2,"Img":[[{}]]}`, ErrBadDimensions},
+		{`{"Header":"DRAW_FILL","Width":-1,"Height":1}`, ErrBadDimensions},
+		{`{"Header":"DRAW_RECT","Width":1,"Height":1,"Img":[[{}],[{}]]}`, ErrBadDimensions},
+	}
+	for _, test := range tests {
+		if _, err := UnmarshalRequest([]byte(test.json)); !errors.Is(err, test.expected) {
+			t.Errorf("JSON %s: expected %v, got %v\n", test.json, test.expected, err)
+		}
+	}
+	var draw DrawRequest
+	if err := json.Unmarshal([]byte(`{"Header":"RENDER","Id":1}`), &draw); !errors.Is(err, ErrBadText) {
+		t.Errorf("Header mismatch: expected ErrBadText, got %v\n", err)
+	}
+	if err := json.Unmarshal([]byte(`{"Id":1}`), &draw); err != nil || draw.Id != 1 {
+		t.Errorf("Request without header: got %v, %v\n", draw, err)
+	}
+}
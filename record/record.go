@@ -0,0 +1,239 @@
+// FWS traffic recording
+// Records frames of connections with timestamps and replays them into
+// window server or compositor, so rendering bugs can be reproduced
+//
+// Recording starts with "FWSREC" magic and format version, followed by
+// entries of 8 byte time offset in nanoseconds, 4 byte connection number,
+// 1 byte direction and the frame as written on the wire
+package record
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+const (
+	magic   = "FWSREC"
+	version = 1
+)
+
+const (
+	entryHeaderSize = 13 // Time offset, connection number and direction
+	frameHeaderSize = 12 // Message length, sequence number and reply reference
+)
+
+var (
+	ErrBadMagic   = errors.New("record: not a recording")
+	ErrBadVersion = errors.New("record: unsupported recording version")
+)
+
+// Frame direction
+type Direction uint8
+
+const (
+	ToServer Direction = iota // Frame sent by app
+	ToApp                     // Frame sent by server
+)
+
+func (d Direction) String() string {
+	switch d {
+	case ToServer:
+		return "app->server"
+	case ToApp:
+		return "server->app"
+	}
+	return fmt.Sprintf("Direction(%d)", uint8(d))
+}
+
+// Recorded frame
+type Entry struct {
+	Time  time.Duration // Since recording start
+	Conn  uint32        // Connection number, starting with 1
+	Dir   Direction
+	Frame fws.Frame
+}
+
+// Recording writer, safe for concurrent use.
+// Write errors stop recording, connections keep working
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	enc   *fws.Encoder
+	start time.Time
+	conns uint32
+	err   error
+}
+
+// Writes recording header to w and starts the clock
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	if _, err := w.Write(append([]uint8(magic), version)); err != nil {
+		return nil, err
+	}
+	return &Recorder{w: w, enc: fws.NewEncoder(w), start: time.Now()}, nil
+}
+
+// First write error
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Records frame of connection
+func (r *Recorder) Record(conn uint32, dir Direction, f fws.Frame) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	var header [entryHeaderSize]uint8
+	binary.LittleEndian.PutUint64(header[0:8], uint64(time.Since(r.start)))
+	binary.LittleEndian.PutUint32(header[8:12], conn)
+	header[12] = uint8(dir)
+	if _, err := r.w.Write(header[:]); err != nil {
+		r.err = err
+		return err
+	}
+	if err := r.enc.WriteFrame(f); err != nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// Wraps connection so frames passing it are recorded under new connection
+// number. read is direction of frames read from conn, ToServer for
+// connections accepted by server
+func (r *Recorder) Conn(conn net.Conn, read Direction) net.Conn {
+	r.mu.Lock()
+	r.conns++
+	n := r.conns
+	r.mu.Unlock()
+	return &recordedConn{
+		Conn:  conn,
+		read:  splitter{r: r, conn: n, dir: read},
+		write: splitter{r: r, conn: n, dir: read ^ 1},
+	}
+}
+
+// Reassembles frames from stream chunks
+type splitter struct {
+	r      *Recorder
+	conn   uint32
+	dir    Direction
+	buf    []uint8
+	broken bool // Oversized frame, stream is not recorded any more
+}
+
+func (s *splitter) feed(b []uint8) {
+	if s.broken {
+		return
+	}
+	s.buf = append(s.buf, b...)
+	for len(s.buf) >= frameHeaderSize {
+		size := binary.LittleEndian.Uint32(s.buf[0:4])
+		if uint64(size) > fws.DefaultMaxFrameSize {
+			s.broken, s.buf = true, nil
+			return
+		}
+		end := frameHeaderSize + uint64(size)
+		if uint64(len(s.buf)) < end {
+			return
+		}
+		f := fws.Frame{
+			Seq: binary.LittleEndian.Uint32(s.buf[4:8]),
+			Ref: binary.LittleEndian.Uint32(s.buf[8:12]),
+			Msg: append(fws.Msg(nil), s.buf[frameHeaderSize:end]...),
+		}
+		s.buf = s.buf[end:]
+		s.r.Record(s.conn, s.dir, f)
+	}
+}
+
+// Connection recording frames it reads and writes
+type recordedConn struct {
+	net.Conn
+	rmu   sync.Mutex
+	read  splitter
+	wmu   sync.Mutex
+	write splitter
+}
+
+func (c *recordedConn) Read(b []uint8) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.rmu.Lock()
+	c.read.feed(b[:n])
+	c.rmu.Unlock()
+	return n, err
+}
+
+func (c *recordedConn) Write(b []uint8) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.wmu.Lock()
+	c.write.feed(b[:n])
+	c.wmu.Unlock()
+	return n, err
+}
+
+// Recording reader
+type Reader struct {
+	r   io.Reader
+	dec *fws.Decoder
+}
+
+// Checks recording header
+func NewReader(r io.Reader) (*Reader, error) {
+	header := make([]uint8, len(magic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadMagic, err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, ErrBadMagic
+	}
+	if header[len(magic)] != version {
+		return nil, fmt.Errorf("%w: %d", ErrBadVersion, header[len(magic)])
+	}
+	return &Reader{r: r, dec: fws.NewDecoder(r)}, nil
+}
+
+// Reads next entry, io.EOF at the end of recording
+func (r *Reader) Next() (Entry, error) {
+	var header [entryHeaderSize]uint8
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return Entry{}, err
+	}
+	f, err := r.dec.ReadFrame()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Entry{}, err
+	}
+	return Entry{
+		Time:  time.Duration(binary.LittleEndian.Uint64(header[0:8])),
+		Conn:  binary.LittleEndian.Uint32(header[8:12]),
+		Dir:   Direction(header[12]),
+		Frame: f,
+	}, nil
+}
+
+// Reads remaining entries
+func (r *Reader) ReadAll() ([]Entry, error) {
+	var entries []Entry
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+}
@@ -0,0 +1,138 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+func TestRecordConn(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, srv := net.Pipe()
+	conn := rec.Conn(srv, ToServer)
+	draw := &fws.DrawRequest{Id: 3, X: 1, Y: 2, Cell: fws.Cell{Ch: 'x'}}
+	go func() {
+		// Frame split across writes is recorded once it is complete
+		var frame bytes.Buffer
+		fws.NewEncoder(&frame).Encode(draw)
+		b := frame.Bytes()
+		app.Write(b[:5])
+		app.Write(b[5:])
+		fws.NewEncoder(app).Encode(&fws.RenderRequest{Id: 3})
+	}()
+	dec := fws.NewDecoder(conn)
+	for i := 0; i < 2; i++ {
+		if _, err := dec.ReadFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go io.Copy(io.Discard, app)
+	if _, err := fws.NewEncoder(conn).Send(&fws.ReplyCreationRequest{Id: 3}, 2); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		dir Direction
+		seq uint32
+		ref uint32
+		msg fws.Msg
+	}{
+		{ToServer, 1, 0, draw.Encode()},
+		{ToServer, 1, 0, (&fws.RenderRequest{Id: 3}).Encode()},
+		{ToApp, 1, 2, (&fws.ReplyCreationRequest{Id: 3}).Encode()},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Recording: expected %d entries, got %d\n", len(expected), len(entries))
+	}
+	for i, e := range entries {
+		exp := expected[i]
+		if e.Conn != 1 || e.Dir != exp.dir || e.Frame.Seq != exp.seq || e.Frame.Ref != exp.ref || !bytes.Equal(e.Frame.Msg, exp.msg) {
+			t.Errorf("Entry %d: expected %v %d/%d %v, got %+v\n", i, exp.dir, exp.seq, exp.ref, exp.msg, e)
+		}
+		if i > 0 && e.Time < entries[i-1].Time {
+			t.Errorf("Entry %d: time %v is before previous entry\n", i, e.Time)
+		}
+	}
+	if c := rec.Conn(srv, ToApp).(*recordedConn); c.read.conn != 2 || c.read.dir != ToApp || c.write.dir != ToServer {
+		t.Errorf("Second connection: got number %d, directions %v and %v\n", c.read.conn, c.read.dir, c.write.dir)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]uint8("FWS"))); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("Short header: expected %v, got %v\n", ErrBadMagic, err)
+	}
+	if _, err := NewReader(bytes.NewReader([]uint8("RECFWS\x01"))); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("Bad magic: expected %v, got %v\n", ErrBadMagic, err)
+	}
+	if _, err := NewReader(bytes.NewReader([]uint8("FWSREC\x09"))); !errors.Is(err, ErrBadVersion) {
+		t.Errorf("Bad version: expected %v, got %v\n", ErrBadVersion, err)
+	}
+
+	var buf bytes.Buffer
+	rec, _ := NewRecorder(&buf)
+	rec.Record(1, ToServer, fws.Frame{Seq: 1, Msg: (&fws.RenderRequest{Id: 1}).Encode()})
+	b := buf.Bytes()
+	r, _ := NewReader(bytes.NewReader(b[:len(b)-2]))
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Truncated frame: expected %v, got %v\n", io.ErrUnexpectedEOF, err)
+	}
+	r, _ = NewReader(bytes.NewReader(b[:len(magic)+1+entryHeaderSize]))
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Missing frame: expected %v, got %v\n", io.ErrUnexpectedEOF, err)
+	}
+	r, _ = NewReader(bytes.NewReader(b))
+	if _, err := r.Next(); err != nil {
+		t.Errorf("Complete entry: got %v\n", err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("End of recording: expected %v, got %v\n", io.EOF, err)
+	}
+}
+
+// Writer failing after n bytes
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(b []uint8) (int, error) {
+	if len(b) > w.n {
+		return 0, io.ErrShortWrite
+	}
+	w.n -= len(b)
+	return len(b), nil
+}
+
+func TestRecorderWriteError(t *testing.T) {
+	rec, err := NewRecorder(&failingWriter{n: len(magic) + 1 + entryHeaderSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := fws.Frame{Seq: 1, Msg: (&fws.RenderRequest{Id: 1}).Encode()}
+	if err := rec.Record(1, ToServer, f); err != io.ErrShortWrite {
+		t.Errorf("Failed write: expected %v, got %v\n", io.ErrShortWrite, err)
+	}
+	if err := rec.Record(1, ToServer, fws.Frame{}); err != io.ErrShortWrite || rec.Err() != io.ErrShortWrite {
+		t.Errorf("Recording after failure: expected %v, got %v\n", io.ErrShortWrite, err)
+	}
+}
@@ -0,0 +1,251 @@
+package record
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/Nekhaevalex/fwsprotocol/compositor"
+)
+
+var ErrNoReply = errors.New("record: server did not create window")
+
+// Time given to server to create window during replay
+const ReplyTimeout = 5 * time.Second
+
+// Messages addressing window, ID follows header
+var windowHeaders = map[fws.Header]bool{
+	fws.GET:         true,
+	fws.DRAW:        true,
+	fws.DRAW_FILL:   true,
+	fws.RENDER:      true,
+	fws.RESIZE:      true,
+	fws.DELETE:      true,
+	fws.MOVE:        true,
+	fws.FOCUS:       true,
+	fws.UNFOCUS:     true,
+	fws.DRAW_RECT:   true,
+	fws.DRAW_PACKED: true,
+	fws.DRAW_TEXT:   true,
+	fws.LINK:        true,
+}
+
+// Replay clock. Speed scales recorded delays: 1 keeps original timing,
+// 2 halves delays, 0 or less plays without delays
+type clock struct {
+	start time.Time
+	speed float64
+}
+
+// Waits until time of entry
+func (c clock) wait(ctx context.Context, e Entry) error {
+	if c.speed <= 0 {
+		return ctx.Err()
+	}
+	d := time.Duration(float64(e.Time)/c.speed) - time.Since(c.start)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Replayed app connection
+type replayConn struct {
+	conn    net.Conn
+	enc     *fws.Encoder
+	created chan *fws.ReplyCreationRequest // Window creation replies, closed when connection ends
+	ids     map[uint32]fws.ID              // Server assigned IDs by sequence number of NEW
+}
+
+func dialReplay(ctx context.Context, path string) (*replayConn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &replayConn{
+		conn:    conn,
+		enc:     fws.NewEncoder(conn),
+		created: make(chan *fws.ReplyCreationRequest, 1),
+		ids:     make(map[uint32]fws.ID),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Passes window creation replies, everything else sent by server is dropped
+func (c *replayConn) readLoop() {
+	defer close(c.created)
+	dec := fws.NewDecoder(c.conn)
+	for {
+		f, err := dec.ReadFrame()
+		if err != nil {
+			return
+		}
+		if len(f.Msg) == 0 || fws.Header(f.Msg[0]) != fws.REPLY_CREATION {
+			continue
+		}
+		req, err := fws.DecodeMsg(f.Msg)
+		if err != nil {
+			continue
+		}
+		// Only one window is created at a time, unexpected replies are dropped
+		select {
+		case c.created <- req.(*fws.ReplyCreationRequest):
+		default:
+		}
+	}
+}
+
+// Sends NEW frame and waits for window ID assigned by server
+func (c *replayConn) create(ctx context.Context, f fws.Frame) error {
+	if err := c.enc.WriteFrame(f); err != nil {
+		return err
+	}
+	timer := time.NewTimer(ReplyTimeout)
+	defer timer.Stop()
+	select {
+	case reply, ok := <-c.created:
+		if !ok {
+			return fmt.Errorf("%w: connection closed", ErrNoReply)
+		}
+		c.ids[f.Seq] = reply.Id
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("%w: timeout", ErrNoReply)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Replays app frames of recording into window server listening on path.
+// Every recorded connection is dialed before its first frame and closed
+// at the end of replay. Window IDs assigned by server replace recorded
+// ones, frames sent by server are dropped
+func Replay(ctx context.Context, r *Reader, path string, speed float64) error {
+	conns := make(map[uint32]*replayConn)
+	defer func() {
+		for _, c := range conns {
+			c.conn.Close()
+		}
+	}()
+	ids := make(map[fws.ID]fws.ID) // Server assigned IDs by recorded ones
+	clock := clock{time.Now(), speed}
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		msg := e.Frame.Msg
+		if len(msg) == 0 {
+			continue
+		}
+		header := fws.Header(msg[0])
+		c := conns[e.Conn]
+		if e.Dir == ToApp {
+			// Recorded reply tells which ID app got for its window
+			if c != nil && header == fws.REPLY_CREATION {
+				if req, err := fws.DecodeMsg(msg); err == nil {
+					if id, ok := c.ids[e.Frame.Ref]; ok {
+						ids[req.(*fws.ReplyCreationRequest).Id] = id
+					}
+				}
+			}
+			continue
+		}
+		if err := clock.wait(ctx, e); err != nil {
+			return err
+		}
+		if c == nil {
+			if c, err = dialReplay(ctx, path); err != nil {
+				return err
+			}
+			conns[e.Conn] = c
+		}
+		f := e.Frame
+		if header == fws.NEW {
+			if err := c.create(ctx, f); err != nil {
+				return err
+			}
+			continue
+		}
+		if windowHeaders[header] && len(msg) >= 5 {
+			if id, ok := ids[fws.ID(binary.LittleEndian.Uint32(msg[1:5]))]; ok {
+				f.Msg = append(fws.Msg(nil), msg...)
+				binary.LittleEndian.PutUint32(f.Msg[1:5], uint32(id))
+			}
+		}
+		if err := c.enc.WriteFrame(f); err != nil {
+			return err
+		}
+	}
+}
+
+// Plays recording into compositor without server, rendering to b on
+// every RENDER. Windows get IDs from recorded server replies
+func Play(ctx context.Context, r *Reader, c *compositor.Compositor, b compositor.Backend, speed float64) error {
+	news := make(map[uint32]map[uint32]*fws.NewWindowRequest) // Pending windows by connection and sequence number
+	unpack := make(map[uint32]*fws.Unpacker)
+	clock := clock{time.Now(), speed}
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Broken messages are skipped like server does
+		req, err := fws.DecodeMsg(e.Frame.Msg)
+		if err != nil {
+			continue
+		}
+		if e.Dir == ToApp {
+			if reply, ok := req.(*fws.ReplyCreationRequest); ok {
+				if nw := news[e.Conn][e.Frame.Ref]; nw != nil {
+					delete(news[e.Conn], e.Frame.Ref)
+					c.Add(reply.Id, nw)
+				}
+			}
+			continue
+		}
+		if err := clock.wait(ctx, e); err != nil {
+			return err
+		}
+		switch req := req.(type) {
+		case *fws.NewWindowRequest:
+			if news[e.Conn] == nil {
+				news[e.Conn] = make(map[uint32]*fws.NewWindowRequest)
+			}
+			news[e.Conn][e.Frame.Seq] = req
+		case *fws.PackedFillRequest:
+			if unpack[e.Conn] == nil {
+				unpack[e.Conn] = fws.NewUnpacker()
+			}
+			if fill, err := unpack[e.Conn].Unpack(req); err == nil {
+				c.Apply(fill)
+			}
+		case *fws.RenderRequest:
+			if err := c.Render(b); err != nil {
+				return err
+			}
+		default:
+			c.Apply(req)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/Nekhaevalex/fwsprotocol/client"
+	"github.com/Nekhaevalex/fwsprotocol/compositor"
+	"github.com/Nekhaevalex/fwsprotocol/headless"
+	"github.com/Nekhaevalex/fwsprotocol/server"
+)
+
+// Handler assigning window IDs from next and recording addressed windows
+type handler struct {
+	server.BaseHandler
+	mu    sync.Mutex
+	next  fws.ID
+	calls []string
+	ids   []fws.ID
+}
+
+func (h *handler) record(call string, id fws.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, call)
+	h.ids = append(h.ids, id)
+}
+
+func (h *handler) OnNewWindow(c *server.Conn, req *fws.NewWindowRequest) (fws.ID, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.next++
+	return h.next, nil
+}
+
+func (h *handler) OnDrawRect(c *server.Conn, req *fws.DrawRectRequest) { h.record("rect", req.Id) }
+func (h *handler) OnDrawFill(c *server.Conn, req *fws.DrawFillRequest) { h.record("fill", req.Id) }
+func (h *handler) OnRender(c *server.Conn, req *fws.RenderRequest)     { h.record("render", req.Id) }
+
+// Waits until handler gets n calls
+func (h *handler) wait(t *testing.T, n int) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		h.mu.Lock()
+		done := len(h.calls) >= n
+		h.mu.Unlock()
+		if done {
+			return
+		}
+	}
+	t.Fatalf("Handler did not get %d calls\n", n)
+}
+
+func listen(t *testing.T, h *handler, wrap func(net.Conn) net.Conn) (*server.Listener, string) {
+	path := filepath.Join(t.TempDir(), "fws.sock")
+	l, err := server.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Caps = fws.CapCompression
+	l.Wrap = wrap
+	go l.Serve(h)
+	t.Cleanup(func() { l.Close() })
+	return l, path
+}
+
+var (
+	white = fws.Color{A: 255, R: 255, G: 255, B: 255}
+	blue  = fws.Color{A: 255, B: 255}
+)
+
+// Records session of app drawing text and packed image in one window
+func recordSession(t *testing.T) []uint8 {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{next: 6}
+	l, path := listen(t, h, func(conn net.Conn) net.Conn {
+		return rec.Conn(conn, ToServer)
+	})
+	conn, err := client.Dial(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := conn.NewWindow(client.WindowOptions{X: 1, Y: 0, Width: 5, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.DrawText(0, 0, "hello", white, blue, 0)
+	w.Render()
+	img := [][]fws.Cell{{{Ch: 'a'}, {Ch: 'b'}}}
+	for x := 1; x < 5; x++ {
+		img = append(img, []fws.Cell{{Ch: 'c'}, {Ch: 'd'}})
+	}
+	w.DrawFill(img)
+	w.Render()
+	h.wait(t, 4)
+	conn.Close()
+	l.Close()
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPlay(t *testing.T) {
+	recording := recordSession(t)
+	r, err := NewReader(bytes.NewReader(recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	screen := headless.New(7, 2, fws.OutputRGB)
+	if err := Play(context.Background(), r, compositor.New(7, 2), screen, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := screen.String(), " acccc \n bdddd \n"; got != expected {
+		t.Errorf("Played screen: expected %q, got %q\n", expected, got)
+	}
+	if screen.Flushes() != 2 {
+		t.Errorf("Played renders: expected 2, got %d\n", screen.Flushes())
+	}
+}
+
+func TestReplay(t *testing.T) {
+	recording := recordSession(t)
+	r, err := NewReader(bytes.NewReader(recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{next: 40}
+	l, path := listen(t, h, nil)
+	if err := Replay(context.Background(), r, path, 0); err != nil {
+		t.Fatal(err)
+	}
+	h.wait(t, 4)
+	l.Close()
+	expected := []string{"rect", "render", "fill", "render"}
+	if len(h.calls) != len(expected) {
+		t.Fatalf("Replayed calls: expected %v, got %v\n", expected, h.calls)
+	}
+	for i := range expected {
+		if h.calls[i] != expected[i] || h.ids[i] != 41 {
+			t.Errorf("Call %d: expected %s of window 41, got %s of window %d\n", i, expected[i], h.calls[i], h.ids[i])
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	e := Entry{Time: time.Second}
+	if err := (clock{time.Now(), 0}).wait(context.Background(), e); err != nil {
+		t.Errorf("Wait without delays: got %v\n", err)
+	}
+	start := time.Now()
+	if err := (clock{start, 100}).wait(context.Background(), e); err != nil {
+		t.Errorf("Accelerated wait: got %v\n", err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond || d > time.Second/2 {
+		t.Errorf("Accelerated wait: expected 10ms, waited %v\n", d)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := (clock{time.Now(), 1}).wait(ctx, e); err != context.Canceled {
+		t.Errorf("Canceled wait: expected %v, got %v\n", context.Canceled, err)
+	}
+}
@@ -0,0 +1,306 @@
+package fwsprotocol
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default number of unacknowledged frames kept for retransmission
+const DefaultRetransmitWindow = 64
+
+// Default time without acknowledgement after which frames are resent
+const DefaultRetransmitTimeout = 100 * time.Millisecond
+
+var ErrConnClosed = errors.New("fwsprotocol: connection closed")
+
+// Reliable message transport over datagram socket (SOCK_DGRAM or
+// SOCK_SEQPACKET), where every datagram carries exactly one frame.
+//
+// Sender keeps every data frame in a bounded retransmit buffer keyed by
+// sequence number. ACK trims the buffer up to acknowledged sequence number,
+// REPEAT resends every buffered frame starting with requested one. Frames
+// left unacknowledged for retransmit timeout are resent as well, so lost
+// acknowledgements and lost trailing frames are recovered.
+//
+// Receiver delivers frames in order exactly once, acknowledges every
+// in-order frame and asks for repeat when it notices a gap. Frames after
+// a gap are dropped and recovered by the repeat (go-back-N). At most window
+// frames wait for ReadFrame, further ones are dropped unacknowledged.
+// ACK and REPEAT frames themselves are not numbered (sequence number 0)
+type ReliableConn struct {
+	// Messages longer than this are rejected by Send with ErrFrameTooLarge.
+	// Datagram sockets usually limit it well below default
+	MaxFrameSize int
+
+	conn    net.Conn
+	window  int
+	timeout time.Duration
+	send    sync.Mutex // Keeps data frames written in sequence order
+
+	mu       sync.Mutex
+	cond     *sync.Cond // Signalled on acknowledgement, delivery and close
+	seq      uint32     // Last assigned sequence number
+	unacked  []Frame    // Sent frames waiting for acknowledgement, ascending
+	progress time.Time  // Last time buffer was trimmed or resent
+	expected uint32     // Next sequence number expected from peer
+	repeated time.Time  // Last time REPEAT was sent
+	received []Frame    // In-order frames not read yet
+	err      error
+	closed   chan struct{}
+}
+
+// Wraps connected datagram socket.
+// Non-positive window and timeout select defaults
+func NewReliableConn(conn net.Conn, window int, timeout time.Duration) *ReliableConn {
+	if window <= 0 {
+		window = DefaultRetransmitWindow
+	}
+	if timeout <= 0 {
+		timeout = DefaultRetransmitTimeout
+	}
+	c := &ReliableConn{
+		MaxFrameSize: DefaultMaxFrameSize,
+		conn:         conn,
+		window:       window,
+		timeout:      timeout,
+		expected:     1,
+		closed:       make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	go c.readLoop()
+	go c.retransmitLoop()
+	return c
+}
+
+func nextSeq(seq uint32) uint32 {
+	seq++
+	if seq == 0 {
+		seq = 1
+	}
+	return seq
+}
+
+// Serial number comparison, tolerates wrap around
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// Sends request with next sequence number, ref is 0 for non-reply messages.
+// Blocks while retransmit buffer is full.
+// Frame that can't be written would be retransmitted forever and leave
+// a gap peer can't skip, so write error fails the connection
+func (c *ReliableConn) Send(req Request, ref uint32) (uint32, error) {
+	msg := req.Encode()
+	if len(msg) > c.MaxFrameSize {
+		return 0, fmt.Errorf("%w: %d > %d bytes", ErrFrameTooLarge, len(msg), c.MaxFrameSize)
+	}
+	c.send.Lock()
+	defer c.send.Unlock()
+	c.mu.Lock()
+	for len(c.unacked) >= c.window && c.err == nil {
+		c.cond.Wait()
+	}
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return 0, err
+	}
+	c.seq = nextSeq(c.seq)
+	f := Frame{Seq: c.seq, Ref: ref, Msg: msg}
+	if len(c.unacked) == 0 {
+		c.progress = time.Now()
+	}
+	c.unacked = append(c.unacked, f)
+	c.mu.Unlock()
+	if err := c.write(f); err != nil {
+		c.fail(err)
+		return f.Seq, err
+	}
+	return f.Seq, nil
+}
+
+// Returns next frame in sequence order
+func (c *ReliableConn) ReadFrame() (Frame, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.received) == 0 && c.err == nil {
+		c.cond.Wait()
+	}
+	if len(c.received) == 0 {
+		return Frame{}, c.err
+	}
+	f := c.received[0]
+	c.received = c.received[1:]
+	return f, nil
+}
+
+// Reads and decodes next message
+func (c *ReliableConn) Decode() (Request, error) {
+	f, err := c.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	return DecodeMsg(f.Msg)
+}
+
+// Number of frames waiting for acknowledgement
+func (c *ReliableConn) Unacked() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.unacked)
+}
+
+// Closes socket, frames still waiting for acknowledgement are dropped
+func (c *ReliableConn) Close() error {
+	c.fail(ErrConnClosed)
+	return c.conn.Close()
+}
+
+func (c *ReliableConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.unacked = nil
+	close(c.closed)
+	c.cond.Broadcast()
+}
+
+func (c *ReliableConn) write(f Frame) error {
+	_, err := c.conn.Write(appendFrame(nil, f))
+	return err
+}
+
+func (c *ReliableConn) writeControl(req Request) {
+	c.write(Frame{Msg: req.Encode()})
+}
+
+func (c *ReliableConn) readLoop() {
+	buf := make([]uint8, frameHeaderSize+DefaultMaxFrameSize)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		f, err := parseFrame(buf[:n])
+		if err != nil {
+			// Damaged datagram is handled as a lost one
+			continue
+		}
+		if f.Seq == 0 {
+			c.control(f.Msg)
+		} else {
+			c.receive(f)
+		}
+	}
+}
+
+func (c *ReliableConn) control(msg Msg) {
+	req, err := DecodeMsg(msg)
+	if err != nil {
+		return
+	}
+	switch req := req.(type) {
+	case *AckRequest:
+		c.acknowledge(req.Seq)
+	case *RepeatRequest:
+		c.acknowledge(req.Seq - 1)
+		c.retransmit(req.Seq)
+	}
+}
+
+// Trims retransmit buffer up to and including seq
+func (c *ReliableConn) acknowledge(seq uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for n < len(c.unacked) && !seqBefore(seq, c.unacked[n].Seq) {
+		n++
+	}
+	if n > 0 {
+		c.unacked = c.unacked[n:]
+		c.progress = time.Now()
+		c.cond.Broadcast()
+	}
+}
+
+// Resends buffered frames starting with seq
+func (c *ReliableConn) retransmit(seq uint32) {
+	c.mu.Lock()
+	var frames []Frame
+	for _, f := range c.unacked {
+		if !seqBefore(f.Seq, seq) {
+			frames = append(frames, f)
+		}
+	}
+	c.progress = time.Now()
+	c.mu.Unlock()
+	for _, f := range frames {
+		if c.write(f) != nil {
+			return
+		}
+	}
+}
+
+func (c *ReliableConn) receive(f Frame) {
+	c.mu.Lock()
+	switch {
+	case f.Seq == c.expected && len(c.received) >= c.window:
+		// App doesn't read, frame is left unacknowledged and peer resends
+		// it after timeout, so unread frames don't grow without bound
+		c.mu.Unlock()
+		return
+	case f.Seq == c.expected:
+		c.received = append(c.received, f)
+		c.expected = nextSeq(c.expected)
+		c.cond.Broadcast()
+	case seqBefore(f.Seq, c.expected):
+		// Duplicate, acknowledgement was probably lost
+	default:
+		// Gap, one REPEAT per timeout is enough for go-back-N
+		if time.Since(c.repeated) < c.timeout {
+			c.mu.Unlock()
+			return
+		}
+		c.repeated = time.Now()
+		expected := c.expected
+		c.mu.Unlock()
+		c.writeControl(&RepeatRequest{Seq: expected})
+		return
+	}
+	last := c.expected - 1
+	if last == 0 {
+		last--
+	}
+	c.mu.Unlock()
+	c.writeControl(&AckRequest{Seq: last})
+}
+
+// Resends whole buffer when nothing was acknowledged for timeout
+func (c *ReliableConn) retransmitLoop() {
+	ticker := time.NewTicker(c.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		stalled := len(c.unacked) > 0 && time.Since(c.progress) >= c.timeout
+		var first uint32
+		if stalled {
+			first = c.unacked[0].Seq
+		}
+		c.mu.Unlock()
+		if stalled {
+			c.retransmit(first)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+//go:build unix
+
+package fwsprotocol
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Datagram socket pair of specified type
+func datagramPair(t *testing.T, typ int) (net.Conn, net.Conn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, typ, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make([]net.Conn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "fws")
+		conns[i], err = net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return conns[0], conns[1]
+}
+
+// Datagram connection silently dropping part of written datagrams
+type lossyConn struct {
+	net.Conn
+	mu      sync.Mutex
+	rand    *rand.Rand
+	rate    float64
+	dropped int
+}
+
+func (c *lossyConn) Write(b []uint8) (int, error) {
+	c.mu.Lock()
+	drop := c.rand.Float64() < c.rate
+	if drop {
+		c.dropped++
+	}
+	c.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *lossyConn) lost() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+func lossyPair(t *testing.T, typ int, rate float64) (*lossyConn, *lossyConn) {
+	a, b := datagramPair(t, typ)
+	return &lossyConn{Conn: a, rand: rand.New(rand.NewSource(1)), rate: rate},
+		&lossyConn{Conn: b, rand: rand.New(rand.NewSource(2)), rate: rate}
+}
+
+func testReliableDelivery(t *testing.T, typ int) {
+	a, b := lossyPair(t, typ, 0.2)
+	sender := NewReliableConn(a, 16, 10*time.Millisecond)
+	receiver := NewReliableConn(b, 16, 10*time.Millisecond)
+	defer sender.Close()
+	defer receiver.Close()
+
+	const count = 500
+	sendErr := make(chan error, 1)
+	go func() {
+		for i := 0; i < count; i++ {
+			if _, err := sender.Send(&DrawRequest{Id: 1, X: i, Cell: Cell{Ch: 'r'}}, 0); err != nil {
+				sendErr <- err
+				return
+			}
+		}
+		sendErr <- nil
+	}()
+
+	for i := 0; i < count; i++ {
+		f, err := receiver.ReadFrame()
+		if err != nil {
+			t.Fatalf("Frame %d: unexpected error: %v\n", i, err)
+		}
+		if f.Seq != uint32(i+1) {
+			t.Fatalf("Frame %d: expected seq %d, got %d\n", i, i+1, f.Seq)
+		}
+		req, err := DecodeMsg(f.Msg)
+		if err != nil {
+			t.Fatalf("Frame %d: unexpected decoding error: %v\n", i, err)
+		}
+		if draw, ok := req.(*DrawRequest); !ok || draw.X != i {
+			t.Fatalf("Frame %d: expected draw at %d, got %v\n", i, i, req)
+		}
+	}
+	if err := <-sendErr; err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sender.Unacked() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Retransmit buffer was not trimmed: %d frames left\n", sender.Unacked())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if a.lost() == 0 || b.lost() == 0 {
+		t.Errorf("Transport lost nothing: %d data, %d control datagrams\n", a.lost(), b.lost())
+	}
+}
+
+func TestReliableDatagram(t *testing.T) {
+	testReliableDelivery(t, syscall.SOCK_DGRAM)
+}
+
+func TestReliableSeqpacket(t *testing.T) {
+	testReliableDelivery(t, syscall.SOCK_SEQPACKET)
+}
+
+func TestReliableBoundedBuffer(t *testing.T) {
+	a, b := datagramPair(t, syscall.SOCK_DGRAM)
+	defer b.Close()
+	sender := NewReliableConn(a, 4, time.Hour)
+	defer sender.Close()
+
+	for i := 0; i < 4; i++ {
+		if _, err := sender.Send(&RenderRequest{Id: 1}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := make(chan uint32, 1)
+	go func() {
+		seq, _ := sender.Send(&RenderRequest{Id: 2}, 0)
+		sent <- seq
+	}()
+	select {
+	case <-sent:
+		t.Fatalf("Send did not block on full retransmit buffer\n")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Peer acknowledges two frames and asks for the rest
+	b.Write(appendFrame(nil, Frame{Msg: (&AckRequest{Seq: 2}).Encode()}))
+	select {
+	case seq := <-sent:
+		if seq != 5 {
+			t.Errorf("Sequence number: expected %d, got %d\n", 5, seq)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Send was not unblocked by ACK\n")
+	}
+	for i := 0; i < 3; i++ {
+		f, _ := parseFrame(readDatagram(t, b))
+		if f.Seq != uint32(i+1) {
+			t.Fatalf("Original frame %d: expected seq %d, got %d\n", i, i+1, f.Seq)
+		}
+	}
+	b.Write(appendFrame(nil, Frame{Msg: (&RepeatRequest{Seq: 4}).Encode()}))
+	// Frames 3 and 4 are repeated after original 4 and 5
+	var seqs []uint32
+	for i := 0; i < 4; i++ {
+		f, _ := parseFrame(readDatagram(t, b))
+		seqs = append(seqs, f.Seq)
+	}
+	expected := []uint32{4, 5, 4, 5}
+	for i := range expected {
+		if seqs[i] != expected[i] {
+			t.Fatalf("Datagrams after REPEAT: expected %v, got %v\n", expected, seqs)
+		}
+	}
+	if unacked := sender.Unacked(); unacked != 2 {
+		t.Errorf("Unacknowledged frames: expected %d, got %d\n", 2, unacked)
+	}
+}
+
+func readDatagram(t *testing.T, conn net.Conn) []uint8 {
+	buf := make([]uint8, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func TestReliableClose(t *testing.T) {
+	a, b := datagramPair(t, syscall.SOCK_SEQPACKET)
+	defer b.Close()
+	c := NewReliableConn(a, 1, time.Hour)
+	c.Send(&RenderRequest{Id: 1}, 0)
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := c.Send(&RenderRequest{Id: 1}, 0)
+		blocked <- err
+	}()
+	c.Close()
+	if err := <-blocked; err != ErrConnClosed {
+		t.Errorf("Expected ErrConnClosed from blocked Send, got %v\n", err)
+	}
+	if _, err := c.ReadFrame(); err != ErrConnClosed {
+		t.Errorf("Expected ErrConnClosed from ReadFrame, got %v\n", err)
+	}
+}
+
+func TestReliableOversizedFrame(t *testing.T) {
+	a, b := datagramPair(t, syscall.SOCK_DGRAM)
+	defer b.Close()
+	c := NewReliableConn(a, 4, time.Hour)
+	defer c.Close()
+	fill := &DrawFillRequest{Id: 1, Width: 1, Height: 100}
+	fill.Img = [][]Cell{make([]Cell, 100)}
+
+	c.MaxFrameSize = 1000
+	if _, err := c.Send(fill, 0); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Frame above limit: expected ErrFrameTooLarge, got %v\n", err)
+	}
+	if unacked := c.Unacked(); unacked != 0 {
+		t.Errorf("Rejected frame is buffered: %d unacknowledged frames\n", unacked)
+	}
+	if _, err := c.Send(&RenderRequest{Id: 1}, 0); err != nil {
+		t.Fatalf("Send after rejected frame: %v\n", err)
+	}
+
+	// Datagram above socket limit can never be written
+	c.MaxFrameSize = DefaultMaxFrameSize
+	fill.Height = 1 << 16
+	fill.Img = [][]Cell{make([]Cell, fill.Height)}
+	if _, err := c.Send(fill, 0); err == nil {
+		t.Fatalf("Expected write error for %d bytes datagram\n", len(fill.Encode()))
+	}
+	if unacked := c.Unacked(); unacked != 0 {
+		t.Errorf("Unwritable frame is kept: %d unacknowledged frames\n", unacked)
+	}
+	if _, err := c.Send(&RenderRequest{Id: 1}, 0); err == nil {
+		t.Errorf("Send after write error succeeded\n")
+	}
+}
+
+func TestReliableBoundedReceive(t *testing.T) {
+	a, b := datagramPair(t, syscall.SOCK_DGRAM)
+	sender := NewReliableConn(a, 16, 20*time.Millisecond)
+	defer sender.Close()
+	receiver := NewReliableConn(b, 4, time.Hour)
+	defer receiver.Close()
+
+	for i := 1; i <= 10; i++ {
+		if _, err := sender.Send(&RenderRequest{Id: ID(i)}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	receiver.mu.Lock()
+	received := len(receiver.received)
+	receiver.mu.Unlock()
+	if received != 4 {
+		t.Errorf("Unread frames: expected %d, got %d\n", 4, received)
+	}
+	if unacked := sender.Unacked(); unacked != 6 {
+		t.Errorf("Unacknowledged frames: expected %d, got %d\n", 6, unacked)
+	}
+	// Dropped frames are resent once app reads
+	for i := 1; i <= 10; i++ {
+		req, err := receiver.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id := req.(*RenderRequest).Id; id != ID(i) {
+			t.Fatalf("Frame %d: got window %d\n", i, id)
+		}
+	}
+}
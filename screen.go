@@ -0,0 +1,26 @@
+package fwsprotocol
+
+// Screen color space
+// (4 bytes)
+type OutputMode uint32
+
+const (
+	OutputCurrent   OutputMode = iota // Mode is unknown or left unchanged
+	OutputNormal                      // 8 basic colors and their bright variants
+	Output256                         // 256 color palette
+	Output216                         // 6x6x6 color cube of 256 color palette
+	OutputGrayscale                   // Black, white and gray ramp of 256 color palette
+	OutputRGB                         // 24 bit colors
+)
+
+// Terminal of window server.
+// Implemented by termbox adapter and ANSI writer, any Screen is a
+// compositor backend
+type Screen interface {
+	Size() (width, height int)
+	Mode() OutputMode
+	SetCell(x, y int, cell Cell) // Sets screen cell, may be buffered until Flush
+	Flush() error                // Shows cells set since previous flush
+	PollEvent() Event            // Waits for input event, EventError after Close
+	Close() error                // Restores terminal state
+}
@@ -0,0 +1,361 @@
+// FWS window server side library
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+// Time given to app to complete handshake
+const HandshakeTimeout = 5 * time.Second
+
+var (
+	ErrListenerClosed = errors.New("server: listener closed")
+	ErrNotSocket      = errors.New("server: file at socket path is not a socket")
+)
+
+// Window manager policy.
+// Library decodes requests, sends replies and checks that connection owns
+// the window it addresses, so callbacks are only called for valid requests.
+// Callbacks of one connection are called sequentially from its goroutine,
+// callbacks of different connections are called concurrently
+type Handler interface {
+	// Creates window and returns its ID, error drops the connection
+	OnNewWindow(c *Conn, req *fws.NewWindowRequest) (fws.ID, error)
+	// Returns cell of window for REPLY_GET
+	OnGet(c *Conn, req *fws.GetRequest) fws.Cell
+	OnDraw(c *Conn, req *fws.DrawRequest)
+	OnDrawFill(c *Conn, req *fws.DrawFillRequest)
+	// Rectangle may exceed window bounds and should be clipped
+	OnDrawRect(c *Conn, req *fws.DrawRectRequest)
+	OnLink(c *Conn, req *fws.LinkRequest)
+	OnRender(c *Conn, req *fws.RenderRequest)
+	OnResize(c *Conn, req *fws.ResizeRequest)
+	OnMove(c *Conn, req *fws.MoveRequest)
+	OnFocus(c *Conn, req *fws.FocusRequest)
+	OnUnfocus(c *Conn, req *fws.UnfocusRequest)
+	// Called for DELETE requests and for every window left on disconnect
+	OnDelete(c *Conn, req *fws.DeleteRequest)
+	// Returns screen description for REPLY_SCREEN
+	OnScreen(c *Conn, req *fws.ScreenRequest) fws.ReplyScreenRequest
+}
+
+// No-op Handler implementation to embed into partial handlers
+type BaseHandler struct{}
+
+func (BaseHandler) OnNewWindow(c *Conn, req *fws.NewWindowRequest) (fws.ID, error) {
+	return 0, errors.New("server: window creation is not supported")
+}
+func (BaseHandler) OnGet(c *Conn, req *fws.GetRequest) fws.Cell  { return fws.Cell{} }
+func (BaseHandler) OnDraw(c *Conn, req *fws.DrawRequest)         {}
+func (BaseHandler) OnDrawFill(c *Conn, req *fws.DrawFillRequest) {}
+func (BaseHandler) OnDrawRect(c *Conn, req *fws.DrawRectRequest) {}
+func (BaseHandler) OnLink(c *Conn, req *fws.LinkRequest)         {}
+func (BaseHandler) OnRender(c *Conn, req *fws.RenderRequest)     {}
+func (BaseHandler) OnResize(c *Conn, req *fws.ResizeRequest)     {}
+func (BaseHandler) OnMove(c *Conn, req *fws.MoveRequest)         {}
+func (BaseHandler) OnFocus(c *Conn, req *fws.FocusRequest)       {}
+func (BaseHandler) OnUnfocus(c *Conn, req *fws.UnfocusRequest)   {}
+func (BaseHandler) OnDelete(c *Conn, req *fws.DeleteRequest)     {}
+func (BaseHandler) OnScreen(c *Conn, req *fws.ScreenRequest) fws.ReplyScreenRequest {
+	return fws.ReplyScreenRequest{}
+}
+
+// Unix socket listener accepting FWS apps
+type Listener struct {
+	Caps fws.Capability // Capabilities offered during handshake
+	// Wraps accepted connections if set, e.g. to record their traffic
+	Wrap func(net.Conn) net.Conn
+
+	ln   net.Listener
+	path string
+
+	mu     sync.Mutex
+	conns  map[*Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Listens on path (FWS_SOCKET if empty).
+// Stale socket file left by a crashed server is removed, other files
+// at path are never touched
+func Listen(path string) (*Listener, error) {
+	if path == "" {
+		path = fws.FWS_SOCKET
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+		} else if fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		} else {
+			return nil, fmt.Errorf("%w: %s", ErrNotSocket, path)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{ln: ln, path: path, conns: make(map[*Conn]struct{})}, nil
+}
+
+// Socket address
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// Accepts connections and serves each one in its own goroutine
+// until listener is closed
+func (l *Listener) Serve(h Handler) error {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			l.mu.Lock()
+			closed := l.closed
+			l.mu.Unlock()
+			if closed {
+				return ErrListenerClosed
+			}
+			return err
+		}
+		if l.Wrap != nil {
+			conn = l.Wrap(conn)
+		}
+		c := newConn(conn)
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			return ErrListenerClosed
+		}
+		l.conns[c] = struct{}{}
+		l.wg.Add(1)
+		l.mu.Unlock()
+		go func() {
+			defer l.wg.Done()
+			c.serve(h, l.Caps)
+			l.mu.Lock()
+			delete(l.conns, c)
+			l.mu.Unlock()
+		}()
+	}
+}
+
+// Stops accepting, closes every connection and waits for their teardown
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	err := l.ln.Close()
+	for c := range l.conns {
+		c.Close()
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+	return err
+}
+
+// Connected app
+type Conn struct {
+	conn    net.Conn
+	enc     *fws.Encoder
+	dec     *fws.Decoder
+	session *fws.ReplyHelloRequest
+	unpack  *fws.Unpacker // Delta references of DRAW_PACKED images
+
+	mu      sync.Mutex
+	windows map[fws.ID]int // Owned windows and pids that created them
+}
+
+func newConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn:    conn,
+		enc:     fws.NewEncoder(conn),
+		dec:     fws.NewDecoder(conn),
+		unpack:  fws.NewUnpacker(),
+		windows: make(map[fws.ID]int),
+	}
+}
+
+// Negotiated protocol revision and capabilities
+func (c *Conn) Session() fws.ReplyHelloRequest {
+	return *c.session
+}
+
+// Sends message to app
+func (c *Conn) Send(req fws.Request) error {
+	return c.enc.Encode(req)
+}
+
+// Sends event to window, mouse coordinates should be window local
+func (c *Conn) SendEvent(id fws.ID, ev fws.Event) error {
+	return c.Send(&fws.EventRequest{Id: id, Event: ev})
+}
+
+// Reports whether window was created by this connection
+func (c *Conn) Owns(id fws.ID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.windows[id]
+	return ok
+}
+
+// Pid declared by app when it created window, 0 if window is not owned
+func (c *Conn) Pid(id fws.ID) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.windows[id]
+}
+
+// IDs of windows owned by connection in ascending order
+func (c *Conn) Windows() []fws.ID {
+	c.mu.Lock()
+	ids := make([]fws.ID, 0, len(c.windows))
+	for id := range c.windows {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Disconnects app, its windows are deleted by connection goroutine
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) serve(h Handler, caps fws.Capability) {
+	defer c.teardown(h)
+	c.conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	session, err := fws.ServerHandshake(c.enc, c.dec, caps)
+	if err != nil {
+		return
+	}
+	c.session = session
+	c.conn.SetDeadline(time.Time{})
+	for {
+		f, err := c.dec.ReadFrame()
+		if err != nil {
+			return
+		}
+		req, err := fws.DecodeMsg(f.Msg)
+		if err != nil {
+			// Message is framed, so the stream is still in sync
+			continue
+		}
+		if err := c.dispatch(h, req, f.Seq); err != nil {
+			return
+		}
+	}
+}
+
+// Deletes windows left by app
+func (c *Conn) teardown(h Handler) {
+	c.conn.Close()
+	for _, id := range c.Windows() {
+		c.forget(id)
+		h.OnDelete(c, &fws.DeleteRequest{Id: id})
+	}
+}
+
+func (c *Conn) forget(id fws.ID) {
+	c.mu.Lock()
+	delete(c.windows, id)
+	c.mu.Unlock()
+	c.unpack.Forget(id)
+}
+
+// Sends reply to request with specified sequence number
+func (c *Conn) reply(req fws.Request, seq uint32) error {
+	_, err := c.enc.Send(req, seq)
+	return err
+}
+
+// Handles request with specified sequence number, replies refer to it
+func (c *Conn) dispatch(h Handler, req fws.Request, seq uint32) error {
+	switch req := req.(type) {
+	case *fws.NewWindowRequest:
+		id, err := h.OnNewWindow(c, req)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.windows[id] = req.Pid
+		c.mu.Unlock()
+		return c.reply(&fws.ReplyCreationRequest{Id: id}, seq)
+	case *fws.GetRequest:
+		// App waits for reply, so foreign windows read as empty cell
+		var cell fws.Cell
+		if c.Owns(req.Id) {
+			cell = h.OnGet(c, req)
+		}
+		return c.reply(&fws.ReplyGetRequest{Id: req.Id, X: req.X, Y: req.Y, C: cell}, seq)
+	case *fws.ScreenRequest:
+		reply := h.OnScreen(c, req)
+		return c.reply(&reply, seq)
+	case *fws.DrawRequest:
+		if c.Owns(req.Id) {
+			h.OnDraw(c, req)
+		}
+	case *fws.DrawFillRequest:
+		if c.Owns(req.Id) {
+			h.OnDrawFill(c, req)
+		}
+	case *fws.PackedFillRequest:
+		// Handlers get packed images as plain DRAW_FILL
+		if c.Owns(req.Id) {
+			fill, err := c.unpack.Unpack(req)
+			if err != nil {
+				// App and server images diverged, later deltas can't be applied
+				c.unpack.Forget(req.Id)
+				return err
+			}
+			h.OnDrawFill(c, fill)
+		}
+	case *fws.DrawRectRequest:
+		if c.Owns(req.Id) {
+			h.OnDrawRect(c, req)
+		}
+	case *fws.DrawTextRequest:
+		// Handlers get text expanded into one row rectangle
+		if c.Owns(req.Id) {
+			h.OnDrawRect(c, req.Rect())
+		}
+	case *fws.LinkRequest:
+		if c.Owns(req.Id) {
+			h.OnLink(c, req)
+		}
+	case *fws.RenderRequest:
+		if c.Owns(req.Id) {
+			h.OnRender(c, req)
+		}
+	case *fws.ResizeRequest:
+		if c.Owns(req.Id) {
+			h.OnResize(c, req)
+		}
+	case *fws.MoveRequest:
+		if c.Owns(req.Id) {
+			h.OnMove(c, req)
+		}
+	case *fws.FocusRequest:
+		if c.Owns(req.Id) {
+			h.OnFocus(c, req)
+		}
+	case *fws.UnfocusRequest:
+		if c.Owns(req.Id) {
+			h.OnUnfocus(c, req)
+		}
+	case *fws.DeleteRequest:
+		if c.Owns(req.Id) {
+			c.forget(req.Id)
+			h.OnDelete(c, req)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+)
+
+// Handler recording calls and storing drawn cells
+type recordingHandler struct {
+	BaseHandler
+	mu      sync.Mutex
+	nextID  fws.ID
+	cells   map[fws.ID]fws.Cell
+	calls   []string
+	fills   []*fws.DrawFillRequest
+	deleted []fws.ID
+}
+
+func newRecordingHandler() *recordingHandler {
+	return &recordingHandler{nextID: 10, cells: make(map[fws.ID]fws.Cell)}
+}
+
+func (h *recordingHandler) record(call string) {
+	h.mu.Lock()
+	h.calls = append(h.calls, call)
+	h.mu.Unlock()
+}
+
+func (h *recordingHandler) OnNewWindow(c *Conn, req *fws.NewWindowRequest) (fws.ID, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	h.calls = append(h.calls, "new")
+	return h.nextID, nil
+}
+
+func (h *recordingHandler) OnGet(c *Conn, req *fws.GetRequest) fws.Cell {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cells[req.Id]
+}
+
+func (h *recordingHandler) OnDraw(c *Conn, req *fws.DrawRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cells[req.Id] = req.Cell
+	h.calls = append(h.calls, "draw")
+}
+
+func (h *recordingHandler) OnDrawFill(c *Conn, req *fws.DrawFillRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fills = append(h.fills, req)
+	h.calls = append(h.calls, "fill")
+}
+
+func (h *recordingHandler) OnDrawRect(c *Conn, req *fws.DrawRectRequest) { h.record("rect") }
+func (h *recordingHandler) OnLink(c *Conn, req *fws.LinkRequest)         { h.record("link") }
+func (h *recordingHandler) OnRender(c *Conn, req *fws.RenderRequest)     { h.record("render") }
+func (h *recordingHandler) OnMove(c *Conn, req *fws.MoveRequest)         { h.record("move") }
+
+func (h *recordingHandler) OnDelete(c *Conn, req *fws.DeleteRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deleted = append(h.deleted, req.Id)
+}
+
+func (h *recordingHandler) OnScreen(c *Conn, req *fws.ScreenRequest) fws.ReplyScreenRequest {
+	return fws.ReplyScreenRequest{Width: 80, Height: 25, Mode: fws.Output256}
+}
+
+func (h *recordingHandler) deletedWindows() []fws.ID {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]fws.ID(nil), h.deleted...)
+}
+
+func (h *recordingHandler) receivedFills() []*fws.DrawFillRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*fws.DrawFillRequest(nil), h.fills...)
+}
+
+func (h *recordingHandler) recordedCalls() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.calls...)
+}
+
+func listen(t *testing.T, h Handler) *Listener {
+	l, err := Listen(filepath.Join(t.TempDir(), "fws.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Caps = fws.CapMouse
+	go l.Serve(h)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// Raw protocol app
+type app struct {
+	conn net.Conn
+	enc  *fws.Encoder
+	dec  *fws.Decoder
+}
+
+func connect(t *testing.T, l *Listener) *app {
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	a := &app{conn, fws.NewEncoder(conn), fws.NewDecoder(conn)}
+	if _, err := fws.ClientHandshake(a.enc, a.dec, fws.CapMouse|fws.CapClipboard); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func (a *app) request(t *testing.T, req fws.Request) fws.Request {
+	seq, err := a.enc.Send(req, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := a.dec.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Ref != seq {
+		t.Errorf("Reply reference: expected %d, got %d\n", seq, f.Ref)
+	}
+	reply, err := fws.DecodeMsg(f.Msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func (a *app) newWindow(t *testing.T) fws.ID {
+	reply := a.request(t, &fws.NewWindowRequest{Pid: 42, Width: 10, Height: 5})
+	created, ok := reply.(*fws.ReplyCreationRequest)
+	if !ok {
+		t.Fatalf("Expected creation reply, got %v\n", reply)
+	}
+	return created.Id
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s\n", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRequestDispatch(t *testing.T) {
+	h := newRecordingHandler()
+	l := listen(t, h)
+	a := connect(t, l)
+	id := a.newWindow(t)
+	if id != 11 {
+		t.Errorf("Window ID: expected %d, got %d\n", 11, id)
+	}
+
+	cell := fws.Cell{Ch: 'q', Fg: fws.Color{A: 255, R: 1}}
+	a.enc.Encode(&fws.DrawRequest{Id: id, X: 1, Y: 2, Cell: cell})
+	a.enc.Encode(&fws.DrawRectRequest{Id: id, X: 8, Y: 4, Width: 2, Height: 1, Img: [][]fws.Cell{{cell}, {cell}}})
+	a.enc.Encode(&fws.DrawTextRequest{Id: id, X: 1, Y: 3, Text: "label"})
+	a.enc.Encode(&fws.LinkRequest{Id: id, Link: 1, URI: "file:///"})
+	a.enc.Encode(&fws.MoveRequest{Id: id, X: 3, Y: 4})
+	a.enc.Encode(&fws.RenderRequest{Id: id})
+	reply := a.request(t, &fws.GetRequest{Id: id, X: 1, Y: 2})
+	if get, ok := reply.(*fws.ReplyGetRequest); !ok || get.Id != id || get.X != 1 || get.Y != 2 || get.C != cell {
+		t.Errorf("Expected get reply with %v, got %v\n", cell, reply)
+	}
+	reply = a.request(t, &fws.ScreenRequest{})
+	if screen, ok := reply.(*fws.ReplyScreenRequest); !ok || screen.Width != 80 || screen.Mode != fws.Output256 {
+		t.Errorf("Expected screen reply, got %v\n", reply)
+	}
+
+	calls := h.recordedCalls()
+	expected := []string{"new", "draw", "rect", "rect", "link", "move", "render"}
+	if len(calls) != len(expected) {
+		t.Fatalf("Handler calls: expected %v, got %v\n", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("Handler call %d: expected %s, got %s\n", i, expected[i], calls[i])
+		}
+	}
+}
+
+func TestForeignWindowIgnored(t *testing.T) {
+	h := newRecordingHandler()
+	l := listen(t, h)
+	owner := connect(t, l)
+	intruder := connect(t, l)
+	id := owner.newWindow(t)
+
+	intruder.enc.Encode(&fws.DrawRequest{Id: id, Cell: fws.Cell{Ch: 'x'}})
+	intruder.enc.Encode(&fws.DeleteRequest{Id: id})
+	reply := intruder.request(t, &fws.GetRequest{Id: id})
+	if get, ok := reply.(*fws.ReplyGetRequest); !ok || get.C != (fws.Cell{}) {
+		t.Errorf("Expected empty get reply, got %v\n", reply)
+	}
+	for _, call := range h.recordedCalls() {
+		if call == "draw" {
+			t.Errorf("Draw into foreign window reached handler\n")
+		}
+	}
+	if deleted := h.deletedWindows(); len(deleted) != 0 {
+		t.Errorf("Foreign window was deleted: %v\n", deleted)
+	}
+}
+
+func TestMalformedMessageSkipped(t *testing.T) {
+	h := newRecordingHandler()
+	l := listen(t, h)
+	a := connect(t, l)
+	a.enc.WriteMsg(fws.Msg{uint8(fws.DRAW), 1, 2})
+	a.enc.WriteMsg(fws.Msg{0xFF})
+	if id := a.newWindow(t); id == 0 {
+		t.Errorf("Connection is broken after malformed messages\n")
+	}
+}
+
+func TestPackedFillUnpacked(t *testing.T) {
+	h := newRecordingHandler()
+	l := listen(t, h)
+	a := connect(t, l)
+	id := a.newWindow(t)
+
+	img := [][]fws.Cell{{{Ch: 'a'}, {Ch: 'b'}}, {{Ch: 'a'}, {Ch: 'a'}}}
+	p := fws.NewPacker()
+	all := fws.EncodingRLE | fws.EncodingPalette | fws.EncodingDelta
+	a.enc.Encode(p.Pack(&fws.DrawFillRequest{Id: id, Width: 2, Height: 2, Img: img}, all))
+	img[1][1].Ch = 'c'
+	a.enc.Encode(p.Pack(&fws.DrawFillRequest{Id: id, Width: 2, Height: 2, Img: img}, all))
+	a.request(t, &fws.ScreenRequest{})
+
+	fills := h.receivedFills()
+	if len(fills) != 2 {
+		t.Fatalf("Handler fills: expected %d, got %d\n", 2, len(fills))
+	}
+	if got := fills[0].Img[1][1].Ch; got != 'a' {
+		t.Errorf("First image cell: expected %q, got %q\n", 'a', got)
+	}
+	if got := fills[1].Img[1][1].Ch; got != 'c' || fills[1].Img[0][1].Ch != 'b' {
+		t.Errorf("Delta image cells: expected 'b' and 'c', got %q and %q\n", fills[1].Img[0][1].Ch, got)
+	}
+}
+
+func TestBadPackedFillDrops(t *testing.T) {
+	h := newRecordingHandler()
+	l := listen(t, h)
+	a := connect(t, l)
+	id := a.newWindow(t)
+	a.enc.Encode(fws.NewPacker().Pack(&fws.DrawFillRequest{Id: id, Width: 1, Height: 1, Img: [][]fws.Cell{{{Ch: 'a'}}}}, 0))
+	// Delta against image of other size can't be applied
+	a.enc.Encode(&fws.PackedFillRequest{Id: id, Width: 3, Height: 3, Encoding: fws.EncodingDelta})
+
+	waitUntil(t, "connection drop", func() bool { return len(h.deletedWindows()) == 1 })
+	if fills := h.receivedFills(); len(fills) != 1 {
+		t.Errorf("Handler fills: expected %d, got %d\n", 1, len(fills))
+	}
+}
+
+func TestDisconnectDeletesWindows(t *testing.T) {
+	h := newRecordingHandler()
+	l := listen(t, h)
+	a := connect(t, l)
+	first := a.newWindow(t)
+	second := a.newWindow(t)
+	third := a.newWindow(t)
+	a.request(t, &fws.ScreenRequest{})
+	a.enc.Encode(&fws.DeleteRequest{Id: second})
+	a.request(t, &fws.ScreenRequest{})
+	a.conn.Close()
+
+	waitUntil(t, "window teardown", func() bool { return len(h.deletedWindows()) == 3 })
+	deleted := h.deletedWindows()
+	expected := []fws.ID{second, first, third}
+	for i := range expected {
+		if deleted[i] != expected[i] {
+			t.Errorf("Deleted window %d: expected %d, got %d\n", i, expected[i], deleted[i])
+		}
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+	h := newRecordingHandler()
+	l, err := Listen(filepath.Join(t.TempDir(), "fws.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- l.Serve(h) }()
+	a := connect(t, l)
+	id := a.newWindow(t)
+
+	l.Close()
+	if err := <-served; err != ErrListenerClosed {
+		t.Errorf("Expected ErrListenerClosed, got %v\n", err)
+	}
+	if deleted := h.deletedWindows(); len(deleted) != 1 || deleted[0] != id {
+		t.Errorf("Expected window %d deleted on close, got %v\n", id, deleted)
+	}
+}
+
+func TestListenRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fws.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave socket file behind as crashed server would
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Listen on stale socket failed: %v\n", err)
+	}
+	l.Close()
+}
+
+func TestListenKeepsRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fws.sock")
+	if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(path); !errors.Is(err, ErrNotSocket) {
+		t.Errorf("Listen on regular file: expected ErrNotSocket, got %v\n", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("Regular file is changed: got %q, %v\n", data, err)
+	}
+}
+
+// Connection counting bytes read by server
+type countingConn struct {
+	net.Conn
+	mu   *sync.Mutex
+	read *int
+}
+
+func (c countingConn) Read(b []uint8) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	*c.read += n
+	c.mu.Unlock()
+	return n, err
+}
+
+func TestListenerWrap(t *testing.T) {
+	var mu sync.Mutex
+	read := 0
+	l, err := Listen(filepath.Join(t.TempDir(), "fws.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Wrap = func(conn net.Conn) net.Conn {
+		return countingConn{conn, &mu, &read}
+	}
+	go l.Serve(newRecordingHandler())
+	defer l.Close()
+	a := connect(t, l)
+	a.newWindow(t)
+	mu.Lock()
+	defer mu.Unlock()
+	// HELLO and NEW frames
+	if expected := 2*12 + len((&fws.HelloRequest{}).Encode()) + len((&fws.NewWindowRequest{}).Encode()); read != expected {
+		t.Errorf("Bytes read through wrapped connection: expected %d, got %d\n", expected, read)
+	}
+}
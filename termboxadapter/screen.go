@@ -0,0 +1,94 @@
+package termboxadapter
+
+import (
+	"errors"
+	"sync"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/nsf/termbox-go"
+)
+
+var ErrClosed = errors.New("termboxadapter: screen closed")
+
+// Termbox terminal as FWS screen.
+// Termbox is global, so only one Screen may be open at a time
+type Screen struct {
+	mode termbox.OutputMode
+
+	mu      sync.Mutex
+	polling bool // PollEvent waits for termbox event
+	closed  bool
+}
+
+var _ fws.Screen = (*Screen)(nil)
+
+// Initializes termbox with mouse input and requested output mode,
+// which may be downgraded by termbox
+func Open(mode fws.OutputMode) (*Screen, error) {
+	if err := termbox.Init(); err != nil {
+		return nil, err
+	}
+	termbox.SetInputMode(termbox.InputEsc | termbox.InputMouse)
+	return &Screen{mode: termbox.SetOutputMode(TermboxOutputMode(mode))}, nil
+}
+
+func (s *Screen) Size() (int, int) {
+	return termbox.Size()
+}
+
+func (s *Screen) Mode() fws.OutputMode {
+	return OutputMode(s.mode)
+}
+
+// Continuation cells are skipped, termbox draws wide runes over two cells
+func (s *Screen) SetCell(x, y int, cell fws.Cell) {
+	if cell.Continuation {
+		return
+	}
+	tb := TermboxCell(cell, s.mode)
+	termbox.SetCell(x, y, tb.Ch, tb.Fg, tb.Bg)
+}
+
+func (s *Screen) Flush() error {
+	return termbox.Flush()
+}
+
+func (s *Screen) PollEvent() fws.Event {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return fws.Event{Type: fws.EventError, Err: ErrClosed}
+	}
+	s.polling = true
+	s.mu.Unlock()
+	ev := termbox.PollEvent()
+	s.mu.Lock()
+	s.polling = false
+	closed := s.closed
+	s.mu.Unlock()
+	if !closed {
+		return Event(ev)
+	}
+	// Close waits until its interrupt is received
+	for ev.Type != termbox.EventInterrupt {
+		ev = termbox.PollEvent()
+	}
+	return fws.Event{Type: fws.EventError, Err: ErrClosed}
+}
+
+// Interrupts PollEvent and restores terminal
+func (s *Screen) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	polling := s.polling
+	s.mu.Unlock()
+	if polling {
+		termbox.Interrupt()
+	}
+	termbox.Close()
+	return nil
+}
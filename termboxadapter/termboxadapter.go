@@ -0,0 +1,139 @@
+// Conversions between FWS and termbox types
+// and termbox implementation of FWS Screen
+package termboxadapter
+
+import (
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/nsf/termbox-go"
+)
+
+func Event(ev termbox.Event) fws.Event {
+	return fws.Event{
+		Type:   fws.EventType(ev.Type),
+		Mod:    fws.Modifier(ev.Mod),
+		Key:    fws.Key(ev.Key),
+		Ch:     ev.Ch,
+		Width:  ev.Width,
+		Height: ev.Height,
+		Err:    ev.Err,
+		MouseX: ev.MouseX,
+		MouseY: ev.MouseY,
+		N:      ev.N,
+	}
+}
+
+func TermboxEvent(ev fws.Event) termbox.Event {
+	return termbox.Event{
+		Type:   termbox.EventType(ev.Type),
+		Mod:    termbox.Modifier(ev.Mod),
+		Key:    termbox.Key(ev.Key),
+		Ch:     ev.Ch,
+		Width:  ev.Width,
+		Height: ev.Height,
+		Err:    ev.Err,
+		MouseX: ev.MouseX,
+		MouseY: ev.MouseY,
+		N:      ev.N,
+	}
+}
+
+func OutputMode(mode termbox.OutputMode) fws.OutputMode {
+	return fws.OutputMode(mode)
+}
+
+func TermboxOutputMode(mode fws.OutputMode) termbox.OutputMode {
+	return termbox.OutputMode(mode)
+}
+
+// Attributes and their termbox counterparts
+var attrs = [...]struct {
+	attr fws.Attr
+	tb   termbox.Attribute
+}{
+	{fws.Bold, termbox.AttrBold},
+	{fws.Blink, termbox.AttrBlink},
+	{fws.Hidden, termbox.AttrHidden},
+	{fws.Dim, termbox.AttrDim},
+	{fws.Underline, termbox.AttrUnderline},
+	{fws.Cursive, termbox.AttrCursive},
+	{fws.Reverse, termbox.AttrReverse},
+}
+
+// Attributes set in termbox attribute, color bits are ignored
+func Attr(attr termbox.Attribute) fws.Attr {
+	var a fws.Attr
+	for _, m := range attrs {
+		if attr&m.tb != 0 {
+			a |= m.attr
+		}
+	}
+	return a
+}
+
+// Termbox attribute bits without color,
+// attributes termbox has no counterpart for are dropped
+func TermboxAttr(a fws.Attr) termbox.Attribute {
+	var attr termbox.Attribute
+	for _, m := range attrs {
+		if a&m.attr != 0 {
+			attr |= m.tb
+		}
+	}
+	return attr
+}
+
+// Color of OutputRGB attribute
+func Color(attr termbox.Attribute) fws.Color {
+	r, g, b := termbox.AttributeToRGB(attr)
+	return fws.Color{A: 255, R: r, G: g, B: b}
+}
+
+// Termbox attribute of nearest color for output mode.
+// Fully transparent color leaves terminal default color
+func TermboxColor(c fws.Color, mode termbox.OutputMode) termbox.Attribute {
+	if c.A == 0 {
+		return termbox.ColorDefault
+	}
+	n := c.Index(OutputMode(mode))
+	switch mode {
+	case termbox.OutputRGB:
+		return termbox.RGBToAttribute(c.R, c.G, c.B)
+	case termbox.Output216:
+		return termbox.Attribute(n - 16 + 1)
+	case termbox.OutputGrayscale:
+		switch n {
+		case 16:
+			return 1
+		case 231:
+			return 26
+		}
+		return termbox.Attribute(n - 232 + 2)
+	case termbox.Output256:
+		return termbox.Attribute(n + 1)
+	default:
+		// OutputNormal colors and their bright variants
+		return termbox.ColorBlack + termbox.Attribute(c.To16Mode())
+	}
+}
+
+// Cell of termbox cell with OutputRGB colors,
+// attributes are collected from both colors
+func Cell(cell termbox.Cell) fws.Cell {
+	return fws.Cell{
+		Ch:        cell.Ch,
+		Fg:        Color(cell.Fg),
+		Bg:        Color(cell.Bg),
+		Attribute: Attr(cell.Fg) | Attr(cell.Bg),
+	}
+}
+
+// Termbox cell for output mode. Attributes are set on foreground, where
+// termbox looks for them, reverse is set on background as well.
+// Grapheme clusters are reduced to their first rune
+func TermboxCell(cell fws.Cell, mode termbox.OutputMode) termbox.Cell {
+	attr := TermboxAttr(cell.Attribute)
+	return termbox.Cell{
+		Ch: cell.Ch,
+		Fg: TermboxColor(cell.Fg, mode) | attr,
+		Bg: TermboxColor(cell.Bg, mode) | attr&termbox.AttrReverse}
+}
@@ -0,0 +1,118 @@
+package termboxadapter
+
+import (
+	"errors"
+	"testing"
+
+	fws "github.com/Nekhaevalex/fwsprotocol"
+	"github.com/nsf/termbox-go"
+)
+
+// Protocol values are sent on the wire, they must stay equal to termbox ones
+func TestConstants(t *testing.T) {
+	keys := map[fws.Key]termbox.Key{
+		fws.KeyF1:          termbox.KeyF1,
+		fws.KeyF12:         termbox.KeyF12,
+		fws.KeyInsert:      termbox.KeyInsert,
+		fws.KeyArrowRight:  termbox.KeyArrowRight,
+		fws.MouseLeft:      termbox.MouseLeft,
+		fws.MouseRelease:   termbox.MouseRelease,
+		fws.MouseWheelDown: termbox.MouseWheelDown,
+		fws.KeyCtrlA:       termbox.KeyCtrlA,
+		fws.KeyEsc:         termbox.KeyEsc,
+		fws.KeySpace:       termbox.KeySpace,
+		fws.KeyBackspace2:  termbox.KeyBackspace2,
+	}
+	for k, tb := range keys {
+		if uint16(k) != uint16(tb) {
+			t.Errorf("Key %#x: termbox value %#x\n", k, tb)
+		}
+	}
+	if fws.EventNone != fws.EventType(termbox.EventNone) || fws.EventMouse != fws.EventType(termbox.EventMouse) {
+		t.Errorf("Event types differ from termbox\n")
+	}
+	if fws.ModMotion != fws.Modifier(termbox.ModMotion) {
+		t.Errorf("Modifiers differ from termbox\n")
+	}
+	if fws.OutputRGB != OutputMode(termbox.OutputRGB) || fws.OutputGrayscale != OutputMode(termbox.OutputGrayscale) {
+		t.Errorf("Output modes differ from termbox\n")
+	}
+}
+
+func TestEvent(t *testing.T) {
+	ev := termbox.Event{Type: termbox.EventMouse, Mod: termbox.ModMotion, Key: termbox.MouseLeft, MouseX: 3, MouseY: 4, Err: errors.New("e")}
+	if got := TermboxEvent(Event(ev)); got != ev {
+		t.Errorf("Event round trip: expected %v, got %v\n", ev, got)
+	}
+}
+
+func TestTermboxColor(t *testing.T) {
+	var (
+		black = fws.Color{A: 255}
+		white = fws.Color{A: 255, R: 255, G: 255, B: 255}
+		red   = fws.Color{A: 255, R: 255}
+		gray  = fws.Color{A: 255, R: 128, G: 128, B: 128}
+		none  = fws.Color{R: 255}
+	)
+	tests := []struct {
+		mode     termbox.OutputMode
+		color    fws.Color
+		expected termbox.Attribute
+	}{
+		{termbox.OutputNormal, black, termbox.ColorBlack},
+		{termbox.OutputNormal, white, termbox.ColorLightGray},
+		{termbox.OutputNormal, red, termbox.ColorLightRed},
+		{termbox.OutputNormal, gray, termbox.ColorDarkGray},
+		{termbox.Output256, black, 17},
+		{termbox.Output256, red, 197},
+		{termbox.Output256, gray, 245},
+		{termbox.Output216, black, 1},
+		{termbox.Output216, white, 216},
+		{termbox.Output216, red, 181},
+		{termbox.OutputGrayscale, black, 1},
+		{termbox.OutputGrayscale, white, 26},
+		{termbox.OutputGrayscale, gray, 14},
+		{termbox.OutputRGB, red, termbox.RGBToAttribute(255, 0, 0)},
+	}
+	for _, test := range tests {
+		if got := TermboxColor(test.color, test.mode); got != test.expected {
+			t.Errorf("Mode %d color %v: expected %d, got %d\n", test.mode, test.color, test.expected, got)
+		}
+		if got := TermboxColor(none, test.mode); got != termbox.ColorDefault {
+			t.Errorf("Mode %d transparent color: got %d\n", test.mode, got)
+		}
+	}
+}
+
+func TestAttrRoundTrip(t *testing.T) {
+	all := fws.Bold | fws.Blink | fws.Hidden | fws.Dim | fws.Underline | fws.Cursive | fws.Reverse
+	for _, mode := range []termbox.OutputMode{termbox.OutputNormal, termbox.Output256, termbox.OutputRGB} {
+		for a := fws.Attr(0); a <= all>>9; a++ {
+			cell := fws.Cell{Ch: 'a', Fg: fws.Color{A: 255, R: 255, G: 255, B: 255}, Bg: fws.Color{A: 255, B: 255}, Attribute: a << 9}
+			tb := TermboxCell(cell, mode)
+			if got := Attr(tb.Fg); got != cell.Attribute {
+				t.Errorf("Mode %d: foreground attributes: expected %b, got %b\n", mode, cell.Attribute, got)
+			}
+			if got := Cell(tb).Attribute; got != cell.Attribute {
+				t.Errorf("Mode %d: cell attributes: expected %b, got %b\n", mode, cell.Attribute, got)
+			}
+			if (tb.Bg&termbox.AttrReverse != 0) != (cell.Attribute&fws.Reverse != 0) {
+				t.Errorf("Mode %d: background reverse of %b: got %b\n", mode, cell.Attribute, tb.Bg)
+			}
+		}
+	}
+	// Termbox applies reverse set on background only
+	if got := Cell(termbox.Cell{Ch: 'a', Bg: termbox.ColorRed | termbox.AttrReverse}).Attribute; got != fws.Reverse {
+		t.Errorf("Background reverse: expected %b, got %b\n", fws.Reverse, got)
+	}
+	if got := Attr(termbox.RGBToAttribute(255, 255, 255)); got != 0 {
+		t.Errorf("Color bits decoded as attributes: got %b\n", got)
+	}
+	if got := TermboxAttr(fws.Strikethrough | fws.CurlyUnderline | fws.Underline); got != termbox.AttrUnderline {
+		t.Errorf("Extended attributes: expected %b, got %b\n", termbox.AttrUnderline, got)
+	}
+	rgb := fws.Color{A: 255, R: 1, G: 2, B: 3}
+	if got := Color(TermboxColor(rgb, termbox.OutputRGB) | termbox.AttrBold); got != rgb {
+		t.Errorf("RGB color round trip: expected %v, got %v\n", rgb, got)
+	}
+}